@@ -102,6 +102,7 @@ const (
 	extensionSignatureAlgorithmsCert uint16 = 50
 	extensionKeyShare                uint16 = 51
 	extensionRenegotiationInfo       uint16 = 0xff01
+	extensionFillGhost               uint16 = 0xff5a // 私有使用范围，协商 FillGhost 支持，extension_data 为空
 )
 
 // TLS signaling cipher suite values
@@ -725,6 +726,33 @@ type Config struct {
 	// used for debugging.
 	KeyLogWriter io.Writer
 
+	// AcceptFillGhost 为 true 时，TLS 1.3 连接的 Read 静默丢弃内层内容类型为
+	// FillGhostContentType 的记录（对端以 FillGhostConfig.MarkInnerType 注入），
+	// 否则按标准以 unexpected_message 断开
+	AcceptFillGhost bool
+
+	// NegotiateFillGhost 为 true 时在握手中以私有扩展协商 FillGhost 支持：客户端在
+	// ClientHello 中声明，服务端自身也开启时在 ServerHello（TLS 1.2）或
+	// EncryptedExtensions（TLS 1.3）中确认。结果见 Conn.FillGhostNegotiated
+	NegotiateFillGhost bool
+
+	// FillGhost 非 nil 时，每个 Conn 在首次握手成功后按该配置的副本自动创建并启动控制器，
+	// 赋给 Conn.FillGhostController 以便查看统计或暂停，Conn.Close 时自动停止。
+	// 启动失败（如配置不合法）不影响握手，错误见 FillGhostController.Err()
+	FillGhost *FillGhostConfig
+
+	// FillGhostClient/FillGhostServer 按连接所在的一端取代 FillGhost：客户端（Client、Dial）的连接
+	// 使用 FillGhostClient，服务端（Server、Listener）的连接使用 FillGhostServer，为 nil 时回退到
+	// FillGhost。两个方向的流量形态通常不同（小请求、大响应），同一 Config 用于两端时可分别配置
+	FillGhostClient *FillGhostConfig
+	FillGhostServer *FillGhostConfig
+
+	// PaddingPolicy 非 nil 时，TLS 1.3 连接写出的每条真实应用数据记录在内层类型之后追加
+	// PaddingPolicy(明文长度) 个零字节（RFC 8446 5.4），对端解密时自动去除，Read 只返回原始数据。
+	// 返回值小于 0 视为 0，超出单条记录余量时截断，记录明文加填充不超过 2^14 字节；
+	// TLS 1.2、握手与告警记录不填充，注入的填充记录也不受影响。在写方向锁内调用，不得再写 Conn
+	PaddingPolicy func(plaintextLen int) int
+
 	// mutex protects sessionTicketKeys and autoSessionTicketKeys.
 	mutex sync.RWMutex
 	// sessionTicketKeys contains zero or more ticket keys. If set, it means the
@@ -814,6 +842,12 @@ func (c *Config) Clone() *Config {
 		DynamicRecordSizingDisabled: c.DynamicRecordSizingDisabled,
 		Renegotiation:               c.Renegotiation,
 		KeyLogWriter:                c.KeyLogWriter,
+		AcceptFillGhost:             c.AcceptFillGhost,
+		NegotiateFillGhost:          c.NegotiateFillGhost,
+		FillGhost:                   c.FillGhost,
+		FillGhostClient:             c.FillGhostClient,
+		FillGhostServer:             c.FillGhostServer,
+		PaddingPolicy:               c.PaddingPolicy,
 		sessionTicketKeys:           c.sessionTicketKeys,
 		autoSessionTicketKeys:       c.autoSessionTicketKeys,
 	}
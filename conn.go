@@ -17,6 +17,7 @@ import (
 	"hash"
 	"io"
 	"net"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -119,6 +120,49 @@ type Conn struct {
 
 	FillGhostController *FillGhostController // FillGhost控制器
 	FillGhostEnabled    bool                 // 是否启用FillGhost自动注入
+
+	// fillGhostWriteMu 保护以下三项；不用 out 锁，阻塞中的 Write 不会挡住注入循环读取
+	fillGhostWriteMu   sync.Mutex
+	fillGhostLastWrite time.Time      // 最近一次真实 Write 的时间
+	fillGhostClock     FillGhostClock // 为真实 Write 打时间戳的时钟，nil 为系统时钟
+	fillGhostRealBytes int64          // 真实 Write 写出的应用数据字节数
+
+	fillGhostStripMagic []byte // 非空时丢弃以此开头的应用数据记录，受 in 锁保护
+	fillGhostStripKey   []byte // 非空时丢弃标记校验通过的应用数据记录，受 in 锁保护
+	fillGhostNegotiated bool   // 双方在握手中协商了 FillGhost 支持，受 handshakeMutex 保护
+
+	// fillGhostAutoOnce 保证按 Config.FillGhost 只创建一次控制器，fillGhostAuto 为该控制器，
+	// Close 时停止；用户自行赋给 FillGhostController 的控制器不受影响
+	fillGhostAutoOnce sync.Once
+	fillGhostAuto     *FillGhostController
+
+	// fillGhostSizeMu 保护最近 fillGhostWriteSizeWindow 条真实应用数据记录的明文长度，
+	// 供 FillGhostConfig.Learn 与 Mirror 取样；不用 out 锁，阻塞中的 Write 不会挡住取样
+	fillGhostSizeMu    sync.Mutex
+	fillGhostSizes     [fillGhostWriteSizeWindow]uint16
+	fillGhostSizeCount uint64 // 累计记录数，对窗口长度取模即下一个写入位置
+
+	// fillGhostKeyMu 保护 fillGhostKey，即由导出器派生、收发两侧共用的标记密钥
+	fillGhostKeyMu sync.Mutex
+	fillGhostKey   []byte
+
+	// fillGhostRecvMu 保护 fillGhostRecv；不用 in 锁，阻塞中的 Read 不会挡住统计查询
+	fillGhostRecvMu sync.Mutex
+	fillGhostRecv   FillGhostRecvStats
+
+	// fillGhostCloseCh 在 Close 时关闭，唤醒等待中的注入循环；首次使用时创建
+	fillGhostCloseOnce sync.Once
+	fillGhostCloseCh   chan struct{}
+
+	// fillGhostDeadlineMu 保护应用经 SetDeadline/SetWriteDeadline 设置的写截止时间，
+	// 以及注入被中断期间底层连接的写截止时间是否由 FillGhost 占用；占用期间应用设置的值
+	// 只记录下来，释放时恢复
+	fillGhostDeadlineMu    sync.Mutex
+	fillGhostWriteDeadline time.Time
+	fillGhostDeadlineHeld  bool
+
+	// fillGhostWriting 进行中的注入写操作数，这些写操作同样计入 activeCall，原子访问
+	fillGhostWriting int32
 }
 
 // Access to net.Conn methods.
@@ -139,6 +183,12 @@ func (c *Conn) RemoteAddr() net.Addr {
 // A zero value for t means Read and Write will not time out.
 // After a Write has timed out, the TLS state is corrupt and all future writes will return the same error.
 func (c *Conn) SetDeadline(t time.Time) error {
+	c.fillGhostDeadlineMu.Lock()
+	defer c.fillGhostDeadlineMu.Unlock()
+	c.fillGhostWriteDeadline = t
+	if c.fillGhostDeadlineHeld {
+		return c.conn.SetReadDeadline(t)
+	}
 	return c.conn.SetDeadline(t)
 }
 
@@ -152,6 +202,12 @@ func (c *Conn) SetReadDeadline(t time.Time) error {
 // A zero value for t means Write will not time out.
 // After a Write has timed out, the TLS state is corrupt and all future writes will return the same error.
 func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.fillGhostDeadlineMu.Lock()
+	defer c.fillGhostDeadlineMu.Unlock()
+	c.fillGhostWriteDeadline = t
+	if c.fillGhostDeadlineHeld {
+		return nil
+	}
 	return c.conn.SetWriteDeadline(t)
 }
 
@@ -175,6 +231,8 @@ type halfConn struct {
 
 	scratchBuf [13]byte // to avoid allocs; interface method args escape
 
+	fillGhostPad int // 下一条 TLS 1.3 记录追加的零填充字节数，encrypt 后清零
+
 	nextCipher any       // next encryption state
 	nextMac    hash.Hash // next MAC algorithm
 
@@ -519,7 +577,14 @@ func (hc *halfConn) encrypt(record, payload []byte, rand io.Reader) ([]byte, err
 			record = append(record, record[0])
 			record[0] = byte(recordTypeApplicationData)
 
-			n := len(payload) + 1 + c.Overhead()
+			// Config.PaddingPolicy 的零填充放在内层类型之后（RFC 8446 5.4）
+			var pad []byte
+			record, pad = sliceForAppend(record, hc.fillGhostPad)
+			for i := range pad {
+				pad[i] = 0
+			}
+
+			n := len(payload) + 1 + len(pad) + c.Overhead()
 			record[3] = byte(n >> 8)
 			record[4] = byte(n)
 
@@ -554,6 +619,7 @@ func (hc *halfConn) encrypt(record, payload []byte, rand io.Reader) ([]byte, err
 	record[3] = byte(n >> 8)
 	record[4] = byte(n)
 	hc.incSeq()
+	hc.fillGhostPad = 0
 
 	return record, nil
 }
@@ -668,6 +734,7 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 
 	// Process message.
 	record := c.rawInput.Next(recordHeaderLen + n)
+	seq := c.in.seq // 解密前的读序号，校验填充记录标记时使用
 	data, typ, err := c.in.decrypt(record)
 	if err != nil {
 		return c.in.setErrorLocked(c.sendAlert(err.(alert)))
@@ -691,6 +758,15 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 		return c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
 	}
 
+	// 对端以 FillGhostContentType 标记的填充记录直接丢弃，Read 会继续读取下一条记录
+	if typ == recordType(FillGhostContentType) && c.vers == VersionTLS13 && handshakeComplete && c.config.AcceptFillGhost {
+		c.fillGhostRecvMu.Lock()
+		c.fillGhostRecv.Records++
+		c.fillGhostRecv.Bytes += uint64(len(data))
+		c.fillGhostRecvMu.Unlock()
+		return nil
+	}
+
 	switch typ {
 	default:
 		return c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
@@ -747,6 +823,22 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 		if len(data) == 0 {
 			return c.retryReadRecord(expectChangeCipherSpec)
 		}
+		// 对端注入的填充记录不交给应用；Read 会继续读取下一条记录
+		if len(c.fillGhostStripMagic) > 0 && bytes.HasPrefix(data, c.fillGhostStripMagic) {
+			c.fillGhostRecvMu.Lock()
+			c.fillGhostRecv.Records++
+			c.fillGhostRecv.Bytes += uint64(len(data))
+			c.fillGhostRecvMu.Unlock()
+			return nil
+		}
+		// 标记校验失败的记录照常交给应用
+		if c.fillGhostStripKey != nil && fillGhostTagged(data, c.fillGhostStripKey, seq) {
+			c.fillGhostRecvMu.Lock()
+			c.fillGhostRecv.Records++
+			c.fillGhostRecv.Bytes += uint64(len(data))
+			c.fillGhostRecvMu.Unlock()
+			return nil
+		}
 		// Note that data is owned by c.rawInput, following the Next call above,
 		// to avoid copying the plaintext. This is safe because c.rawInput is
 		// not read from or written to until c.input is drained.
@@ -965,6 +1057,9 @@ func (c *Conn) writeRecordLocked(typ recordType, data []byte) (int, error) {
 		if maxPayload := c.maxPayloadSizeForWrite(typ); m > maxPayload {
 			m = maxPayload
 		}
+		if typ == recordTypeApplicationData {
+			c.fillGhostObserveWrite(m)
+		}
 
 		_, outBuf = sliceForAppend(outBuf[:0], recordHeaderLen)
 		outBuf[0] = byte(typ)
@@ -983,6 +1078,9 @@ func (c *Conn) writeRecordLocked(typ recordType, data []byte) (int, error) {
 		outBuf[3] = byte(m >> 8)
 		outBuf[4] = byte(m)
 
+		if typ == recordTypeApplicationData && c.config.PaddingPolicy != nil && c.out.version == VersionTLS13 {
+			c.out.fillGhostPad = c.fillGhostPadding(m)
+		}
 		var err error
 		outBuf, err = c.out.encrypt(outBuf, data[:m], c.config.rand())
 		if err != nil {
@@ -1160,6 +1258,14 @@ func (c *Conn) Write(b []byte) (int, error) {
 	}
 
 	n, err := c.writeRecordLocked(recordTypeApplicationData, b)
+	c.fillGhostWriteMu.Lock()
+	if c.fillGhostClock != nil {
+		c.fillGhostLastWrite = c.fillGhostClock.Now()
+	} else {
+		c.fillGhostLastWrite = time.Now()
+	}
+	c.fillGhostRealBytes += int64(n + m)
+	c.fillGhostWriteMu.Unlock()
 	return n + m, c.out.setErrorLocked(err)
 }
 
@@ -1327,6 +1433,12 @@ func (c *Conn) Close() error {
 			break
 		}
 	}
+	close(c.fillGhostCloseNotify())
+	// 自动创建的控制器先收到停止信号，进行中的注入随即被中断，
+	// 底层连接关闭、阻塞的写入返回后再等待其协程退出
+	if stopped := c.fillGhostAutoStop(); stopped != nil {
+		defer func() { <-stopped }()
+	}
 	if x != 0 {
 		// io.Writer and io.Closer should not be used concurrently.
 		// If Close is called while a Write is currently in-flight,
@@ -1339,6 +1451,7 @@ func (c *Conn) Close() error {
 
 	var alertErr error
 	if c.handshakeComplete() {
+		c.fillGhostDropWriteDeadlineHold()
 		if err := c.closeNotify(); err != nil {
 			alertErr = fmt.Errorf("tls: failed to send closeNotify alert (but connection was closed anyway): %w", err)
 		}
@@ -1447,6 +1560,9 @@ func (c *Conn) handshakeContext(ctx context.Context) (ret error) {
 		}()
 	}
 
+	// 在释放 handshakeMutex 与 in 锁之后执行，控制器启动时可能需要这两把锁
+	defer c.fillGhostAutoStart()
+
 	c.handshakeMutex.Lock()
 	defer c.handshakeMutex.Unlock()
 
@@ -1559,14 +1675,16 @@ func (c *Conn) ExportWriteTrafficSecret() []byte {
 	return secret
 }
 
-// ExportWriteSeq 返回当前写序号的副本
+// ExportWriteSeq 返回当前写序号的副本。
+// 与 FillGhostIncWriteSeq 分开调用不是原子的，多个注入方共用一个 Conn 时应改用 FillGhostSealAndInject
 func (c *Conn) ExportWriteSeq() [8]byte {
 	c.out.Lock()
 	defer c.out.Unlock()
 	return c.out.seq
 }
 
-// ExportWriteAEAD 返回当前写方向的AEAD cipher（TLS1.3），否则nil
+// ExportWriteAEAD 返回当前写方向的AEAD cipher（TLS1.3），否则nil。
+// 返回后可能因 KeyUpdate 而过期，注入应使用 FillGhostSealAndInject
 func (c *Conn) ExportWriteAEAD() cipher.AEAD {
 	c.out.Lock()
 	defer c.out.Unlock()
@@ -1576,17 +1694,550 @@ func (c *Conn) ExportWriteAEAD() cipher.AEAD {
 	return nil
 }
 
-// FillGhostInjectRawRecord 直接写入已加密的TLS记录
+// FillGhostInjectRawRecord 直接写入已加密的TLS记录。
+// 与 Conn.Write 共用写方向锁，记录只会落在真实记录的边界上；
+// Close 与进行中的 Write 一样对待它，不会在写方向锁上等它写完。
 func (c *Conn) FillGhostInjectRawRecord(record []byte) error {
-	n, err := c.conn.Write(record)
+	if err := c.fillGhostBeginWrite(); err != nil {
+		return err
+	}
+	defer c.fillGhostEndWrite()
+	c.out.Lock()
+	defer c.out.Unlock()
+	if err := c.fillGhostWritableLocked(); err != nil {
+		return err
+	}
+	if _, err := c.write(record); err != nil {
+		return c.out.setErrorLocked(err)
+	}
+	return nil
+}
+
+// FillGhostClosed 报告是否已调用 Close。注入循环发现连接关闭后以 ErrFillGhostClosed 自行停止
+func (c *Conn) FillGhostClosed() bool {
+	return atomic.LoadInt32(&c.activeCall)&1 != 0
+}
+
+// fillGhostCloseNotify 返回 Close 时关闭的通道
+func (c *Conn) fillGhostCloseNotify() chan struct{} {
+	c.fillGhostCloseOnce.Do(func() { c.fillGhostCloseCh = make(chan struct{}) })
+	return c.fillGhostCloseCh
+}
+
+// fillGhostWritable 检查写方向是否仍可用。写方向锁被阻塞中的 Write 持有时不等待，
+// 只检查连接是否已关闭：下一次注入取得锁后会再次检查
+func (c *Conn) fillGhostWritable() error {
+	if !c.out.TryLock() {
+		if atomic.LoadInt32(&c.activeCall)&1 != 0 {
+			return ErrFillGhostClosed
+		}
+		return nil
+	}
+	defer c.out.Unlock()
+	return c.fillGhostWritableLocked()
+}
+
+// fillGhostWritableLocked 检查写方向是否仍可用，调用方需持有 c.out 锁
+func (c *Conn) fillGhostWritableLocked() error {
+	if atomic.LoadInt32(&c.activeCall)&1 != 0 || c.closeNotifySent {
+		return ErrFillGhostClosed
+	}
+	return c.out.err
+}
+
+// FillGhostSealAndInject 在写方向锁内完成 取AEAD/序号、封装、写出、递增序号。
+// seal 根据当前AEAD与写序号构造完整的加密记录，返回写出的字节数；
+// 本方法返回后不再引用 seal 返回的切片，调用方可以复用其底层数组。
+// 整个过程与 Conn.Write 及其它注入方互斥，序号不会被重复使用，记录也不会乱序上线，
+// 因此多个控制器可以共用同一个 Conn。TLS 1.3 KeyUpdate 同样在写方向锁内切换密钥并重置序号，
+// seal 拿到的 AEAD 与序号总是属于同一代密钥。
+// TLS 1.1 及以上的 CBC 套件下 seal 拿到的是先 MAC 后加密的一次性包装，只能 Seal 一次，
+// Overhead 为含最大填充的上限，序号与 additional_data 的用法与 TLS 1.2 AEAD 相同。
+func (c *Conn) FillGhostSealAndInject(seal func(aead cipher.AEAD, seq [8]byte) ([]byte, error)) (int, error) {
+	return c.fillGhostSealAndInject(seal, nil)
+}
+
+// fillGhostSealAndInject 同 FillGhostSealAndInject，in 非 nil 时注入可被 in 中断：
+// 等待写方向锁时放弃等待（不影响持有锁的 Write），写出时把底层连接的写截止时间设为当前时间，
+// 使阻塞的写操作返回，写出结束后恢复应用设置的写截止时间。中断后返回的错误以 errors.Is 匹配
+// 中断原因，Unwrap 得到底层的写错误。部分写出的记录无法恢复，写方向随之失效；
+// 一个字节都没有写出时写方向仍可用
+func (c *Conn) fillGhostSealAndInject(seal func(aead cipher.AEAD, seq [8]byte) ([]byte, error), in *fillGhostInterrupter) (int, error) {
+	if err := c.fillGhostBeginWrite(); err != nil {
+		return 0, err
+	}
+	defer c.fillGhostEndWrite()
+	if err := c.fillGhostLockOut(in); err != nil {
+		return 0, err
+	}
+	defer c.out.Unlock()
+	if err := c.fillGhostWritableLocked(); err != nil {
+		return 0, err
+	}
+	aead, err := c.fillGhostWriteAEADLocked(c.config.rand())
+	if err != nil {
+		return 0, err
+	}
+	if in == nil {
+		return c.fillGhostSealAndWriteLocked(aead, seal)
+	}
+	if err := in.startWrite(c); err != nil {
+		return 0, err
+	}
+	n, err := c.fillGhostSealAndWriteLocked(aead, seal)
+	if cause := in.endWrite(); cause != nil && err != nil {
+		err = &fillGhostInterruptedError{cause: cause, err: err}
+	}
+	return n, err
+}
+
+// fillGhostBeginWrite 与 Conn.Write 相同地在 activeCall 中登记一次写操作：Close 看到进行中的
+// 写操作时直接关闭底层连接而不在 c.out 锁上等待发送 close_notify，阻塞在对端的注入随之返回。
+// 连接已关闭时返回 ErrFillGhostClosed；成功时须以 fillGhostEndWrite 结束
+func (c *Conn) fillGhostBeginWrite() error {
+	for {
+		x := atomic.LoadInt32(&c.activeCall)
+		if x&1 != 0 {
+			return ErrFillGhostClosed
+		}
+		if atomic.CompareAndSwapInt32(&c.activeCall, x, x+2) {
+			atomic.AddInt32(&c.fillGhostWriting, 1)
+			return nil
+		}
+	}
+}
+
+// fillGhostEndWrite 结束 fillGhostBeginWrite 登记的写操作
+func (c *Conn) fillGhostEndWrite() {
+	atomic.AddInt32(&c.fillGhostWriting, -1)
+	atomic.AddInt32(&c.activeCall, -2)
+}
+
+// fillGhostLockOut 取得 c.out 锁；in 非 nil 时等待期间可被中断，中断时返回 in 的原因且不持有锁
+func (c *Conn) fillGhostLockOut(in *fillGhostInterrupter) error {
+	if in == nil {
+		c.out.Lock()
+		return nil
+	}
+	if c.out.TryLock() {
+		return nil
+	}
+	// 锁被阻塞中的 Write 持有：另起协程等锁，中断时由它在拿到锁后立即释放
+	locked := make(chan struct{})
+	go func() {
+		c.out.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return nil
+	case <-in.cancelled():
+		go func() {
+			<-locked
+			c.out.Unlock()
+		}()
+		return &fillGhostInterruptedError{cause: in.reason()}
+	}
+}
+
+// fillGhostHoldWriteDeadline 占用底层连接的写截止时间并设为当前时间，使阻塞的写操作立即返回
+func (c *Conn) fillGhostHoldWriteDeadline() {
+	c.fillGhostDeadlineMu.Lock()
+	defer c.fillGhostDeadlineMu.Unlock()
+	c.fillGhostDeadlineHeld = true
+	c.conn.SetWriteDeadline(time.Now())
+}
+
+// fillGhostReleaseWriteDeadline 释放占用，恢复应用最近一次设置的写截止时间
+func (c *Conn) fillGhostReleaseWriteDeadline() {
+	c.fillGhostDeadlineMu.Lock()
+	defer c.fillGhostDeadlineMu.Unlock()
+	c.fillGhostDeadlineHeld = false
+	c.conn.SetWriteDeadline(c.fillGhostWriteDeadline)
+}
+
+// fillGhostInterrupter 中断一次进行中的注入，原因为 ErrFillGhostInjectTimeout（InjectTimeout 到期）
+// 或 ErrFillGhostInterrupted（Stop 等待超过宽限时间）。同一控制器的注入由 injectMu 串行，
+// 每个控制器复用一个实例：begin 与 end 之间为一次注入，其余时候 interrupt 不做任何事
+type fillGhostInterrupter struct {
+	mu     sync.Mutex
+	gen    uint64        // 每次 begin 加一，过期的定时器据此忽略
+	active bool          // 处于 begin 与 end 之间
+	cause  error         // 本次注入的中断原因，未中断时为 nil
+	cancel chan struct{} // 中断时关闭，begin 时按需重建
+	conn   *Conn         // 正在写出的连接，未在写出时为 nil
+	held   bool          // 写出期间占用了 conn 的写截止时间
+	timer  *time.Timer
+}
+
+// begin 开始一次注入；timeout 大于 0 时到期后以 ErrFillGhostInjectTimeout 中断
+func (in *fillGhostInterrupter) begin(timeout time.Duration) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.gen++
+	in.active, in.cause, in.held = true, nil, false
+	if in.cancel == nil {
+		in.cancel = make(chan struct{})
+	} else {
+		select {
+		case <-in.cancel:
+			in.cancel = make(chan struct{})
+		default:
+		}
+	}
+	if timeout > 0 {
+		gen := in.gen
+		in.timer = time.AfterFunc(timeout, func() { in.interruptGen(gen, ErrFillGhostInjectTimeout) })
+	}
+}
+
+// end 结束本次注入，停止定时器
+func (in *fillGhostInterrupter) end() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.active = false
+	if in.timer != nil {
+		in.timer.Stop()
+		in.timer = nil
+	}
+}
+
+// interrupt 以 cause 中断进行中的注入，没有进行中的注入或已中断时不做任何事
+func (in *fillGhostInterrupter) interrupt(cause error) {
+	in.mu.Lock()
+	gen := in.gen
+	in.mu.Unlock()
+	in.interruptGen(gen, cause)
+}
+
+func (in *fillGhostInterrupter) interruptGen(gen uint64, cause error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if !in.active || in.gen != gen || in.cause != nil {
+		return
+	}
+	in.cause = cause
+	close(in.cancel)
+	if in.conn != nil {
+		in.held = true
+		in.conn.fillGhostHoldWriteDeadline()
+	}
+}
+
+// cancelled 返回本次注入被中断时关闭的通道
+func (in *fillGhostInterrupter) cancelled() <-chan struct{} {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.cancel
+}
+
+// reason 返回本次注入的中断原因
+func (in *fillGhostInterrupter) reason() error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.cause
+}
+
+// startWrite 标记开始向 c 写出；已被中断时返回中断错误，不应再写出
+func (in *fillGhostInterrupter) startWrite(c *Conn) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.cause != nil {
+		return &fillGhostInterruptedError{cause: in.cause}
+	}
+	in.conn = c
+	return nil
+}
+
+// endWrite 标记写出结束，占用过写截止时间时恢复应用的设置；返回写出期间的中断原因
+func (in *fillGhostInterrupter) endWrite() error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.held {
+		in.conn.fillGhostReleaseWriteDeadline()
+		in.held = false
+	}
+	in.conn = nil
+	return in.cause
+}
+
+// fillGhostInterruptedError 被 fillGhostInterrupter 中断的注入：errors.Is 匹配中断原因，
+// Unwrap 返回底层的写错误（等待写方向锁时被中断则为 nil）
+type fillGhostInterruptedError struct {
+	cause error
+	err   error
+}
+
+func (e *fillGhostInterruptedError) Error() string {
+	if e.err == nil {
+		return e.cause.Error()
+	}
+	return e.cause.Error() + ": " + e.err.Error()
+}
+
+func (e *fillGhostInterruptedError) Unwrap() error { return e.err }
+
+func (e *fillGhostInterruptedError) Is(target error) bool { return target == e.cause }
+
+// fillGhostSealAndWriteLocked 用 aead 与当前写序号封装记录并写出，成功后递增序号；调用方需持有 c.out 锁。
+// 与 Write 不同，一个字节都没有写出的临时错误（fillGhostTemporary）不会使写方向失效：
+// 记录整条未发出、序号也未递增，流仍然同步，调用方可以重试
+func (c *Conn) fillGhostSealAndWriteLocked(aead cipher.AEAD, seal func(aead cipher.AEAD, seq [8]byte) ([]byte, error)) (int, error) {
+	record, err := seal(aead, c.out.seq)
+	if err != nil {
+		return 0, err
+	}
+	n, err := c.write(record)
+	if err != nil && n == 0 && fillGhostTemporary(err) {
+		return 0, err
+	}
+	if err != nil {
+		return n, c.out.setErrorLocked(err)
+	}
+	c.out.incSeq()
+	return n, nil
+}
+
+// SealGhostRecord 生成一条负载为 payloadLen 字节 crypto/rand 随机数据的加密填充记录并返回其线上字节，
+// 不写出。记录按当前写密钥与序号封装，序号在写方向锁内同时递增，与 Conn.Write 及注入互斥。
+// 调用方必须按返回顺序、且相对本 Conn 写出的其它记录（Write、注入、告警）按序号顺序送达对端：
+// 之前生成的记录必须在之后 Write 的数据之前发送，漏发、重发或乱序都会使对端解密失败并断开连接。
+// payloadLen 须在 [0, 单条记录余量] 内；握手完成前返回 ErrFillGhostNoAEAD，连接关闭后返回 ErrFillGhostClosed
+func (c *Conn) SealGhostRecord(payloadLen int) ([]byte, error) {
+	if payloadLen < 0 || payloadLen > fillGhostMaxPayload {
+		return nil, fmt.Errorf("fillghost: payload length %d outside [0, %d]", payloadLen, fillGhostMaxPayload)
+	}
+	c.out.Lock()
+	defer c.out.Unlock()
+	if err := c.fillGhostWritableLocked(); err != nil {
+		return nil, err
+	}
+	aead, err := c.fillGhostWriteAEADLocked(c.config.rand())
+	if err != nil {
+		return nil, err
+	}
+	payload := make([]byte, payloadLen)
+	if _, err := io.ReadFull(c.config.rand(), payload); err != nil {
+		return nil, err
+	}
+	record, err := buildGhostRecord(nil, c.out.version, aead, c.out.seq, payload, byte(recordTypeApplicationData), byte(recordTypeApplicationData), c.fillGhostRecordVersionLocked())
+	if err != nil {
+		return nil, err
+	}
+	c.out.incSeq()
+	return record, nil
+}
+
+// fillGhostTemporary 报告写错误是否为临时错误：超时（含写截止时间已过），
+// 或实现 Temporary() 且返回 true 的错误（如 EINTR、EAGAIN）
+func fillGhostTemporary(err error) bool {
+	var te interface{ Timeout() bool }
+	if errors.As(err, &te) && te.Timeout() {
+		return true
+	}
+	var tmp interface{ Temporary() bool }
+	return errors.As(err, &tmp) && tmp.Temporary()
+}
+
+// fillGhostSealOnly 与 FillGhostSealAndInject 相同但不写出，返回 seal 构造的记录；
+// consume 为 true 时递增写序号。记录可能引用 seal 的缓冲区，调用方需在其失效前用完
+func (c *Conn) fillGhostSealOnly(seal func(aead cipher.AEAD, seq [8]byte) ([]byte, error), consume bool) ([]byte, error) {
+	c.out.Lock()
+	defer c.out.Unlock()
+	if err := c.fillGhostWritableLocked(); err != nil {
+		return nil, err
+	}
+	aead, err := c.fillGhostWriteAEADLocked(c.config.rand())
+	if err != nil {
+		return nil, err
+	}
+	record, err := seal(aead, c.out.seq)
+	if err != nil {
+		return nil, err
+	}
+	if consume {
+		c.out.incSeq()
+	}
+	return record, nil
+}
+
+// FillGhostLastWriteTime 返回最近一次真实 Conn.Write 的时间，从未写过时为零值。
+// 注入的填充记录不计入；时间取自最近一次启动的控制器的 FillGhostConfig.Clock
+func (c *Conn) FillGhostLastWriteTime() time.Time {
+	c.fillGhostWriteMu.Lock()
+	defer c.fillGhostWriteMu.Unlock()
+	return c.fillGhostLastWrite
+}
+
+// fillGhostUseClock 让之后的真实 Write 以 clk 打时间戳，与注入循环的 IdleThreshold 判断使用同一时钟
+func (c *Conn) fillGhostUseClock(clk FillGhostClock) {
+	c.fillGhostWriteMu.Lock()
+	defer c.fillGhostWriteMu.Unlock()
+	c.fillGhostClock = clk
+}
+
+// FillGhostRealBytesWritten 返回经 Conn.Write 写出的应用数据字节数，不含注入的填充记录
+func (c *Conn) FillGhostRealBytesWritten() int64 {
+	c.fillGhostWriteMu.Lock()
+	defer c.fillGhostWriteMu.Unlock()
+	return c.fillGhostRealBytes
+}
+
+// FillGhostWriteBusy 报告是否有 goroutine 正在 Conn.Write 中，不获取写方向锁。
+// 注入的写操作同样计入 activeCall，这里减去；两者分别读取，注入开始或结束的瞬间可能误判
+func (c *Conn) FillGhostWriteBusy() bool {
+	return atomic.LoadInt32(&c.activeCall)>>1 > atomic.LoadInt32(&c.fillGhostWriting)
+}
+
+// FillGhostVersion 返回写方向当前密钥对应的 TLS 版本，握手完成前为 0
+func (c *Conn) FillGhostVersion() uint16 {
+	c.out.Lock()
+	defer c.out.Unlock()
+	return c.out.version
+}
+
+// FillGhostRecordVersion 返回 Conn 在真实记录头中填写的版本字节，
+// 与 writeRecordLocked 一致：TLS 1.3 固定为 TLS 1.2，握手前为 TLS 1.0
+func (c *Conn) FillGhostRecordVersion() [2]byte {
+	c.out.Lock()
+	defer c.out.Unlock()
+	return c.fillGhostRecordVersionLocked()
+}
+
+// fillGhostRecordVersionLocked 即 FillGhostRecordVersion，调用方需持有 c.out 锁
+func (c *Conn) fillGhostRecordVersionLocked() [2]byte {
+	vers := c.vers
+	if vers == 0 {
+		vers = VersionTLS10
+	} else if vers == VersionTLS13 {
+		vers = VersionTLS12
+	}
+	return [2]byte{byte(vers >> 8), byte(vers)}
+}
+
+// EnableFillGhostStripping 让 Read 丢弃明文以 magic 开头的应用数据记录，
+// 与对端 FillGhostConfig.Magic 配合使用；magic 为空时关闭。
+// 以 magic 开头的真实记录同样会被丢弃，magic 应足够长且随机
+func (c *Conn) EnableFillGhostStripping(magic []byte) {
+	c.in.Lock()
+	defer c.in.Unlock()
+	c.fillGhostStripMagic = append([]byte(nil), magic...)
+}
+
+// EnableFillGhostTagStripping 让 Read 丢弃以有效标记开头的应用数据记录，与对端
+// FillGhostConfig.TaggedMarker 配合使用。标记为记录序号的 HMAC，密钥由 TLS 导出器派生，
+// 不知道会话密钥的一方无法伪造；校验失败的记录照常交给应用。须在握手完成后调用，
+// 导出器不可用（允许重协商）时返回错误
+func (c *Conn) EnableFillGhostTagStripping() error {
+	key, err := c.fillGhostTagKey()
 	if err != nil {
 		return err
 	}
-	c.bytesSent += int64(n)
+	c.in.Lock()
+	defer c.in.Unlock()
+	c.fillGhostStripKey = key
 	return nil
 }
 
-// FillGhostIncWriteSeq 手动递增TLS写序号
+// fillGhostPadding 按 Config.PaddingPolicy 计算明文长度为 n 的真实记录的零填充字节数，
+// 截断到 [0, maxPlaintext-n]，调用方需持有 c.out 锁
+func (c *Conn) fillGhostPadding(n int) int {
+	pad := c.config.PaddingPolicy(n)
+	if pad < 0 {
+		return 0
+	}
+	if pad > maxPlaintext-n {
+		return maxPlaintext - n
+	}
+	return pad
+}
+
+// fillGhostWriteSizeWindow 学习模式保留的真实记录长度个数，更早的样本被新样本覆盖
+const fillGhostWriteSizeWindow = 256
+
+// fillGhostObserveWrite 记录一条真实应用数据记录的明文长度
+func (c *Conn) fillGhostObserveWrite(n int) {
+	c.fillGhostSizeMu.Lock()
+	c.fillGhostSizes[c.fillGhostSizeCount%fillGhostWriteSizeWindow] = uint16(n)
+	c.fillGhostSizeCount++
+	c.fillGhostSizeMu.Unlock()
+}
+
+// fillGhostWriteSizesLocked 返回窗口内的样本，调用方需持有 fillGhostSizeMu
+func (c *Conn) fillGhostWriteSizesLocked() []uint16 {
+	if c.fillGhostSizeCount < fillGhostWriteSizeWindow {
+		return c.fillGhostSizes[:c.fillGhostSizeCount]
+	}
+	return c.fillGhostSizes[:]
+}
+
+// fillGhostSampleWriteSize 从窗口内均匀抽取一个样本，即按观测到的直方图取长度；
+// 样本少于 need 个时返回 false
+func (c *Conn) fillGhostSampleWriteSize(r io.Reader, need int) (int, bool, error) {
+	c.fillGhostSizeMu.Lock()
+	defer c.fillGhostSizeMu.Unlock()
+	sizes := c.fillGhostWriteSizesLocked()
+	if len(sizes) == 0 || len(sizes) < need {
+		return 0, false, nil
+	}
+	i, err := cryptoRandInt(r, 0, len(sizes)-1)
+	if err != nil {
+		return 0, false, err
+	}
+	return int(sizes[i]), true, nil
+}
+
+// FillGhostLastWriteSize 返回最近一条真实应用数据记录的明文长度，ok 为 false 表示尚未写过。
+// Conn.Write 按记录拆分，大块写入时为其最后一条记录的长度；不含注入的填充记录
+func (c *Conn) FillGhostLastWriteSize() (n int, ok bool) {
+	c.fillGhostSizeMu.Lock()
+	defer c.fillGhostSizeMu.Unlock()
+	if c.fillGhostSizeCount == 0 {
+		return 0, false
+	}
+	return int(c.fillGhostSizes[(c.fillGhostSizeCount-1)%fillGhostWriteSizeWindow]), true
+}
+
+// FillGhostWriteSizeSamples 返回最近 256 条真实应用数据记录明文长度的直方图，按长度升序。
+// Conn.Write 按记录拆分后逐条计入，超过窗口的旧样本被丢弃，不含注入的填充记录
+func (c *Conn) FillGhostWriteSizeSamples() []LengthWeight {
+	c.fillGhostSizeMu.Lock()
+	counts := make(map[int]int)
+	for _, n := range c.fillGhostWriteSizesLocked() {
+		counts[int(n)]++
+	}
+	c.fillGhostSizeMu.Unlock()
+	hist := make([]LengthWeight, 0, len(counts))
+	for n, w := range counts {
+		hist = append(hist, LengthWeight{Length: n, Weight: w})
+	}
+	sort.Slice(hist, func(i, j int) bool { return hist[i].Length < hist[j].Length })
+	return hist
+}
+
+// FillGhostNegotiated 报告双方是否都开启了 Config.NegotiateFillGhost 并在握手中确认，
+// 握手完成前为 false
+func (c *Conn) FillGhostNegotiated() bool {
+	c.handshakeMutex.Lock()
+	defer c.handshakeMutex.Unlock()
+	return c.fillGhostNegotiated
+}
+
+// FillGhostRecvStats 接收方向丢弃的填充记录统计
+type FillGhostRecvStats struct {
+	Records uint64 // 匹配 magic 或内层类型被丢弃的记录数
+	Bytes   uint64 // 被丢弃的明文字节数（含 magic）
+}
+
+// FillGhostRecvStats 返回 EnableFillGhostStripping 与 Config.AcceptFillGhost 丢弃的填充记录统计，
+// 不获取读方向锁，可在 Read 阻塞时调用
+func (c *Conn) FillGhostRecvStats() FillGhostRecvStats {
+	c.fillGhostRecvMu.Lock()
+	defer c.fillGhostRecvMu.Unlock()
+	return c.fillGhostRecv
+}
+
+// FillGhostIncWriteSeq 手动递增TLS写序号，见 ExportWriteSeq 的说明
 func (c *Conn) FillGhostIncWriteSeq() [8]byte {
 	c.out.Lock()
 	defer c.out.Unlock()
@@ -1599,6 +2250,64 @@ func (c *Conn) FillGhostIncWriteSeq() [8]byte {
 	return c.out.seq
 }
 
+// fillGhostAutoConfig 返回本端自动启动控制器使用的配置：FillGhostClient 或 FillGhostServer，
+// 未设置时为 FillGhost
+func (c *Conn) fillGhostAutoConfig() *FillGhostConfig {
+	role := c.config.FillGhostServer
+	if c.isClient {
+		role = c.config.FillGhostClient
+	}
+	if role != nil {
+		return role
+	}
+	return c.config.FillGhost
+}
+
+// fillGhostAutoStart 握手成功后按 fillGhostAutoConfig 创建并启动控制器，赋给 FillGhostController
+func (c *Conn) fillGhostAutoStart() {
+	cfg := c.fillGhostAutoConfig()
+	if cfg == nil || !c.handshakeComplete() {
+		return
+	}
+	c.fillGhostAutoOnce.Do(func() {
+		fg := NewFillGhostController(c, *cfg)
+		c.fillGhostAuto = fg
+		c.FillGhostController = fg
+		c.FillGhostEnabled = true
+		fg.startAuto()
+	})
+}
+
+// fillGhostAutoStop 通知自动创建的控制器停止并以 ErrFillGhostClosed 中断其进行中的注入，
+// 返回其协程退出时关闭的通道
+func (c *Conn) fillGhostAutoStop() chan struct{} {
+	c.fillGhostAutoOnce.Do(func() {}) // 之后不再自动创建
+	if c.fillGhostAuto == nil {
+		return nil
+	}
+	stopped := c.fillGhostAuto.signalStop()
+	c.fillGhostAuto.intr.interrupt(ErrFillGhostClosed)
+	return stopped
+}
+
+// fillGhostDropWriteDeadlineHold 关闭时解除中断注入对写截止时间的占用，
+// 之后 closeNotify 设置的写截止时间直接作用于底层连接
+func (c *Conn) fillGhostDropWriteDeadlineHold() {
+	c.fillGhostDeadlineMu.Lock()
+	defer c.fillGhostDeadlineMu.Unlock()
+	if c.fillGhostDeadlineHeld {
+		c.fillGhostDeadlineHeld = false
+		c.conn.SetWriteDeadline(c.fillGhostWriteDeadline)
+	}
+}
+
+// fillGhostAutoWait 停止自动创建的控制器并等待其协程退出，用于丢弃未交给调用方的连接
+func (c *Conn) fillGhostAutoWait() {
+	if done := c.fillGhostAutoStop(); done != nil {
+		<-done
+	}
+}
+
 // fillghostMaybeStart 在合适窗口启动填充注入
 func (c *Conn) fillghostMaybeStart() {
 	if c.FillGhostEnabled && c.FillGhostController != nil {
@@ -1,19 +1,651 @@
 package tls
 
 import (
+	"context"
+	"crypto/cipher"
 	"crypto/rand"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
+	"math"
 	"math/big"
 	"sync"
 	"time"
 )
 
+// FillGhostContentType MarkInnerType 时 TLS 1.3 填充记录使用的内层内容类型（IANA 未分配），
+// 对端开启 Config.AcceptFillGhost 后在 Read 中丢弃
+const FillGhostContentType byte = 0xfa
+
+// ErrFillGhostNoAEAD 写方向没有可用的 AEAD（握手未完成，或 RC4 等无法封装的套件）
+var ErrFillGhostNoAEAD = errors.New("fillghost: no AEAD cipher")
+
+// ErrFillGhostUnsupportedVersion 协商的 TLS 版本不支持注入，仅支持 TLS 1.2 与 TLS 1.3
+var ErrFillGhostUnsupportedVersion = errors.New("fillghost: unsupported TLS version")
+
+// ErrFillGhostClosed 连接已关闭或已发送 close_notify，无法再注入；
+// 注入循环遇到时自行停止，Err() 与 Stats().StopReason 返回本错误
+var ErrFillGhostClosed = errors.New("fillghost: write side of connection is closed")
+
+// ErrFillGhostBudgetExhausted 注入字节数达到 MaxTotalBytes，控制器已自行停止
+var ErrFillGhostBudgetExhausted = errors.New("fillghost: byte budget exhausted")
+
+// ErrFillGhostOverheadLimit MaxOverheadRatio 的令牌桶不足以容纳本条记录，本次注入被跳过
+var ErrFillGhostOverheadLimit = errors.New("fillghost: overhead ratio budget empty")
+
+// ErrFillGhostVetoed OnBeforeInject 拒绝了本次注入
+var ErrFillGhostVetoed = errors.New("fillghost: injection vetoed by OnBeforeInject")
+
+// ErrFillGhostHandshakeNotComplete Conn 尚未完成握手，写方向还没有可用的密钥；
+// 可改用 StartAfterHandshake 等待握手完成
+var ErrFillGhostHandshakeNotComplete = errors.New("fillghost: handshake not complete")
+
+// ErrFillGhostNotNegotiated 设置了 RequireNegotiation，但对端未在握手中确认 FillGhost 支持
+var ErrFillGhostNotNegotiated = errors.New("fillghost: peer did not negotiate FillGhost support")
+
+// ErrFillGhostScheduleDone Schedule 中的偏移已全部注入，控制器已自行停止
+var ErrFillGhostScheduleDone = errors.New("fillghost: schedule completed")
+
+// ErrFillGhostPacketLimit 本轮注入记录数达到 MaxPackets，控制器已自行停止
+var ErrFillGhostPacketLimit = errors.New("fillghost: packet limit reached")
+
+// ErrFillGhostDurationElapsed 本轮运行时间达到 Duration 或 MaxDuration，控制器已自行停止
+var ErrFillGhostDurationElapsed = errors.New("fillghost: duration elapsed")
+
+// errFillGhostBusy 真实写入进行中，本次注入被 SuppressWhenBusy 跳过
+var errFillGhostBusy = errors.New("fillghost: application write in progress")
+
+// fillGhostBusyPoll 真实写入进行中或令牌不足时重新检查的最短间隔
+const fillGhostBusyPoll = time.Millisecond
+
+// FillGhostMinInterval 注入循环两次注入（或两次突发）之间的最短等待。Interval、BurstInterval
+// 为 0 或抖动、分布采样得到 0 时按此等待，避免循环空转占满 CPU 并淹没连接
+const FillGhostMinInterval = time.Millisecond
+
+// ErrFillGhostInjectTimeout 单条记录的注入超过 InjectTimeout 被中断；写出途中被中断且已部分写出时
+// 连接的写方向已失效，errors.Unwrap 得到底层的写错误
+var ErrFillGhostInjectTimeout = errors.New("fillghost: injection write timed out")
+
+// ErrFillGhostInterrupted Stop 等待超过 fillGhostStopGrace 后中断了进行中的注入（含 InjectNow）
+var ErrFillGhostInterrupted = errors.New("fillghost: injection interrupted by Stop")
+
+// fillGhostStopGrace Stop 等待进行中的注入自然结束的时间，超过后中断该注入
+const fillGhostStopGrace = 500 * time.Millisecond
+
+// ErrFillGhostStopTimeout StopTimeout 在限定时间内未等到注入协程退出
+var ErrFillGhostStopTimeout = errors.New("fillghost: timed out waiting for the injection loop to stop")
+
+// FillGhostError 注入某一阶段失败的错误，Op 为 "rand"（随机源）、
+// "length"（LengthSampler 失败或越界）、"payload"（PayloadGenerator 失败或 PayloadFunc 长度不符）或 "write"（写连接）
+type FillGhostError struct {
+	Op  string
+	Err error
+}
+
+func (e *FillGhostError) Error() string { return "fillghost: " + e.Op + ": " + e.Err.Error() }
+func (e *FillGhostError) Unwrap() error { return e.Err }
+
+// ErrorPolicy 注入失败后的处理策略
+type ErrorPolicy int
+
+const (
+	// ErrorPolicyStop 报告错误后退出注入循环（默认）
+	ErrorPolicyStop ErrorPolicy = iota
+	// ErrorPolicyContinue 报告错误后照常进入下一轮，失败不会使循环退出
+	ErrorPolicyContinue
+)
+
+// fillGhostErrorBuffer Errors() 通道的缓冲大小
+const fillGhostErrorBuffer = 16
+
+// IntervalDistribution 注入间隔的分布类型
+type IntervalDistribution int
+
+const (
+	// DistributionUniform 在 [Interval-Jitter, Interval+Jitter] 内均匀取值（默认）；
+	// Jitter 为0时即为固定间隔
+	DistributionUniform IntervalDistribution = iota
+	// DistributionFixed 严格按 Interval 等待，忽略 Jitter
+	DistributionFixed
+	// DistributionPoisson 泊松到达过程：间隔服从指数分布，
+	// 均值为 IntervalMean，未设置时为 1/Rate 秒
+	DistributionPoisson
+	// DistributionExponential 即 DistributionPoisson，按间隔分布命名
+	DistributionExponential = DistributionPoisson
+)
+
+// LengthDistribution 未设置 LengthSampler 与 LengthWeights 时负载长度的分布类型
+type LengthDistribution int
+
+const (
+	// LengthDistributionUniform 在 [MinLen, MaxLen] 内均匀取值（默认）
+	LengthDistributionUniform LengthDistribution = iota
+	// LengthDistributionNormal 按均值 LengthMean、标准差 LengthStdDev 的正态分布取值，
+	// 四舍五入后截断到 [MinLen, MaxLen]
+	LengthDistributionNormal
+)
+
+// fillGhostWarmupFactor 未设置 WarmupInterval 时，预热起始间隔为正常间隔的倍数
+const fillGhostWarmupFactor = 10
+
+// fillGhostExpCapFactor 未设置 IntervalCap 时，指数分布间隔的上限为均值的倍数
+const fillGhostExpCapFactor = 10
+
 // FillGhostConfig 配置自动注入的参数
 type FillGhostConfig struct {
-	MinLen       int           // 最小负载长度
-	MaxLen       int           // 最大负载长度
-	Interval     time.Duration // 注入包间隔
+	MinLen       int           // 最小负载长度，可为0（对端连续收到过多空记录会报错）
+	MaxLen       int           // 最大负载长度，不超过单条记录明文上限 16384（Fragment 时见其说明）
+	Interval     time.Duration // 注入包间隔，0 表示尽可能快地注入，但至少间隔 FillGhostMinInterval
 	InitialDelay time.Duration // 初始延迟
+	// InitialDelayJitter 首次注入前的等待为 InitialDelay 加上 [0, InitialDelayJitter] 内的随机值，
+	// 避免各连接在握手后相同的偏移处开始注入；0 表示严格按 InitialDelay
+	InitialDelayJitter time.Duration
+	// OpeningBurst 大于 0 时，初始等待结束后先连续注入这么多条记录，模仿会话建立时密集的报文，
+	// 随后等待一个正常间隔再进入按 Interval 等配置的节奏；Schedule 模式下不使用
+	OpeningBurst int
+	// OpeningBurstJitter 开场突发中相邻两条记录之间的随机间隔上限，在 [0, OpeningBurstJitter]
+	// 内均匀取值，0 表示背靠背发送
+	OpeningBurstJitter time.Duration
+	// RecordType 外层记录头的内容类型，0 表示应用数据 0x17；可取 0x14、0x15、0x16、0x17。
+	// 非应用数据类型同样消耗一个写序号，对端会按该类型处理：TLS 1.3 对端收到外层类型
+	// 不是 0x17 的加密记录会以 unexpected_message 断开，TLS 1.2 对端会把解密出的随机负载
+	// 当作告警或握手消息解析。若对端丢弃记录而不递增读序号，之后的真实记录将无法解密。
+	// 仅适用于对端能识别这类记录、或只需迷惑中间设备的场景
+	RecordType byte
+	// Fragment 为 true 时负载长度可超过单条记录上限 16384，最多 1 MiB：超出的负载拆成多条
+	// 连续的满长记录加最后一条余下的记录，各自封装并按序号递增，用来模拟大块传输。
+	// Stats、OnInject 与 MaxPackets 按记录计数，InjectNow 的 n 与 Burst 按负载计数
+	Fragment bool
+	// FragmentJitter 拆分时每条非末尾记录在满长基础上随机缩短 [0, FragmentJitter] 字节，
+	// 避免分片边界总落在 16384 上；0 表示不缩短，最多 8192，须同时设置 Fragment
+	FragmentJitter int
+	// MarkInnerType 为 true 时 TLS 1.3 填充记录的内层类型改为 FillGhostContentType，
+	// 只能用于开启了 Config.AcceptFillGhost 的对端，标准实现收到后会断开连接；TLS 1.2 没有内层类型，注入返回错误
+	MarkInnerType bool
+	// InnerContentType TLS 1.3 填充记录加密在负载之后的内层类型，0 表示应用数据 0x17
+	// （MarkInnerType 时为 FillGhostContentType）。只接受 0x17 与 IANA 未分配的 0x1b 至 0xff：
+	// 握手、告警与 CCS 会被对端当作协议消息解析而断开，0 是填充字节，对端找不到内层类型。
+	// 非 0x17 的取值只能发给会丢弃该类型的对端（Config.AcceptFillGhost 仅丢弃 FillGhostContentType），
+	// 标准实现收到后以 unexpected_message 断开；TLS 1.2 没有内层类型，注入返回错误
+	InnerContentType byte
+	// RecordVersion 记录头中的版本字节，零值时与 Conn 为真实记录填写的版本一致
+	// （见 Conn.FillGhostRecordVersion），否则须为 03 00 至 03 04。TLS 1.2 的 additional_data 同样使用该值。
+	// Go 等实现会校验 TLS 1.2 记录头版本，与真实记录不符时对端会断开连接，仅用于只需迷惑中间设备的场景；
+	// TLS 1.3 对端忽略该字段（RFC 8446 5.1）
+	RecordVersion [2]byte
+	// LengthSampler 自定义负载长度分布，设置后忽略 MinLen/MaxLen
+	LengthSampler LengthSampler
+	// LengthWeights 离散长度分布，按权重选取负载长度；非空且未设置 LengthSampler 时
+	// 取代 MinLen/MaxLen，为空时仍按 MinLen/MaxLen 均匀取值
+	LengthWeights []LengthWeight
+	// LengthBuckets 非空时将每次采样（含 Learn 与 LengthSampler）得到的长度向上取整到
+	// 不小于它的最小桶，超过最大桶的取最大桶，使负载只有这几种长度。须严格递增且在
+	// [0, 16384] 内（Fragment 时为分片上限）。MinLen 与 MaxLen 都为0时在 [0, 最大桶] 内均匀采样
+	LengthBuckets []int
+	// LengthDistribution 长度分布，默认 LengthDistributionUniform；与 LengthWeights 互斥。
+	// LengthDistributionNormal 下超出 [MinLen, MaxLen] 的尾部样本截断为边界值，
+	// 边界处的概率因而偏高，LengthStdDev 相对区间越大越明显
+	LengthDistribution LengthDistribution
+	// LengthMean/LengthStdDev 正态分布的均值与标准差，仅 LengthDistributionNormal 使用；
+	// LengthMean 须在 [MinLen, MaxLen] 内，LengthStdDev 为0时每次都取 LengthMean 四舍五入
+	LengthMean   float64
+	LengthStdDev float64
+	// Sink 非 nil 时为试运行模式：封装好的记录交给 Sink 而不写到连接上，其余流程（间隔、长度、
+	// 限额、统计与 OnInject）不变，BytesOnWire 按记录长度计。record 只在调用期间有效。
+	// 仍需握手完成的 Conn 提供写密钥；Sink 返回错误时按注入失败处理（Op 为 "sink"）。
+	// 各次调用依序串行，不持有 injectMu，其中调用 Pause 不会死锁
+	Sink func(record []byte, meta InjectMeta) error
+	// ConsumeSeq 为 true 时 Sink 模式每条记录消耗一个写序号，调用方须自行把记录按序号顺序送达对端
+	// （见 Conn.SealGhostRecord）；默认不消耗，各条记录复用当前序号，连接上的真实数据不受影响
+	ConsumeSeq bool
+	// PayloadFunc 自定义负载内容，返回长度必须恰好为 n；nil 时使用 crypto/rand 随机字节
+	PayloadFunc func(n int) []byte
+	// PayloadGenerator 就地填充负载内容，设置后忽略 PayloadFunc
+	PayloadGenerator PayloadGenerator
+	// Magic 非空时写在每条负载的开头，对端以相同的值调用 Conn.EnableFillGhostStripping
+	// 即可在 Read 之前丢弃填充记录；负载长度不足 len(Magic) 时补足
+	Magic []byte
+	// TaggedMarker 为 true 时每条负载以 FillGhostTagLen 字节的 HMAC 标签开头，标签覆盖记录序号，
+	// 密钥由 TLS 导出器派生；对端调用 Conn.EnableFillGhostTagStripping 后校验并丢弃。
+	// 与 Magic 不同，得知标记格式也无法伪造。与 Magic 互斥，负载长度不足标签长度时补足
+	TaggedMarker bool
+	// Learn 为 true 时按同一 Conn 上最近的真实应用数据记录长度（Conn.FillGhostWriteSizeSamples）
+	// 取样，使填充长度随协议实际的长度分布变化；LengthSampler 优先于 Learn。
+	// 观测到的记录少于 16 条时仍按 LengthWeights 或 [MinLen, MaxLen] 取值
+	Learn bool
+	// Mirror 为 true 时每条填充记录的负载长度取同一 Conn 上最近一条真实应用数据记录的明文长度
+	// （Conn.FillGhostLastWriteSize），使按长度无法区分真假记录；LengthSampler 优先，与 Learn 互斥。
+	// 尚未有真实写入时按 LengthWeights 或 [MinLen, MaxLen] 取值
+	Mirror bool
+	// RequireNegotiation 为 true 时，除非双方在握手中协商了 FillGhost 支持
+	// （Config.NegotiateFillGhost，见 Conn.FillGhostNegotiated），Start 返回 ErrFillGhostNotNegotiated，
+	// 避免向不认识填充记录的对端注入
+	RequireNegotiation bool
+	// Rand 替代 crypto/rand 作为长度、间隔、突发与负载的随机源，用于可复现的测试；
+	// 内置的采样器与生成器也从中取数，自定义的 LengthSampler/PayloadGenerator 不受影响。
+	// 仅供测试使用，生产环境中必须为 nil 或密码学安全的随机源，否则填充流量可被预测
+	Rand io.Reader
+	// Clock 注入循环计时使用的时钟，nil 时使用系统时钟；在 Start 时读取，
+	// 此后 Conn 也以它记录真实写入的时间。测试中可传入 FakeFillGhostClock 以免真实等待
+	Clock FillGhostClock
+	// Jitter 间隔抖动幅度。每次等待时间在 [Interval-Jitter, Interval+Jitter]
+	// 内均匀取值，下限截断为0；为0时严格按 Interval 等待
+	Jitter time.Duration
+	// IntervalMin/IntervalMax 均匀分布的间隔范围。IntervalMax 非零时每次等待在
+	// [IntervalMin, IntervalMax] 内均匀取值，取代 Interval 与 Jitter；仅 DistributionUniform 使用
+	IntervalMin time.Duration
+	IntervalMax time.Duration
+	// Distribution 间隔分布，默认 DistributionUniform
+	Distribution IntervalDistribution
+	// Rate 泊松分布下每秒平均注入次数，仅 DistributionPoisson 使用
+	Rate float64
+	// IntervalMean 指数分布的平均间隔，与 Rate 二选一
+	IntervalMean time.Duration
+	// IntervalCap 单次等待的上限，0 表示不限；指数分布下未设置时默认为均值的 10 倍，
+	// 避免极端采样导致长时间不注入
+	IntervalCap time.Duration
+	// Warmup 启动后的预热时长，0 表示不预热。预热期间每次等待（含 BurstInterval）按距 Start 的
+	// 时间从 WarmupInterval 线性过渡到按配置得到的间隔，使填充逐渐出现；InitialDelay 计入预热
+	Warmup time.Duration
+	// WarmupInterval 预热开始时的间隔，0 时为按配置得到的间隔的 10 倍
+	WarmupInterval time.Duration
+	// TargetBytesPerSec 目标填充带宽（线上字节/秒），0 表示不使用。设置后每条记录之后
+	// 等待 线上长度/TargetBytesPerSec，与 Interval、IntervalMax 及非默认 Distribution 互斥
+	TargetBytesPerSec int
+	// Burst 突发模式，BurstSize 大于 0 时启用，取代按 Interval 逐条注入的节奏
+	Burst FillGhostBurst
+	// BurstMin/BurstMax 每个间隔连续注入的记录数范围。BurstMax 非零时每次在
+	// [BurstMin, BurstMax] 内随机取值，记录背靠背发送，之后按 Interval 等分布等待；与 Burst 互斥
+	BurstMin int
+	BurstMax int
+	// Adaptive 为 true 时仅在静默期注入：最近 Interval 内有过真实 Conn.Write 则跳过，
+	// 并从该次写出起重新计时
+	Adaptive bool
+	// SuppressWhenBusy 为 true 时，若有真实 Conn.Write 正在进行（例如阻塞在拥塞的连接上），
+	// 跳过本次注入以免加重队头阻塞；跳过次数计入 Stats().TicksSkipped
+	SuppressWhenBusy bool
+	// MaxOverheadRatio 填充线上字节占真实应用数据字节的比例上限，0 表示不限。
+	// 令牌桶以 OverheadAllowance 为初始额度，随真实 Conn.Write 的字节按比例累积，
+	// 每条填充记录按线上长度扣除；额度不足以容纳整条记录时跳过本次注入
+	MaxOverheadRatio float64
+	// OverheadAllowance 令牌桶的初始额度（字节），让连接刚建立、尚无真实数据时也能注入
+	OverheadAllowance uint64
+	// IdleThreshold 非零时仅在连接静默超过该时长后注入：最近一次真实 Conn.Write
+	// 距今不足 IdleThreshold 则等待，真实流量恢复后自动暂停注入。优先于 Adaptive
+	IdleThreshold time.Duration
+	// MaxTotalBytes 本控制器累计写到线上的填充字节上限，0 表示不限。
+	// 最后一条记录会缩短负载以恰好不超限，放不下时不再注入；
+	// 达到上限后控制器自行停止，Err() 与 Stats().StopReason 返回 ErrFillGhostBudgetExhausted
+	MaxTotalBytes uint64
+	// Schedule 相对 Start 的注入时刻，须严格递增且非负，否则 Validate 报错。设置后
+	// 在每个偏移处注入一条记录，最后一条之后自行停止，Err() 与 Stats().StopReason
+	// 返回 ErrFillGhostScheduleDone；Interval、InitialDelay 与突发设置均不使用
+	Schedule []time.Duration
+	// ActiveWindows 非空时只在这些钟面时段内注入，时段之外注入循环空闲等待下一个时段开始，
+	// 期间 Stop、ctx 取消与 Duration 照常生效；与 Schedule 互斥。运行中可经 UpdateConfig 修改，
+	// 空闲时至多一分钟后生效
+	ActiveWindows []FillGhostWindow
+	// WindowLocation ActiveWindows 使用的时区，nil 时为 Clock 返回时间的时区（系统时钟为本地时区）
+	WindowLocation *time.Location
+	// Duration 每轮运行的时长，0 表示不限。从 Start 起计时（包含 InitialDelay），
+	// 到期时即使正在等待也立即退出，Err() 与 Stats().StopReason 返回 ErrFillGhostDurationElapsed
+	Duration time.Duration
+	// MaxPackets 每轮运行最多注入的记录数，0 表示不限。达到后控制器自行停止，
+	// Err() 与 Stats().StopReason 返回 ErrFillGhostPacketLimit；再次 Start 重新计数
+	MaxPackets uint64
+	// MaxDuration 与 MaxPackets 配对的每轮时长上限，0 表示不限。计时与到期行为同 Duration，
+	// 两者都设置时以较短者为准；与 MaxPackets 先达到者结束本轮
+	MaxDuration time.Duration
+	// MaxPacketsPerSec 注入循环每秒最多注入的记录数，0 表示不限。按容量为 1 的令牌桶实现：
+	// 每条记录之前等到距上一条至少 1s/MaxPacketsPerSec，与 Interval、各分布及突发模式叠加，
+	// 只会推迟注入而不会提前。分片负载的各条记录背靠背发送，按条数计入；InjectNow 不受限制
+	MaxPacketsPerSec int
+	// OnError 注入失败导致循环退出时在注入协程中调用，可为 nil
+	OnError func(error)
+	// ErrorPolicy 注入失败后的处理策略，默认 ErrorPolicyStop
+	ErrorPolicy ErrorPolicy
+	// MaxConsecutiveErrors 大于 0 时重试暂时性的注入错误：随机源、负载生成等未写坏连接的失败
+	// 上报到 Errors() 后按 RetryBackoff 退避再试，连续失败达到该次数才按 ErrorPolicyStop 退出。
+	// 一个字节都没有写出的临时写错误（超时、Temporary() 为 true）同样重试；其余写入底层连接的失败
+	// 或连接已关闭时写方向不可恢复，仍立即退出。不能与 ErrorPolicyContinue 同用
+	MaxConsecutiveErrors int
+	// RetryBackoff 首次重试前的等待，之后每次连续失败翻倍，最长 1s（RetryBackoff 更长时为其本身）；
+	// 0 表示 10ms
+	RetryBackoff time.Duration
+	// OnInject 每条记录成功写出后在注入的 goroutine 中调用，传入该记录的元数据。
+	// 调用时不持有 Conn 的写方向锁与控制器的锁，慢回调不会阻塞真实写入，
+	// 也可以在回调中调用控制器方法；但回调耗时会直接拖慢注入节奏
+	OnInject func(InjectMeta)
+	// OnBeforeInject 每次注入前以采样得到的长度调用，返回值替换本条记录的负载长度，
+	// 需在 [0, 16384] 内；返回 false 跳过本次注入并计入 TicksSkipped。
+	// 调用时同样不持有任何锁，可以在回调中调用控制器方法
+	OnBeforeInject func(proposedLen int) (int, bool)
+	// Logger 输出启动、停止与错误日志，nil 时不输出
+	Logger FillGhostLogger
+	// Verbose 为 true 时每次注入也输出一条日志
+	Verbose bool
+	// InjectLogSize 大于 0 时控制器在定长环形缓冲中保留最近这么多项注入与跳过的元数据，
+	// 见 InjectLog 与 DumpJSON；0 表示不记录
+	InjectLogSize int
+	// InjectTimeout 大于 0 时，单条记录的注入（含等待写方向锁）最多阻塞这么久，超时后中断并以包装
+	// ErrFillGhostInjectTimeout 的 FillGhostError 按 ErrorPolicy 处理。等待写方向锁时中断只是放弃本次注入，
+	// 不影响持有锁的应用 Write；写出时中断借助底层连接的写截止时间，之后恢复应用设置的截止时间。
+	// 中断的记录已部分写出时连接的写方向随之失效，应用随后的 Write 也会失败；一个字节都没有写出时
+	// 写方向仍可用，可按 MaxConsecutiveErrors 重试。为 0 时不限，但 Stop 仍会在等待约 0.5s 后中断注入
+	InjectTimeout time.Duration
+	// Metrics 非 nil 时在注入成功、失败与每次等待间隔时调用，nil 时没有开销
+	Metrics FillGhostMetrics
+}
+
+// FillGhostBurst 突发模式参数：每次连续注入 BurstSize 条记录，然后等待 BurstInterval
+type FillGhostBurst struct {
+	BurstSize     int           // 每次突发的记录数，0 表示不使用突发模式
+	BurstInterval time.Duration // 两次突发之间的间隔
+	Jitter        time.Duration // 突发内相邻记录之间在 [0, Jitter] 内随机等待，0 表示背靠背发送
+}
+
+// recordType 返回外层记录类型，未设置时为应用数据
+func (cfg *FillGhostConfig) recordType() byte {
+	if cfg.RecordType == 0 {
+		return byte(recordTypeApplicationData)
+	}
+	return cfg.RecordType
+}
+
+// innerType 返回 TLS 1.3 填充记录的内层类型
+func (cfg *FillGhostConfig) innerType() byte {
+	switch {
+	case cfg.InnerContentType != 0:
+		return cfg.InnerContentType
+	case cfg.MarkInnerType:
+		return FillGhostContentType
+	}
+	return byte(recordTypeApplicationData)
+}
+
+// idleThreshold 返回注入前要求的最短静默时长，0 表示不检查
+func (cfg *FillGhostConfig) idleThreshold() time.Duration {
+	if cfg.IdleThreshold > 0 {
+		return cfg.IdleThreshold
+	}
+	if cfg.Adaptive {
+		return cfg.Interval
+	}
+	return 0
+}
+
+// InjectMeta 一条已注入记录的元数据，便于与抓包结果对照
+type InjectMeta struct {
+	Seq          uint64    // 记录使用的 TLS 写序号
+	PlaintextLen int       // 明文负载长度
+	WireLen      int       // 线上记录长度，含记录头与 AEAD 开销
+	Time         time.Time // 写出完成的时间
+}
+
+// FillGhostMetrics 计数器钩子，便于接入 expvar 或 Prometheus 一类的指标库而不引入依赖；
+// 方法在注入的 goroutine 中调用，不持有任何锁，需可并发调用（多个控制器共用一个实现时）。
+// 参考实现见子包 fillghostexpvar
+type FillGhostMetrics interface {
+	IncPackets()                     // 每条成功写出的记录一次
+	AddBytes(n int)                  // 同一条记录的线上字节数
+	IncErrors()                      // 每次计入 Stats().Errors 的失败一次
+	ObserveInterval(d time.Duration) // 每次按配置的节奏等待下一次注入（或下一次突发）之前一次
+}
+
+// FillGhostLogger 最小日志接口，*log.Logger 即满足
+type FillGhostLogger interface {
+	Printf(format string, args ...any)
+}
+
+// FillGhostLevelLogger 可选的分级日志接口。Logger 同时实现它时，启动、停止、暂停、恢复与
+// 写密钥轮换仍用 Printf，错误与重试改用 Warnf，每条记录的注入与跳过用 Debugf 输出（不需要 Verbose），
+// 且每类每秒至多一条，其间省略的条数附在下一条中
+type FillGhostLevelLogger interface {
+	FillGhostLogger
+	Debugf(format string, args ...any)
+	Warnf(format string, args ...any)
+}
+
+// fillGhostDebugLogEvery Debugf 输出的每类高频事件之间的最短间隔
+const fillGhostDebugLogEvery = time.Second
+
+// fillGhostLogLimiter 按 fillGhostDebugLogEvery 限流一类高频日志
+type fillGhostLogLimiter struct {
+	mu         sync.Mutex
+	last       time.Time
+	suppressed int // 上次输出之后被省略的条数
+}
+
+// allow 报告 now 时是否可以输出，可以时返回此前省略的条数并清零
+func (l *fillGhostLogLimiter) allow(now time.Time) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.last.IsZero() && now.Sub(l.last) < fillGhostDebugLogEvery {
+		l.suppressed++
+		return false, 0
+	}
+	n := l.suppressed
+	l.last, l.suppressed = now, 0
+	return true, n
+}
+
+// observeInterval 将循环选定的等待时间交给 Metrics
+func (cfg *FillGhostConfig) observeInterval(d time.Duration) {
+	if cfg.Metrics != nil {
+		cfg.Metrics.ObserveInterval(d)
+	}
+}
+
+// logf 通过 Logger 输出日志
+func (cfg *FillGhostConfig) logf(format string, args ...any) {
+	if cfg.Logger != nil {
+		cfg.Logger.Printf("fillghost: "+format, args...)
+	}
+}
+
+// warnf 输出错误与重试日志，Logger 实现 FillGhostLevelLogger 时用 Warnf
+func (cfg *FillGhostConfig) warnf(format string, args ...any) {
+	if l, ok := cfg.Logger.(FillGhostLevelLogger); ok {
+		l.Warnf("fillghost: "+format, args...)
+		return
+	}
+	cfg.logf(format, args...)
+}
+
+// debugf 在 Logger 实现 FillGhostLevelLogger 时以 Debugf 输出一条高频日志，按 lim 限流
+func (cfg *FillGhostConfig) debugf(lim *fillGhostLogLimiter, format string, args ...any) {
+	l, ok := cfg.Logger.(FillGhostLevelLogger)
+	if !ok {
+		return
+	}
+	allowed, suppressed := lim.allow(cfg.clock().Now())
+	if !allowed {
+		return
+	}
+	if suppressed > 0 {
+		format += " (%d similar messages suppressed)"
+		args = append(args, suppressed)
+	}
+	l.Debugf("fillghost: "+format, args...)
+}
+
+// fillGhostMaxPayload 单条 TLS 1.3 记录可承载的最大负载（不含内层类型字节）
+const fillGhostMaxPayload = maxPlaintext
+
+// fillGhostMaxFragmented Fragment 时单次负载的长度上限
+const fillGhostMaxFragmented = 64 * fillGhostMaxPayload
+
+// fillGhostLearnMinSamples Learn 模式开始使用观测长度所需的最少样本数
+const fillGhostLearnMinSamples = 16
+
+// maxLength 返回单次负载允许的最大长度
+func (cfg *FillGhostConfig) maxLength() int {
+	if cfg.Fragment {
+		return fillGhostMaxFragmented
+	}
+	return fillGhostMaxPayload
+}
+
+// markerLen 返回负载开头供对端识别的前缀长度：Magic 或 HMAC 标签
+func (cfg *FillGhostConfig) markerLen() int {
+	if cfg.TaggedMarker {
+		return FillGhostTagLen
+	}
+	return len(cfg.Magic)
+}
+
+// Validate 检查配置是否合法
+func (cfg *FillGhostConfig) Validate() error {
+	switch {
+	case cfg.MinLen < 0:
+		return fmt.Errorf("fillghost: MinLen %d is negative", cfg.MinLen)
+	case cfg.MaxLen < 0:
+		return fmt.Errorf("fillghost: MaxLen %d is negative", cfg.MaxLen)
+	case cfg.MinLen > cfg.MaxLen:
+		return fmt.Errorf("fillghost: MinLen %d is greater than MaxLen %d", cfg.MinLen, cfg.MaxLen)
+	case cfg.MaxLen > fillGhostMaxFragmented && cfg.Fragment:
+		return fmt.Errorf("fillghost: MaxLen %d exceeds the fragmented payload limit of %d bytes", cfg.MaxLen, fillGhostMaxFragmented)
+	case cfg.MaxLen > fillGhostMaxPayload && !cfg.Fragment:
+		return fmt.Errorf("fillghost: MaxLen %d exceeds the TLS record limit of %d bytes; set Fragment to split larger payloads", cfg.MaxLen, fillGhostMaxPayload)
+	case cfg.FragmentJitter < 0 || cfg.FragmentJitter > fillGhostMaxPayload/2:
+		return fmt.Errorf("fillghost: FragmentJitter %d is outside [0, %d]", cfg.FragmentJitter, fillGhostMaxPayload/2)
+	case cfg.FragmentJitter > 0 && !cfg.Fragment:
+		return errors.New("fillghost: FragmentJitter requires Fragment")
+	case cfg.Interval < 0:
+		return fmt.Errorf("fillghost: Interval %v is negative", cfg.Interval)
+	case cfg.InitialDelay < 0:
+		return fmt.Errorf("fillghost: InitialDelay %v is negative", cfg.InitialDelay)
+	case cfg.InjectTimeout < 0:
+		return fmt.Errorf("fillghost: InjectTimeout %v is negative", cfg.InjectTimeout)
+	case cfg.InjectLogSize < 0:
+		return fmt.Errorf("fillghost: InjectLogSize %d is negative", cfg.InjectLogSize)
+	case cfg.OpeningBurst < 0:
+		return fmt.Errorf("fillghost: OpeningBurst %d is negative", cfg.OpeningBurst)
+	case cfg.OpeningBurstJitter < 0:
+		return fmt.Errorf("fillghost: OpeningBurstJitter %v is negative", cfg.OpeningBurstJitter)
+	case cfg.InitialDelayJitter < 0:
+		return fmt.Errorf("fillghost: InitialDelayJitter %v is negative", cfg.InitialDelayJitter)
+	case cfg.InitialDelayJitter > math.MaxInt64-cfg.InitialDelay:
+		return fmt.Errorf("fillghost: InitialDelay %v plus InitialDelayJitter %v overflows", cfg.InitialDelay, cfg.InitialDelayJitter)
+	case cfg.Jitter < 0:
+		return fmt.Errorf("fillghost: Jitter %v is negative", cfg.Jitter)
+	case cfg.Warmup < 0:
+		return fmt.Errorf("fillghost: Warmup %v is negative", cfg.Warmup)
+	case cfg.WarmupInterval < 0:
+		return fmt.Errorf("fillghost: WarmupInterval %v is negative", cfg.WarmupInterval)
+	case cfg.IntervalMin < 0:
+		return fmt.Errorf("fillghost: IntervalMin %v is negative", cfg.IntervalMin)
+	case cfg.IntervalMax < 0:
+		return fmt.Errorf("fillghost: IntervalMax %v is negative", cfg.IntervalMax)
+	case cfg.IntervalMin > cfg.IntervalMax:
+		return fmt.Errorf("fillghost: IntervalMin %v is greater than IntervalMax %v", cfg.IntervalMin, cfg.IntervalMax)
+	case cfg.IntervalCap < 0:
+		return fmt.Errorf("fillghost: IntervalCap %v is negative", cfg.IntervalCap)
+	case cfg.InnerContentType != 0 && cfg.InnerContentType != byte(recordTypeApplicationData) && cfg.InnerContentType < 0x1b:
+		return fmt.Errorf("fillghost: InnerContentType %#02x is a TLS protocol content type the peer would parse", cfg.InnerContentType)
+	case cfg.MarkInnerType && cfg.InnerContentType != 0 && cfg.InnerContentType != FillGhostContentType:
+		return fmt.Errorf("fillghost: MarkInnerType conflicts with InnerContentType %#02x", cfg.InnerContentType)
+	case cfg.RecordVersion != [2]byte{} && (cfg.RecordVersion[0] != 3 || cfg.RecordVersion[1] > 4):
+		return fmt.Errorf("fillghost: RecordVersion % x is not a TLS version", cfg.RecordVersion[:])
+	case cfg.RecordType != 0 && (cfg.RecordType < byte(recordTypeChangeCipherSpec) || cfg.RecordType > byte(recordTypeApplicationData)):
+		return fmt.Errorf("fillghost: RecordType %#02x is not a known TLS content type", cfg.RecordType)
+	case cfg.MaxPacketsPerSec < 0:
+		return fmt.Errorf("fillghost: MaxPacketsPerSec %d is negative", cfg.MaxPacketsPerSec)
+	case cfg.TargetBytesPerSec < 0:
+		return fmt.Errorf("fillghost: TargetBytesPerSec %d is negative", cfg.TargetBytesPerSec)
+	case cfg.TargetBytesPerSec > 0 && (cfg.Interval > 0 || cfg.IntervalMax > 0 || cfg.Distribution != DistributionUniform):
+		return errors.New("fillghost: TargetBytesPerSec and Interval are mutually exclusive")
+	case cfg.MaxOverheadRatio < 0 || math.IsNaN(cfg.MaxOverheadRatio) || math.IsInf(cfg.MaxOverheadRatio, 0):
+		return fmt.Errorf("fillghost: MaxOverheadRatio %v is not a finite non-negative number", cfg.MaxOverheadRatio)
+	case cfg.IdleThreshold < 0:
+		return fmt.Errorf("fillghost: IdleThreshold %v is negative", cfg.IdleThreshold)
+	case cfg.Duration < 0:
+		return fmt.Errorf("fillghost: Duration %v is negative", cfg.Duration)
+	case cfg.MaxDuration < 0:
+		return fmt.Errorf("fillghost: MaxDuration %v is negative", cfg.MaxDuration)
+	case cfg.TaggedMarker && len(cfg.Magic) > 0:
+		return errors.New("fillghost: TaggedMarker and Magic are mutually exclusive")
+	case len(cfg.Magic) > fillGhostMaxPayload:
+		return fmt.Errorf("fillghost: Magic of %d bytes exceeds the TLS record limit of %d bytes", len(cfg.Magic), fillGhostMaxPayload)
+	case cfg.BurstMin < 0:
+		return fmt.Errorf("fillghost: BurstMin %d is negative", cfg.BurstMin)
+	case cfg.BurstMax < 0:
+		return fmt.Errorf("fillghost: BurstMax %d is negative", cfg.BurstMax)
+	case cfg.BurstMin > cfg.BurstMax:
+		return fmt.Errorf("fillghost: BurstMin %d is greater than BurstMax %d", cfg.BurstMin, cfg.BurstMax)
+	case cfg.BurstMax > 0 && cfg.Burst.BurstSize > 0:
+		return errors.New("fillghost: BurstMin/BurstMax and Burst are mutually exclusive")
+	case cfg.Burst.BurstSize < 0:
+		return fmt.Errorf("fillghost: BurstSize %d is negative", cfg.Burst.BurstSize)
+	case cfg.Burst.BurstInterval < 0:
+		return fmt.Errorf("fillghost: BurstInterval %v is negative", cfg.Burst.BurstInterval)
+	case cfg.Burst.Jitter < 0:
+		return fmt.Errorf("fillghost: burst Jitter %v is negative", cfg.Burst.Jitter)
+	}
+	if err := validateLengthWeights(cfg.LengthWeights, cfg.maxLength()); err != nil {
+		return err
+	}
+	if err := validateLengthBuckets(cfg.LengthBuckets, cfg.maxLength()); err != nil {
+		return err
+	}
+	if cfg.Mirror && cfg.Learn {
+		return errors.New("fillghost: Mirror and Learn are mutually exclusive")
+	}
+	if err := validateWindows(cfg.ActiveWindows); err != nil {
+		return err
+	}
+	if len(cfg.ActiveWindows) > 0 && len(cfg.Schedule) > 0 {
+		return errors.New("fillghost: ActiveWindows and Schedule are mutually exclusive")
+	}
+	for i, off := range cfg.Schedule {
+		if off < 0 {
+			return fmt.Errorf("fillghost: Schedule offset %v is negative", off)
+		}
+		if i > 0 && off <= cfg.Schedule[i-1] {
+			return fmt.Errorf("fillghost: Schedule offsets must be strictly increasing, got %v after %v", off, cfg.Schedule[i-1])
+		}
+	}
+	switch cfg.LengthDistribution {
+	case LengthDistributionUniform:
+	case LengthDistributionNormal:
+		switch {
+		case len(cfg.LengthWeights) > 0:
+			return errors.New("fillghost: LengthWeights and LengthDistributionNormal are mutually exclusive")
+		case math.IsNaN(cfg.LengthMean) || cfg.LengthMean < float64(cfg.MinLen) || cfg.LengthMean > float64(cfg.MaxLen):
+			return fmt.Errorf("fillghost: LengthMean %v is outside [MinLen, MaxLen] = [%d, %d]", cfg.LengthMean, cfg.MinLen, cfg.MaxLen)
+		case !(cfg.LengthStdDev >= 0) || math.IsInf(cfg.LengthStdDev, 0):
+			return fmt.Errorf("fillghost: LengthStdDev %v must be finite and non-negative", cfg.LengthStdDev)
+		}
+	default:
+		return fmt.Errorf("fillghost: unknown LengthDistribution %d", cfg.LengthDistribution)
+	}
+	switch cfg.Distribution {
+	case DistributionUniform, DistributionFixed:
+	case DistributionPoisson:
+		switch {
+		case cfg.IntervalMean < 0:
+			return fmt.Errorf("fillghost: IntervalMean %v is negative", cfg.IntervalMean)
+		case cfg.IntervalMean > 0 && cfg.Rate != 0:
+			return errors.New("fillghost: Rate and IntervalMean are mutually exclusive")
+		case cfg.IntervalMean == 0 && (!(cfg.Rate > 0) || math.IsInf(cfg.Rate, 0)):
+			return fmt.Errorf("fillghost: Poisson distribution requires IntervalMean or a finite Rate > 0, got Rate %v", cfg.Rate)
+		}
+	default:
+		return fmt.Errorf("fillghost: unknown Distribution %d", cfg.Distribution)
+	}
+	if cfg.ErrorPolicy != ErrorPolicyStop && cfg.ErrorPolicy != ErrorPolicyContinue {
+		return fmt.Errorf("fillghost: unknown ErrorPolicy %d", cfg.ErrorPolicy)
+	}
+	switch {
+	case cfg.MaxConsecutiveErrors < 0:
+		return fmt.Errorf("fillghost: MaxConsecutiveErrors %d is negative", cfg.MaxConsecutiveErrors)
+	case cfg.RetryBackoff < 0:
+		return fmt.Errorf("fillghost: RetryBackoff %v is negative", cfg.RetryBackoff)
+	case cfg.MaxConsecutiveErrors > 0 && cfg.ErrorPolicy == ErrorPolicyContinue:
+		return errors.New("fillghost: MaxConsecutiveErrors and ErrorPolicyContinue are mutually exclusive")
+	}
+	return nil
 }
 
 // FillGhostController 控制自动注入
@@ -24,116 +656,1531 @@ type FillGhostController struct {
 	stoppedCh chan struct{}
 	mu        sync.Mutex
 	active    bool
+	ran       bool // 是否启动过
+	paused    bool
+	resumeCh  chan struct{}        // 暂停期间由 Resume 关闭
+	injectMu  sync.Mutex           // 循环注入期间持有，Pause 借此等待进行中的注入
+	sinkMu    sync.Mutex           // Sink 模式下每条记录从封装到统计整体持有，先于 injectMu 获取
+	err       error                // 最近一轮循环因错误退出时的错误
+	autoErr   error                // startAuto 启动失败的错误，与循环自行停止的原因分开记录
+	intr      fillGhostInterrupter // 中断进行中的注入，供 InjectTimeout 与 Stop 使用
+	errCh     chan error           // 每次注入失败的错误，满时丢弃
+	sleeping  bool                 // 循环正在等待下一次注入
+	nextAt    time.Time            // sleeping 时下一次注入的预定时间
+	manager   *FillGhostManager    // 经 FillGhostManager.Attach 创建时所属的管理器
+	injectLog fillGhostInjectLog
+	lastSeq   uint64              // 最近一条记录的写序号，用于发现写密钥轮换，受 statsMu 保护
+	injectLim fillGhostLogLimiter // 注入的 Debugf 日志限流
+	skipLim   fillGhostLogLimiter // 跳过的 Debugf 日志限流
+
+	statsMu sync.Mutex
+	stats   FillGhostStats
+}
+
+// FillGhostStats 注入统计
+type FillGhostStats struct {
+	PacketsInjected        uint64    // 成功注入的记录数
+	BytesInjectedPlaintext uint64    // 注入的明文负载字节数
+	BytesOnWire            uint64    // 写到线上的字节数（含记录头与AEAD开销）
+	LastInjectAt           time.Time // 最近一次成功注入的时间
+	LastPayloadLen         int       // 最近一条成功注入的记录的明文负载长度
+	Errors                 uint64    // 注入失败次数，含循环为间隔、抖动、突发大小或分片取随机数的失败
+	TicksSkipped           uint64    // 因 SuppressWhenBusy、MaxOverheadRatio、FillGhostManager 或 OnBeforeInject 跳过的注入次数
+	Retries                uint64    // 按 MaxConsecutiveErrors 退避重试的次数
+	// StartedAt 最近一次 Start 的时间，未启动过时为零值；
+	// 平均注入吞吐可用 BytesOnWire / time.Since(StartedAt) 估算
+	StartedAt time.Time
+	// StopReason 最近一轮因达到限额或连接关闭而自行停止的原因（如 ErrFillGhostBudgetExhausted），
+	// 运行中、调用 Stop 或因错误退出时为 nil
+	StopReason error
+}
+
+// AvgPayloadLen 返回已注入记录的平均明文负载长度（不含记录头与 AEAD 开销），尚未注入时为 0；
+// 可用于在运行中确认长度分布的配置是否如预期
+func (s FillGhostStats) AvgPayloadLen() float64 {
+	if s.PacketsInjected == 0 {
+		return 0
+	}
+	return float64(s.BytesInjectedPlaintext) / float64(s.PacketsInjected)
+}
+
+// NewFillGhostControllerChecked 与 NewFillGhostController 相同，但先用 Validate 检查配置，
+// 使配置错误在构造时而不是 Start 时暴露
+func NewFillGhostControllerChecked(c *Conn, cfg FillGhostConfig) (*FillGhostController, error) {
+	if c == nil {
+		return nil, errors.New("fillghost: nil Conn")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	fg := NewFillGhostController(c, cfg)
+	if err := fg.checkRecordLimit(&cfg); err != nil {
+		return nil, err
+	}
+	return fg, nil
 }
 
-// NewFillGhostController 构造控制器
+// NewFillGhostController 构造控制器，不检查配置；配置错误在 Start 时返回
 func NewFillGhostController(c *Conn, cfg FillGhostConfig) *FillGhostController {
 	return &FillGhostController{
 		c:         c,
 		cfg:       cfg,
 		stopCh:    make(chan struct{}),
 		stoppedCh: make(chan struct{}),
+		errCh:     make(chan error, fillGhostErrorBuffer),
 	}
 }
 
-// Start 开始注入
+// Start 开始注入，配置不合法时返回错误；Conn 尚未完成握手时返回 ErrFillGhostHandshakeNotComplete
 func (fg *FillGhostController) Start() error {
+	return fg.StartContext(context.Background())
+}
+
+// StartAfterHandshake 经 Conn.HandshakeContext 等待握手完成后再以 StartContext(ctx) 开始注入，
+// InitialDelay 因此从握手完成时起算。握手尚未开始时由本调用驱动；已完成时立即启动。
+// 握手失败时返回其错误；与 HandshakeContext 相同，握手期间 ctx 结束会关闭连接
+func (fg *FillGhostController) StartAfterHandshake(ctx context.Context) error {
+	if fg.c == nil {
+		return ErrFillGhostHandshakeNotComplete
+	}
+	if err := fg.c.HandshakeContext(ctx); err != nil {
+		return err
+	}
+	return fg.StartContext(ctx)
+}
+
+// StartContext 与 Start 相同，ctx 取消或超时后注入自动停止，
+// 效果等同于调用 Stop；之后再调用 Stop 是安全的空操作。
+// ctx 已经结束时不启动并返回 ctx.Err()
+func (fg *FillGhostController) StartContext(ctx context.Context) error {
 	fg.mu.Lock()
 	defer fg.mu.Unlock()
+	return fg.startLocked(ctx)
+}
+
+// startLocked 启动注入协程，调用方需持有 fg.mu
+func (fg *FillGhostController) startLocked(ctx context.Context) error {
 	if fg.active {
 		return errors.New("FillGhost already running")
 	}
+	// StopTimeout 超时后上一轮协程可能仍阻塞在写操作中
+	if fg.ran && !fg.loopExitedLocked() {
+		return errors.New("fillghost: previous injection loop has not exited yet")
+	}
+	if err := fg.cfg.Validate(); err != nil {
+		return err
+	}
+	if fg.c == nil || !fg.c.handshakeComplete() {
+		return ErrFillGhostHandshakeNotComplete
+	}
+	if err := fg.c.fillGhostCheckCipher(); err != nil {
+		return err
+	}
+	if err := fg.checkRecordLimit(&fg.cfg); err != nil {
+		return err
+	}
+	if fg.cfg.RequireNegotiation && (fg.c == nil || !fg.c.FillGhostNegotiated()) {
+		return ErrFillGhostNotNegotiated
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// 本轮的 stopCh 与 stoppedCh 以参数交给注入协程，协程不再读取这两个字段；
+	// 字段只在持有 fg.mu 时替换，Stop 读到的总是最近一轮的通道
 	fg.stopCh = make(chan struct{})
-	fg.stoppedCh = make(chan struct{})
-	go fg.loop()
+	if fg.ran {
+		fg.stoppedCh = make(chan struct{})
+	}
+	// 首次启动沿用构造时创建的 stoppedCh，启动前取得的 Done() 也能在本轮结束时关闭
+	fg.ran = true
 	fg.active = true
+	fg.err = nil
+	fg.paused = false
+	fg.statsMu.Lock()
+	fg.stats.StartedAt = fg.cfg.clock().Now()
+	fg.stats.StopReason = nil
+	fg.statsMu.Unlock()
+	// IdleThreshold 与 Adaptive 比较的是 Conn 记录的真实写入时间，须与循环使用同一时钟
+	fg.c.fillGhostUseClock(fg.cfg.Clock)
+	go fg.loop(ctx, fg.stopCh, fg.stoppedCh)
 	return nil
 }
 
-// Stop 停止注入
+// Stop 停止注入，等待注入协程退出。可重复调用，也可与 Start、Restart 和循环的自行退出并发调用，
+// 第一次之后的调用只等待同一轮退出；未启动过时立即返回
 func (fg *FillGhostController) Stop() {
-	fg.mu.Lock()
-	defer fg.mu.Unlock()
-	if fg.active {
-		close(fg.stopCh)
-		<-fg.stoppedCh
-		fg.active = false
-	}
+	fg.StopTimeout(0)
 }
 
-// loop 内部注入循环
-func (fg *FillGhostController) loop() {
-	defer close(fg.stoppedCh)
-	if fg.cfg.InitialDelay > 0 {
-		select {
-		case <-time.After(fg.cfg.InitialDelay):
-		case <-fg.stopCh:
-			return
-		}
+// StopTimeout 与 Stop 相同，但最多等待 d；d <= 0 时一直等待。
+// 注入阻塞在拥塞或失去响应的连接上（写出中，或等待被应用 Write 占用的写方向锁）时，
+// 等待约 0.5s 后中断该注入，见 InjectTimeout；已部分写出的记录会使连接的写方向失效。
+// d 内仍未退出时返回 ErrFillGhostStopTimeout，此时控制器已标记为停止，协程退出后关闭 Done()；
+// 在此之前 Start 返回错误。Conn.Close 同样不会被阻塞的注入挡住：注入与 Write 一样计为进行中的
+// 调用，Close 因此不发送 close_notify，直接关闭底层连接，阻塞的注入随之返回
+func (fg *FillGhostController) StopTimeout(d time.Duration) error {
+	stoppedCh := fg.signalStop()
+	if stoppedCh == nil {
+		return nil
+	}
+	// 等待时不持有 fg.mu，loop 检查暂停状态时也需要该锁。
+	// 注入阻塞在已满的发送缓冲区或应用持有的写方向锁上时，宽限时间后中断该注入
+	grace := time.NewTimer(fillGhostStopGrace)
+	defer grace.Stop()
+	var timeout <-chan time.Time
+	if d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
 	}
 	for {
 		select {
-		case <-fg.stopCh:
-			return
-		default:
+		case <-stoppedCh:
+			return nil
+		case <-grace.C:
+			// 检查停止标志与开始注入之间的窗口内中断会落空，因此每个宽限时间重复一次
+			fg.intr.interrupt(ErrFillGhostInterrupted)
+			grace.Reset(fillGhostStopGrace)
+		case <-timeout:
+			return ErrFillGhostStopTimeout
 		}
-		err := fg.injectOne()
-		if err != nil {
-			// 可以log输出
-			return
+	}
+}
+
+// Restart 停止正在运行的注入循环，等待其退出后以当前配置重新启动；未运行时等同于 Start。
+// 新一轮使用 context.Background()，此前 StartContext 传入的 ctx 不再生效
+func (fg *FillGhostController) Restart() error {
+	return fg.restart(nil)
+}
+
+// RestartWithConfig 与 Restart 相同，但以 cfg 替换配置；cfg 不合法时返回错误，
+// 正在运行的循环不受影响
+func (fg *FillGhostController) RestartWithConfig(cfg FillGhostConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if err := fg.checkRecordLimit(&cfg); err != nil {
+		return err
+	}
+	return fg.restart(&cfg)
+}
+
+// restart 等待上一轮退出后在同一次持锁内替换配置并启动；等待期间若被其他调用方
+// 启动或停止，则重新停止、等待
+func (fg *FillGhostController) restart(cfg *FillGhostConfig) error {
+	for {
+		if stoppedCh := fg.signalStop(); stoppedCh != nil {
+			<-stoppedCh
 		}
-		if fg.cfg.Interval > 0 {
-			select {
-			case <-time.After(fg.cfg.Interval):
-			case <-fg.stopCh:
-				return
+		fg.mu.Lock()
+		if !fg.active && (!fg.ran || fg.loopExitedLocked()) {
+			if cfg != nil {
+				fg.cfg = *cfg
 			}
+			err := fg.startLocked(context.Background())
+			fg.mu.Unlock()
+			return err
 		}
+		fg.mu.Unlock()
 	}
 }
 
-// injectOne 生成并注入一个包
-func (fg *FillGhostController) injectOne() error {
-	aead := fg.c.ExportWriteAEAD()
-	if aead == nil {
-		return errors.New("fillghost: no AEAD cipher")
+// loopExitedLocked 报告最近一轮注入协程是否已退出，调用方需持有 fg.mu
+func (fg *FillGhostController) loopExitedLocked() bool {
+	select {
+	case <-fg.stoppedCh:
+		return true
+	default:
+		return false
 	}
-	seq := fg.c.ExportWriteSeq()
-	L, err := cryptoRandInt(fg.cfg.MinLen, fg.cfg.MaxLen)
-	if err != nil {
+}
+
+// signalStop 通知注入协程退出但不等待，返回其退出时关闭的通道；从未启动过时返回 nil
+func (fg *FillGhostController) signalStop() chan struct{} {
+	// active 只在持有 fg.mu 并新建 stopCh 后置为 true，关闭 stopCh 与清除 active 在同一次持锁内完成，
+	// 因此每个 stopCh 至多关闭一次；循环自行退出时只清除 active，不关闭 stopCh
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+	if fg.active {
+		close(fg.stopCh)
+		fg.active = false
+	} else if !fg.ran {
+		return nil
+	}
+	return fg.stoppedCh
+}
+
+// startAuto 由 Conn 在握手完成后调用，启动失败的错误记入 Err()，同时单独记入 autoStartErr
+func (fg *FillGhostController) startAuto() {
+	if err := fg.Start(); err != nil {
+		fg.mu.Lock()
+		fg.err = err
+		fg.autoErr = err
+		fg.mu.Unlock()
+	}
+}
+
+// autoStartErr 返回 startAuto 启动失败的错误；启动成功后循环自行停止（达到限额、写错误等）时仍为 nil
+func (fg *FillGhostController) autoStartErr() error {
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+	return fg.autoErr
+}
+
+// UpdateConfig 在运行中原子替换配置，下一轮注入起生效；
+// 新配置不合法时返回错误且不做任何修改
+func (fg *FillGhostController) UpdateConfig(cfg FillGhostConfig) error {
+	if err := cfg.Validate(); err != nil {
 		return err
 	}
-	payload := make([]byte, L)
-	_, err = rand.Read(payload)
-	if err != nil {
+	if err := fg.checkRecordLimit(&cfg); err != nil {
 		return err
 	}
-	padded := append(payload, byte(0x17))
-	header := []byte{0x17, 0x03, 0x03, 0, 0}
-	ciphertext := aead.Seal(nil, seq[:], padded, header[:5])
-	ln := len(ciphertext)
-	header[3] = byte(ln >> 8)
-	header[4] = byte(ln)
-	record := append(header, ciphertext...)
-	if err := fg.c.FillGhostInjectRawRecord(record); err != nil {
+	fg.mu.Lock()
+	fg.cfg = cfg
+	fg.mu.Unlock()
+	return nil
+}
+
+// SetLengthCDF 以经验累积分布替换当前配置的 LengthSampler，校验规则见 NewCDFLengthSampler；
+// 运行中调用从下一次注入起生效
+func (fg *FillGhostController) SetLengthCDF(sizes []int, cumulative []float64) error {
+	s, err := NewCDFLengthSampler(sizes, cumulative)
+	if err != nil {
 		return err
 	}
-	fg.c.FillGhostIncWriteSeq()
+	fg.mu.Lock()
+	fg.cfg.LengthSampler = s
+	fg.mu.Unlock()
 	return nil
 }
 
-// cryptoRandInt [min, max] 闭区间
-func cryptoRandInt(min, max int) (int, error) {
-	if min == max {
-		return min, nil
+// InjectNow 立即同步注入 n 条记录，长度与内容按当前配置生成。无论注入循环是否运行、
+// 是否暂停都可调用，与循环及真实 Conn.Write 串行执行。遇到第一个错误即停止，
+// 返回实际注入的条数；MaxTotalBytes、MaxOverheadRatio 与 FillGhostManager 的限额同样适用。
+// 错误与循环中的注入相同：握手完成前为 ErrFillGhostNoAEAD；随机源、负载生成或写出失败为
+// *FillGhostError，连接关闭后其中包装的是 ErrFillGhostClosed，可用 errors.Is 判断
+func (fg *FillGhostController) InjectNow(n int) (int, error) {
+	cfg := fg.config()
+	if err := cfg.Validate(); err != nil {
+		return 0, err
 	}
-	if min > max {
-		return 0, errors.New("fillghost: min > max")
+	for i := 0; i < n; i++ {
+		L, err := fg.proposeLength(&cfg)
+		if err != nil {
+			return i, err
+		}
+		if _, _, _, err := fg.injectPayload(&cfg, L, false); err != nil {
+			return i, err
+		}
 	}
-	diff := big.NewInt(int64(max - min + 1))
-	n, err := rand.Int(rand.Reader, diff)
-	if err != nil {
-		return 0, err
+	return n, nil
+}
+
+// Done 返回在注入协程退出时关闭的通道，无论退出原因是 Stop、ctx 取消、达到限额自行停止、
+// 注入失败还是连接关闭；只读，可在 select 中与其他事件一起等待。
+// 对应当前（或最近一次）的运行；Start 之前取得的通道在第一次运行结束时关闭
+func (fg *FillGhostController) Done() <-chan struct{} {
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+	return fg.stoppedCh
+}
+
+// Wait 阻塞直到当前运行结束，返回导致退出的错误（同 Err）
+func (fg *FillGhostController) Wait() error {
+	<-fg.Done()
+	return fg.Err()
+}
+
+// Errors 返回注入错误通道，每次注入失败都会尝试发送一次；
+// 通道带缓冲，读取不及时的错误会被丢弃，不会阻塞注入循环
+func (fg *FillGhostController) Errors() <-chan error {
+	return fg.errCh
+}
+
+// Err 返回最近一轮注入循环因错误或达到限额退出时的原因；正常停止或仍在运行时返回 nil
+func (fg *FillGhostController) Err() error {
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+	return fg.err
+}
+
+// config 返回当前配置的快照
+func (fg *FillGhostController) config() FillGhostConfig {
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+	return fg.cfg
+}
+
+// NextInjectAt 返回注入循环下一次醒来注入的预定时间（按 Clock），ok 表示循环正在等待；
+// 正在注入、暂停、未运行时返回零值与 false。Adaptive 模式下醒来后仍可能因真实写入而继续等待
+func (fg *FillGhostController) NextInjectAt() (at time.Time, ok bool) {
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+	if !fg.active || !fg.sleeping {
+		return time.Time{}, false
 	}
-	return int(n.Int64()) + min, nil
+	return fg.nextAt, true
+}
+
+// Stats 返回统计快照，可在注入运行时并发调用
+func (fg *FillGhostController) Stats() FillGhostStats {
+	fg.statsMu.Lock()
+	defer fg.statsMu.Unlock()
+	return fg.stats
+}
+
+// Pause 暂停注入，注入协程保持运行。若有注入正在进行，等其完成后才返回，
+// 返回后不会再有新的注入开始。已暂停或未运行时为空操作
+func (fg *FillGhostController) Pause() {
+	fg.mu.Lock()
+	if !fg.active {
+		fg.mu.Unlock()
+		return
+	}
+	paused := !fg.paused
+	if paused {
+		fg.paused = true
+		fg.resumeCh = make(chan struct{})
+	}
+	cfg := fg.cfg
+	fg.mu.Unlock()
+	if paused {
+		cfg.logf("injection paused")
+	}
+	// 循环持有 injectMu 时会再次检查暂停状态，拿到锁即说明进行中的注入已结束
+	fg.injectMu.Lock()
+	fg.injectMu.Unlock()
+}
+
+// Resume 恢复注入；未暂停时为空操作
+func (fg *FillGhostController) Resume() {
+	fg.mu.Lock()
+	if !fg.paused {
+		fg.mu.Unlock()
+		return
+	}
+	fg.paused = false
+	close(fg.resumeCh)
+	cfg := fg.cfg
+	fg.mu.Unlock()
+	cfg.logf("injection resumed")
+}
+
+// waitResumed 暂停期间阻塞，直到恢复或停止；返回 false 表示已停止
+func (fg *FillGhostController) waitResumed(ctx context.Context, stopCh <-chan struct{}) bool {
+	for {
+		fg.mu.Lock()
+		paused, resumeCh := fg.paused, fg.resumeCh
+		fg.mu.Unlock()
+		if !paused {
+			return true
+		}
+		select {
+		case <-resumeCh:
+		case <-stopCh:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// sleep 按本轮的时钟等待 d；期间停止或 ctx 取消则返回 false。
+// 整轮复用同一个定时器，避免每次等待都创建新的定时器
+func (run *fillGhostRun) sleep(ctx context.Context, stopCh <-chan struct{}, d time.Duration) bool {
+	run.setNext(stopCh, run.clock.Now().Add(d), true)
+	defer run.setNext(stopCh, time.Time{}, false)
+	if run.timer == nil {
+		run.timer = run.clock.NewTimer(d)
+	} else {
+		run.timer.Reset(d)
+	}
+	select {
+	case <-run.timer.C():
+		return true
+	case <-stopCh:
+	case <-ctx.Done():
+	}
+	run.stopTimer()
+	return false
+}
+
+// setNext 记录本轮是否在等待及预定的唤醒时间；StopTimeout 超时后残留的旧一轮不覆盖新一轮的记录
+func (run *fillGhostRun) setNext(stopCh <-chan struct{}, at time.Time, sleeping bool) {
+	if run.fg == nil {
+		return
+	}
+	run.fg.mu.Lock()
+	if run.fg.stopCh == stopCh {
+		run.fg.nextAt, run.fg.sleeping = at, sleeping
+	}
+	run.fg.mu.Unlock()
+}
+
+// stopTimer 停止定时器并清空可能已经到期的值，使下次 Reset 后不会立即返回
+func (run *fillGhostRun) stopTimer() {
+	if run.timer != nil && !run.timer.Stop() {
+		select {
+		case <-run.timer.C():
+		default:
+		}
+	}
+}
+
+// loop 内部注入循环
+func (fg *FillGhostController) loop(parent context.Context, stopCh <-chan struct{}, stoppedCh chan struct{}) {
+	cfg := fg.config()
+	run := fillGhostRun{fg: fg, clock: cfg.clock()}
+	defer run.stopTimer()
+	start := run.clock.Now()
+	// Conn 关闭或 Duration 到期时取消 ctx，唤醒所有等待，循环随即自行停止
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	var expired <-chan time.Time
+	if d := cfg.runDuration(); d > 0 {
+		// Duration 从启动时起算，InitialDelay 与暂停的时间也计入；
+		// 按 Clock 计时，因此不用 context.WithTimeout
+		t := run.clock.NewTimer(d)
+		defer t.Stop()
+		expired = t.C()
+	}
+	go func() {
+		select {
+		case <-expired:
+			cancel()
+		case <-fg.c.fillGhostCloseNotify():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	defer func() {
+		if ctx.Err() != nil && parent.Err() == nil && fg.Err() == nil {
+			select {
+			case <-stopCh:
+			default:
+				reason := ErrFillGhostDurationElapsed
+				if fg.c.FillGhostClosed() {
+					reason = ErrFillGhostClosed
+				}
+				fg.selfStop(stoppedCh, reason)
+			}
+		}
+		// 自行退出（ctx 取消、注入失败）时同样标记为未运行，
+		// 仅当本轮仍是当前运行时才修改，避免影响之后的 Start
+		fg.mu.Lock()
+		if fg.stoppedCh == stoppedCh {
+			fg.active = false
+		}
+		cfg := fg.cfg
+		fg.mu.Unlock()
+		cfg.logf("injection stopped")
+		close(stoppedCh)
+	}()
+	cfg.logf("injection started (len %d-%d, interval %v)", cfg.MinLen, cfg.MaxLen, cfg.Interval)
+	if len(cfg.Schedule) > 0 {
+		fg.runSchedule(ctx, stopCh, stoppedCh, start, &cfg, &run)
+		return
+	}
+	d := cfg.InitialDelay
+	if cfg.InitialDelayJitter > 0 {
+		j, err := cryptoRandDuration(cfg.random(), 0, cfg.InitialDelayJitter)
+		if err != nil {
+			if fg.handleRandError(stoppedCh, err) {
+				return
+			}
+			j = 0
+		}
+		d += j
+	}
+	if d > 0 {
+		if !run.sleep(ctx, stopCh, d) {
+			return
+		}
+	}
+	if cfg.OpeningBurst > 0 && !fg.openingBurst(ctx, stopCh, stoppedCh, start, &cfg, &run) {
+		return
+	}
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+		if !fg.waitResumed(ctx, stopCh) {
+			return
+		}
+		cfg := fg.config()
+		if d := cfg.untilWindow(run.clock.Now()); d > 0 {
+			// 不在任何时段内：分段等待，以便及时看到 UpdateConfig 的修改
+			if d > fillGhostWindowPoll {
+				d = fillGhostWindowPoll
+			}
+			if !run.sleep(ctx, stopCh, d) {
+				return
+			}
+			continue
+		}
+		if threshold := cfg.idleThreshold(); threshold > 0 {
+			if idle := run.clock.Now().Sub(fg.c.FillGhostLastWriteTime()); idle < threshold {
+				// 应用仍在发送真实数据，等到静默满 threshold 再注入
+				if !run.sleep(ctx, stopCh, threshold-idle) {
+					return
+				}
+				continue
+			}
+		}
+		n, err := cfg.burstSize()
+		if err != nil {
+			if fg.handleRandError(stoppedCh, err) {
+				return
+			}
+			n = 1
+		}
+		skipped, done, err := fg.burst(ctx, stopCh, stoppedCh, &cfg, &run, n)
+		if done {
+			return
+		}
+		if cfg.Burst.BurstSize > 0 {
+			d := cfg.warmupInterval(cfg.Burst.BurstInterval, run.clock.Now().Sub(start))
+			if d < FillGhostMinInterval {
+				d = FillGhostMinInterval
+			}
+			cfg.observeInterval(d)
+			if !run.sleep(ctx, stopCh, d) {
+				return
+			}
+			continue
+		}
+		if skipped {
+			continue
+		}
+		held := err == errFillGhostBusy || err == ErrFillGhostOverheadLimit || err == ErrFillGhostSharedBudget
+		var d time.Duration
+		if cfg.TargetBytesPerSec > 0 {
+			if err == nil {
+				// 按本条记录的线上长度折算等待时间，平均吞吐收敛到目标值
+				d = time.Duration(run.wire) * time.Second / time.Duration(cfg.TargetBytesPerSec)
+			}
+		} else if d, err = fg.nextInterval(); err != nil && fg.handleRandError(stoppedCh, err) {
+			return
+		}
+		if !held {
+			d = cfg.warmupInterval(d, run.clock.Now().Sub(start))
+		}
+		if held && d < fillGhostBusyPoll {
+			d = fillGhostBusyPoll
+		}
+		if d < FillGhostMinInterval {
+			d = FillGhostMinInterval
+		}
+		if !held {
+			cfg.observeInterval(d)
+		}
+		if !run.sleep(ctx, stopCh, d) {
+			return
+		}
+	}
+}
+
+// runSchedule 依次等到 start 之后的各个偏移注入一条记录，全部完成后自行停止
+func (fg *FillGhostController) runSchedule(ctx context.Context, stopCh <-chan struct{}, stoppedCh chan struct{}, start time.Time, cfg *FillGhostConfig, run *fillGhostRun) {
+	for _, off := range cfg.Schedule {
+		if d := start.Add(off).Sub(run.clock.Now()); d > 0 && !run.sleep(ctx, stopCh, d) {
+			return
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+		if !fg.waitResumed(ctx, stopCh) {
+			return
+		}
+		if _, done, _ := fg.tick(ctx, stopCh, stoppedCh, run); done {
+			return
+		}
+	}
+	fg.selfStop(stoppedCh, ErrFillGhostScheduleDone)
+}
+
+// reportError 记录日志并把错误送入 Errors()
+func (fg *FillGhostController) reportError(cfg *FillGhostConfig, err error) {
+	cfg.warnf("injection error: %v", err)
+	select {
+	case fg.errCh <- err:
+	default:
+		// 读取方跟不上时丢弃，不阻塞注入
+	}
+}
+
+// fillGhostDefaultRetryBackoff 与 fillGhostMaxRetryBackoff RetryBackoff 的默认值与翻倍上限
+const (
+	fillGhostDefaultRetryBackoff = 10 * time.Millisecond
+	fillGhostMaxRetryBackoff     = time.Second
+)
+
+// retryBackoff 返回第 n 次连续失败后的退避时长
+func (cfg *FillGhostConfig) retryBackoff(n int) time.Duration {
+	d := cfg.RetryBackoff
+	if d == 0 {
+		d = fillGhostDefaultRetryBackoff
+	}
+	limit := fillGhostMaxRetryBackoff
+	if d > limit {
+		limit = d
+	}
+	for i := 1; i < n && d < limit; i++ {
+		d *= 2
+	}
+	if d > limit {
+		d = limit
+	}
+	return d
+}
+
+// retry 按 MaxConsecutiveErrors 处理一次注入错误：写方向仍可用且连续失败未达上限时上报、
+// 退避后返回 retried；退避期间停止则 done 为 true。否则交由 handleError 处理
+func (fg *FillGhostController) retry(ctx context.Context, stopCh <-chan struct{}, cfg *FillGhostConfig, run *fillGhostRun, err error) (retried, done bool) {
+	if cfg.MaxConsecutiveErrors <= 0 || fg.c.fillGhostWritable() != nil {
+		return false, false
+	}
+	if run.failures++; run.failures >= cfg.MaxConsecutiveErrors {
+		return false, false
+	}
+	fg.reportError(cfg, err)
+	fg.statsMu.Lock()
+	fg.stats.Retries++
+	fg.statsMu.Unlock()
+	d := cfg.retryBackoff(run.failures)
+	cfg.warnf("retrying in %v (%d/%d consecutive failures)", d, run.failures, cfg.MaxConsecutiveErrors)
+	return true, !run.sleep(ctx, stopCh, d)
+}
+
+// countError 把一次未经 injectCounted 或 proposeLength 计数的失败计入 Stats().Errors 与 Metrics
+func (fg *FillGhostController) countError(cfg *FillGhostConfig) {
+	fg.statsMu.Lock()
+	fg.stats.Errors++
+	fg.statsMu.Unlock()
+	if cfg.Metrics != nil {
+		cfg.Metrics.IncErrors()
+	}
+}
+
+// handleRandError 计数后按 handleError 处理循环自身取随机数（初始延迟、间隔、抖动与突发大小）的失败
+func (fg *FillGhostController) handleRandError(stoppedCh chan struct{}, err error) bool {
+	cfg := fg.config()
+	fg.countError(&cfg)
+	return fg.handleError(stoppedCh, &FillGhostError{Op: "rand", Err: err})
+}
+
+// handleError 上报错误并按 ErrorPolicy 决定是否退出循环，返回 true 表示应退出
+func (fg *FillGhostController) handleError(stoppedCh chan struct{}, err error) bool {
+	cfg := fg.config()
+	fg.reportError(&cfg, err)
+	if cfg.ErrorPolicy == ErrorPolicyContinue {
+		return false
+	}
+	fg.mu.Lock()
+	if fg.stoppedCh == stoppedCh {
+		fg.err = err
+	}
+	fg.mu.Unlock()
+	if cfg.OnError != nil {
+		cfg.OnError(err)
+	}
+	return true
+}
+
+// fillGhostRun 单轮注入循环内的状态
+type fillGhostRun struct {
+	fg    *FillGhostController // 等待时在其上记录 NextInjectAt，为 nil 时不记录
+	clock FillGhostClock       // 本轮使用的时钟
+	timer FillGhostTimer       // sleep 复用的定时器，首次等待时创建
+	sent  uint64               // 本轮成功注入的记录数
+	wire  int                  // 最近一次注入的线上字节数，分片时为各条记录之和
+	// nextToken MaxPacketsPerSec 下下一条记录最早的注入时间
+	nextToken time.Time
+	failures  int // 连续失败的注入次数，成功后清零
+}
+
+// waitToken 按 MaxPacketsPerSec 等到允许注入下一条记录；期间停止或 ctx 取消则返回 false
+func (run *fillGhostRun) waitToken(ctx context.Context, stopCh <-chan struct{}, cfg *FillGhostConfig) bool {
+	if cfg.MaxPacketsPerSec <= 0 {
+		return true
+	}
+	if d := run.nextToken.Sub(run.clock.Now()); d > 0 {
+		return run.sleep(ctx, stopCh, d)
+	}
+	return true
+}
+
+// takeTokens 扣除 records 条记录的令牌。桶容量为 1，空闲期间不会累积额度
+func (run *fillGhostRun) takeTokens(cfg *FillGhostConfig, records int) {
+	if cfg.MaxPacketsPerSec <= 0 || records == 0 {
+		return
+	}
+	if now := run.clock.Now(); run.nextToken.Before(now) {
+		run.nextToken = now
+	}
+	run.nextToken = run.nextToken.Add(time.Duration(records) * time.Second / time.Duration(cfg.MaxPacketsPerSec))
+}
+
+// tick 按 MaxPacketsPerSec 等待后注入一条记录并处理错误，成功时更新 run 并检查 MaxPackets。
+// skipped 表示因暂停未注入，done 表示循环应退出；err 为已按 ErrorPolicy 处理过的注入错误
+func (fg *FillGhostController) tick(ctx context.Context, stopCh <-chan struct{}, stoppedCh chan struct{}, run *fillGhostRun) (skipped, done bool, err error) {
+	cfg := fg.config()
+	if !run.waitToken(ctx, stopCh, &cfg) {
+		return false, true, nil
+	}
+	wire, records, skipped, err := fg.injectTick()
+	run.takeTokens(&cfg, records)
+	if err == errFillGhostBusy || err == ErrFillGhostOverheadLimit || err == ErrFillGhostSharedBudget {
+		return false, false, err
+	}
+	if err == ErrFillGhostVetoed {
+		// 跳过本次注入，按正常间隔等待下一次
+		return false, false, nil
+	}
+	if err == ErrFillGhostBudgetExhausted {
+		fg.selfStop(stoppedCh, err)
+		return false, true, err
+	}
+	if errors.Is(err, ErrFillGhostInterrupted) {
+		// Stop 中断了阻塞的注入，视同停止
+		return false, true, nil
+	}
+	if errors.Is(err, ErrFillGhostClosed) {
+		// 连接已关闭，无论 ErrorPolicy 如何都不会再成功
+		fg.selfStop(stoppedCh, ErrFillGhostClosed)
+		return false, true, err
+	}
+	if err != nil {
+		if retried, done := fg.retry(ctx, stopCh, &cfg, run, err); retried {
+			// 已退避，视同跳过本次注入，立即进入下一轮
+			return true, done, err
+		}
+		return false, fg.handleError(stoppedCh, err), err
+	}
+	run.failures = 0
+	if skipped {
+		return true, false, nil
+	}
+	run.sent += uint64(records)
+	run.wire = wire
+	if limit := cfg.MaxPackets; limit > 0 && run.sent >= limit {
+		fg.selfStop(stoppedCh, ErrFillGhostPacketLimit)
+		return false, true, nil
+	}
+	return false, false, nil
+}
+
+// burstSize 返回本次连续注入的记录数：Burst.BurstSize，或在 [BurstMin, BurstMax] 内随机取值，默认为 1
+func (cfg *FillGhostConfig) burstSize() (int, error) {
+	switch {
+	case cfg.Burst.BurstSize > 0:
+		return cfg.Burst.BurstSize, nil
+	case cfg.BurstMax > 0:
+		return cryptoRandInt(cfg.random(), cfg.BurstMin, cfg.BurstMax)
+	}
+	return 1, nil
+}
+
+// burst 连续注入 n 条记录，每条独立采样长度，记录之间检查停止信号；
+// 遇到错误或暂停时提前结束本次突发。返回值含义同 tick，对应最后一次注入
+func (fg *FillGhostController) burst(ctx context.Context, stopCh <-chan struct{}, stoppedCh chan struct{}, cfg *FillGhostConfig, run *fillGhostRun, n int) (skipped, done bool, err error) {
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			select {
+			case <-stopCh:
+				return false, true, nil
+			case <-ctx.Done():
+				return false, true, nil
+			default:
+			}
+			if cfg.Burst.Jitter > 0 {
+				d, err := cryptoRandDuration(cfg.random(), 0, cfg.Burst.Jitter)
+				if err != nil {
+					return false, fg.handleRandError(stoppedCh, err), &FillGhostError{Op: "rand", Err: err}
+				}
+				if !run.sleep(ctx, stopCh, d) {
+					return false, true, nil
+				}
+			}
+		}
+		skipped, done, err = fg.tick(ctx, stopCh, stoppedCh, run)
+		if done || skipped || err != nil {
+			return skipped, done, err
+		}
+	}
+	return false, false, nil
+}
+
+// openingBurst 注入 OpeningBurst 条间隔按 OpeningBurstJitter 随机的记录，再等待一个正常间隔；
+// 返回 false 表示循环应退出
+func (fg *FillGhostController) openingBurst(ctx context.Context, stopCh <-chan struct{}, stoppedCh chan struct{}, start time.Time, cfg *FillGhostConfig, run *fillGhostRun) bool {
+	if !fg.waitResumed(ctx, stopCh) {
+		return false
+	}
+	ob := *cfg
+	ob.Burst.Jitter = cfg.OpeningBurstJitter
+	if _, done, _ := fg.burst(ctx, stopCh, stoppedCh, &ob, run, cfg.OpeningBurst); done {
+		return false
+	}
+	d, err := fg.nextInterval()
+	if err != nil && fg.handleRandError(stoppedCh, err) {
+		return false
+	}
+	if d = cfg.warmupInterval(d, run.clock.Now().Sub(start)); d < FillGhostMinInterval {
+		d = FillGhostMinInterval
+	}
+	cfg.observeInterval(d)
+	return run.sleep(ctx, stopCh, d)
+}
+
+// selfStop 记录达到限额自行停止的原因，随后由 loop 退出
+func (fg *FillGhostController) selfStop(stoppedCh chan struct{}, reason error) {
+	fg.mu.Lock()
+	if fg.stoppedCh == stoppedCh {
+		fg.err = reason
+	}
+	cfg := fg.cfg
+	fg.mu.Unlock()
+	fg.statsMu.Lock()
+	fg.stats.StopReason = reason
+	fg.statsMu.Unlock()
+	cfg.logf("%v", reason)
+}
+
+// nextInterval 计算本次注入后的等待时间，每次重新采样，结果不超过 IntervalCap
+func (fg *FillGhostController) nextInterval() (time.Duration, error) {
+	cfg := fg.config()
+	d, err := cfg.sampleInterval()
+	if err != nil {
+		return 0, err
+	}
+	if cfg.IntervalCap > 0 && d > cfg.IntervalCap {
+		d = cfg.IntervalCap
+	}
+	return d, nil
+}
+
+// warmupInterval 按启动后经过的时间 elapsed 对间隔 d 做预热插值，预热结束后原样返回
+func (cfg *FillGhostConfig) warmupInterval(d, elapsed time.Duration) time.Duration {
+	if cfg.Warmup <= 0 || elapsed >= cfg.Warmup {
+		return d
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	from := cfg.WarmupInterval
+	if from == 0 {
+		from = fillGhostWarmupFactor * d
+	}
+	frac := float64(elapsed) / float64(cfg.Warmup)
+	return from + time.Duration(float64(d-from)*frac)
+}
+
+// sampleInterval 按配置的分布采样一次间隔
+func (cfg *FillGhostConfig) sampleInterval() (time.Duration, error) {
+	switch cfg.Distribution {
+	case DistributionFixed:
+		return cfg.Interval, nil
+	case DistributionPoisson:
+		mean := cfg.IntervalMean
+		if mean == 0 {
+			if !(cfg.Rate > 0) {
+				return 0, errors.New("fillghost: Poisson distribution requires Rate > 0")
+			}
+			mean = time.Duration(float64(time.Second) / cfg.Rate)
+		}
+		d, err := cryptoRandExp(cfg.random(), mean)
+		if err != nil {
+			return 0, err
+		}
+		if cfg.IntervalCap == 0 && d > fillGhostExpCapFactor*mean {
+			d = fillGhostExpCapFactor * mean
+		}
+		return d, nil
+	}
+	if cfg.IntervalMax > 0 {
+		return cryptoRandDuration(cfg.random(), cfg.IntervalMin, cfg.IntervalMax)
+	}
+	d := cfg.Interval
+	if cfg.Jitter <= 0 {
+		return d, nil
+	}
+	j, err := cryptoRandDuration(cfg.random(), -cfg.Jitter, cfg.Jitter)
+	if err != nil {
+		return 0, err
+	}
+	d += j
+	if d < 0 {
+		d = 0
+	}
+	return d, nil
+}
+
+// injectOne 生成并注入一个包，更新统计并调用 OnInject
+func (fg *FillGhostController) injectOne() error {
+	cfg := fg.config()
+	L, err := fg.proposeLength(&cfg)
+	if err != nil {
+		return err
+	}
+	_, _, _, err = fg.injectPayload(&cfg, L, false)
+	return err
+}
+
+// injectTick 循环中的一次注入；暂停时返回 skipped 为 true，
+// 成功时返回写出的记录数与线上字节数之和
+func (fg *FillGhostController) injectTick() (wire, records int, skipped bool, err error) {
+	cfg := fg.config()
+	if fg.isPaused() {
+		return 0, 0, true, nil
+	}
+	// OnBeforeInject 在 injectMu 之外调用，回调中调用 Pause 或 Stop 不会死锁
+	L, err := fg.proposeLength(&cfg)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	return fg.injectPayload(&cfg, L, true)
+}
+
+// injectPayload 注入长度为 L 的负载，超过单条记录上限时（Fragment）逐条注入，
+// 非末尾记录按 FragmentJitter 随机缩短。
+// 每条记录单独持有 injectMu，inLoop 时持锁后再次确认未暂停并检查 SuppressWhenBusy，
+// 保证 Pause 返回后不会再开始新的记录；Metrics 与 OnInject 在 injectMu 之外逐条调用
+func (fg *FillGhostController) injectPayload(cfg *FillGhostConfig, L int, inLoop bool) (wire, records int, skipped bool, err error) {
+	for {
+		n := L
+		if n > fillGhostMaxPayload {
+			cut, err := cryptoRandInt(cfg.random(), 0, cfg.FragmentJitter)
+			if err != nil {
+				fg.countError(cfg)
+				return wire, records, false, &FillGhostError{Op: "rand", Err: err}
+			}
+			n = fillGhostMaxPayload - cut
+		}
+		// Sink 调用期间会释放 injectMu，另以 sinkMu 保证各条记录依序交给 Sink 且额度判断不重叠
+		if cfg.Sink != nil {
+			fg.sinkMu.Lock()
+		}
+		fg.injectMu.Lock()
+		if inLoop && (fg.isPaused() || fg.stopRequested()) {
+			fg.unlockInject(cfg)
+			return wire, records, true, nil
+		}
+		if inLoop && cfg.SuppressWhenBusy && fg.c.FillGhostWriteBusy() {
+			fg.unlockInject(cfg)
+			fg.statsMu.Lock()
+			fg.stats.TicksSkipped++
+			fg.statsMu.Unlock()
+			fg.logSkipped(cfg, n, errFillGhostBusy)
+			return wire, records, false, errFillGhostBusy
+		}
+		meta, err := fg.injectCounted(cfg, n)
+		fg.unlockInject(cfg)
+		if err != nil {
+			// 与 injectCounted 计入 Stats().Errors 的失败一致，跳过与额度用尽不计
+			if cfg.Metrics != nil && err != ErrFillGhostBudgetExhausted && err != ErrFillGhostOverheadLimit && err != ErrFillGhostSharedBudget {
+				cfg.Metrics.IncErrors()
+			}
+			return wire, records, false, err
+		}
+		// 指标与回调在 injectMu 之外执行，其中调用 Pause 不会死锁
+		fg.afterInject(cfg, meta)
+		wire += meta.WireLen
+		records++
+		if L -= n; L <= 0 {
+			return wire, records, false, nil
+		}
+	}
+}
+
+// unlockInject 释放 injectPayload 为一条记录取得的 injectMu 与 sinkMu
+func (fg *FillGhostController) unlockInject(cfg *FillGhostConfig) {
+	fg.injectMu.Unlock()
+	if cfg.Sink != nil {
+		fg.sinkMu.Unlock()
+	}
+}
+
+// stopRequested 报告本轮是否已被 Stop；循环在 injectMu 上等到锁（如 Stop 中断了阻塞的 InjectNow）后
+// 据此不再开始新的记录
+func (fg *FillGhostController) stopRequested() bool {
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+	return fg.ran && !fg.active
+}
+
+// isPaused 报告控制器是否处于暂停状态
+func (fg *FillGhostController) isPaused() bool {
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+	return fg.paused
+}
+
+// proposeLength 采样本条记录的负载长度并交给 OnBeforeInject 确认。
+// 被否决时计入 TicksSkipped 并返回 ErrFillGhostVetoed，回调给出非法长度时计入 Errors
+func (fg *FillGhostController) proposeLength(cfg *FillGhostConfig) (int, error) {
+	L, learned, err := fg.learnedLength(cfg)
+	if !learned && err == nil {
+		L, err = cfg.sampleLength()
+	}
+	if err == nil {
+		L = snapToBucket(cfg.LengthBuckets, L)
+	}
+	if err != nil || cfg.OnBeforeInject == nil {
+		return L, err
+	}
+	n, ok := cfg.OnBeforeInject(L)
+	switch {
+	case !ok:
+		err = ErrFillGhostVetoed
+	case n < 0 || n > cfg.maxLength():
+		err = &FillGhostError{Op: "payload", Err: fmt.Errorf("OnBeforeInject returned length %d, want within [0, %d]", n, cfg.maxLength())}
+	default:
+		return n, nil
+	}
+	fg.statsMu.Lock()
+	if err == ErrFillGhostVetoed {
+		fg.stats.TicksSkipped++
+	} else {
+		fg.stats.Errors++
+	}
+	fg.statsMu.Unlock()
+	if err == ErrFillGhostVetoed {
+		fg.logSkipped(cfg, L, err)
+	} else if cfg.Metrics != nil {
+		cfg.Metrics.IncErrors()
+	}
+	return 0, err
+}
+
+// learnedLength Learn 模式下从 Conn 观测到的真实记录长度中取样，Mirror 模式下取最近一条的长度；
+// LengthSampler 优先。样本不足 fillGhostLearnMinSamples（Mirror 时为尚无样本）时返回 false，
+// 由 sampleLength 按常规方式取值
+func (fg *FillGhostController) learnedLength(cfg *FillGhostConfig) (int, bool, error) {
+	if !(cfg.Learn || cfg.Mirror) || cfg.LengthSampler != nil || fg.c == nil {
+		return 0, false, nil
+	}
+	if cfg.Mirror {
+		n, ok := fg.c.FillGhostLastWriteSize()
+		return n, ok, nil
+	}
+	n, ok, err := fg.c.fillGhostSampleWriteSize(cfg.random(), fillGhostLearnMinSamples)
+	if err != nil {
+		return 0, false, &FillGhostError{Op: "rand", Err: err}
+	}
+	return n, ok, nil
+}
+
+// injectCounted 注入一条负载长度为 L 的记录并更新统计
+func (fg *FillGhostController) injectCounted(cfg *FillGhostConfig, L int) (InjectMeta, error) {
+	budget := -1 // 本条记录允许的线上字节数，-1 表示不限
+	if cfg.MaxTotalBytes > 0 {
+		fg.statsMu.Lock()
+		used := fg.stats.BytesOnWire
+		fg.statsMu.Unlock()
+		budget = 0
+		if used < cfg.MaxTotalBytes {
+			budget = int(cfg.MaxTotalBytes - used)
+		}
+	}
+	tokens := -1 // 开销比例令牌桶中可用的线上字节数，-1 表示不限
+	if cfg.MaxOverheadRatio > 0 {
+		realBytes := float64(fg.c.FillGhostRealBytesWritten())
+		fg.statsMu.Lock()
+		used := float64(fg.stats.BytesOnWire)
+		fg.statsMu.Unlock()
+		tokens = 0
+		if t := float64(cfg.OverheadAllowance) + cfg.MaxOverheadRatio*realBytes - used; t > 0 {
+			tokens = int(math.Min(t, math.MaxInt32))
+		}
+	}
+	m := fg.sharedManager()
+	shared := false // 共享额度比开销比例更紧
+	if m != nil {
+		if avail := m.available(); avail >= 0 && (tokens < 0 || avail < tokens) {
+			tokens, shared = avail, true
+		}
+	}
+	meta, err := fg.injectRecord(cfg, L, budget, tokens)
+	if m != nil && meta.WireLen > 0 {
+		m.consume(meta.WireLen)
+	}
+	if err == ErrFillGhostOverheadLimit && shared {
+		err = ErrFillGhostSharedBudget
+	}
+	if err == ErrFillGhostBudgetExhausted {
+		return InjectMeta{}, err
+	}
+	fg.statsMu.Lock()
+	if err == ErrFillGhostOverheadLimit || err == ErrFillGhostSharedBudget {
+		fg.stats.TicksSkipped++
+	} else if err != nil {
+		fg.stats.Errors++
+	} else {
+		fg.stats.PacketsInjected++
+		fg.stats.BytesInjectedPlaintext += uint64(meta.PlaintextLen)
+		fg.stats.BytesOnWire += uint64(meta.WireLen)
+		fg.stats.LastInjectAt = meta.Time
+		fg.stats.LastPayloadLen = meta.PlaintextLen
+	}
+	// TLS 1.3 的 KeyUpdate 使写序号从 0 重新开始，同一密钥下序号严格递增；
+	// Sink 不消耗序号时相邻记录序号相同，不据此判断
+	rotated := err == nil && fg.stats.PacketsInjected > 1 && meta.Seq <= fg.lastSeq && (cfg.Sink == nil || cfg.ConsumeSeq)
+	if err == nil {
+		fg.lastSeq = meta.Seq
+	}
+	fg.statsMu.Unlock()
+	if rotated {
+		cfg.logf("write key rotated, sequence numbers restarted at %d", meta.Seq)
+	}
+	if err == nil {
+		fg.logInjected(cfg, meta)
+	} else if err == ErrFillGhostOverheadLimit || err == ErrFillGhostSharedBudget {
+		fg.logSkipped(cfg, L, err)
+	}
+	return meta, err
+}
+
+// afterInject 注入成功后的指标、日志与回调，不持有任何锁
+func (fg *FillGhostController) afterInject(cfg *FillGhostConfig, meta InjectMeta) {
+	if cfg.Metrics != nil {
+		cfg.Metrics.IncPackets()
+		cfg.Metrics.AddBytes(meta.WireLen)
+	}
+	if _, ok := cfg.Logger.(FillGhostLevelLogger); ok {
+		cfg.debugf(&fg.injectLim, "injected %d bytes (%d on wire, seq %d)", meta.PlaintextLen, meta.WireLen, meta.Seq)
+	} else if cfg.Verbose {
+		cfg.logf("injected %d bytes (%d on wire, seq %d)", meta.PlaintextLen, meta.WireLen, meta.Seq)
+	}
+	if cfg.OnInject != nil {
+		cfg.OnInject(meta)
+	}
+}
+
+// injectRecord 生成长度为 L 的随机负载并封装注入，返回记录的元数据；失败时 PlaintextLen 仍为 L。
+// budget 非负时线上记录不超过 budget 字节，必要时缩短负载；
+// tokens 非负且不足以容纳整条记录时不注入，返回 ErrFillGhostOverheadLimit
+func (fg *FillGhostController) injectRecord(cfg *FillGhostConfig, L, budget, tokens int) (InjectMeta, error) {
+	if L < cfg.markerLen() {
+		L = cfg.markerLen()
+	}
+	buf := fillGhostBufPool.Get().(*fillGhostBuf)
+	defer fillGhostBufPool.Put(buf)
+	payload := buf.payload[:L]
+	if g, ok := cfg.PayloadGenerator.(fillGhostReaderGenerator); ok {
+		if err := g.fillFrom(cfg.random(), payload); err != nil {
+			return InjectMeta{}, &FillGhostError{Op: "payload", Err: err}
+		}
+	} else if cfg.PayloadGenerator != nil {
+		if err := cfg.PayloadGenerator.Fill(payload); err != nil {
+			return InjectMeta{}, &FillGhostError{Op: "payload", Err: err}
+		}
+	} else if cfg.PayloadFunc != nil {
+		p := cfg.PayloadFunc(L)
+		if len(p) != L {
+			return InjectMeta{}, &FillGhostError{Op: "payload", Err: fmt.Errorf("PayloadFunc returned %d bytes, want %d", len(p), L)}
+		}
+		copy(payload, p)
+	} else if _, err := io.ReadFull(cfg.random(), payload); err != nil {
+		return InjectMeta{}, &FillGhostError{Op: "rand", Err: err}
+	}
+	copy(payload, cfg.Magic)
+	inner := cfg.innerType()
+	var tagKey []byte
+	if cfg.TaggedMarker && fg.c.handshakeComplete() {
+		k, err := fg.c.fillGhostTagKey()
+		if err != nil {
+			return InjectMeta{}, &FillGhostError{Op: "tag", Err: err}
+		}
+		tagKey = k
+	}
+	var recSeq [8]byte
+	// seal 在持有写方向锁时调用，版本在锁内读取：锁外读取会在应用的 Write 阻塞时无法中断地等锁。
+	// 握手完成前没有可用的 AEAD，seal 不会被调用
+	seal := func(a cipher.AEAD, seq [8]byte) ([]byte, error) {
+		vers := fg.c.out.version
+		if (vers != VersionTLS12 && vers != VersionTLS13) || (inner != byte(recordTypeApplicationData) && vers == VersionTLS12) {
+			return nil, ErrFillGhostUnsupportedVersion
+		}
+		recVers := cfg.RecordVersion
+		if recVers == ([2]byte{}) {
+			recVers = fg.c.fillGhostRecordVersionLocked()
+		}
+		recSeq = seq
+		if budget >= 0 {
+			room := budget - fillGhostRecordOverhead(vers, a)
+			// 截断后放不下 Magic 或标签的记录对端无法识别，同样视为额度用尽
+			if room < cfg.markerLen() {
+				return nil, ErrFillGhostBudgetExhausted
+			}
+			if L > room {
+				L = room
+			}
+		}
+		if tokens >= 0 && fillGhostRecordOverhead(vers, a)+L > tokens {
+			return nil, ErrFillGhostOverheadLimit
+		}
+		if tagKey != nil {
+			fillGhostTag(payload, tagKey, seq)
+		}
+		// Conn 写出后不保留该切片，缓冲区在本函数返回时即可放回池中
+		return buf.sealer.build(buf.record[:0], vers, a, seq, payload[:L], cfg.recordType(), inner, recVers)
+	}
+	if cfg.Sink != nil {
+		return fg.injectToSink(cfg, seal, &recSeq, &L)
+	}
+	fg.intr.begin(cfg.InjectTimeout)
+	n, err := fg.c.fillGhostSealAndInject(seal, &fg.intr)
+	fg.intr.end()
+	if err != nil && err != ErrFillGhostNoAEAD && err != ErrFillGhostUnsupportedVersion && err != ErrFillGhostBudgetExhausted && err != ErrFillGhostOverheadLimit {
+		err = &FillGhostError{Op: "write", Err: err}
+	}
+	return InjectMeta{
+		Seq:          binary.BigEndian.Uint64(recSeq[:]),
+		PlaintextLen: L,
+		WireLen:      n,
+		Time:         cfg.clock().Now(),
+	}, err
+}
+
+// injectToSink 封装一条记录交给 Sink 而不写出；seal 会更新 recSeq 与 L。
+// Sink 在写方向锁与 injectMu 之外调用（仍持有 sinkMu），其中调用 Pause 不会死锁；
+// 返回的错误以 Op 为 "sink" 的 FillGhostError 返回
+func (fg *FillGhostController) injectToSink(cfg *FillGhostConfig, seal func(cipher.AEAD, [8]byte) ([]byte, error), recSeq *[8]byte, L *int) (InjectMeta, error) {
+	record, err := fg.c.fillGhostSealOnly(seal, cfg.ConsumeSeq)
+	meta := InjectMeta{
+		Seq:          binary.BigEndian.Uint64(recSeq[:]),
+		PlaintextLen: *L,
+		WireLen:      len(record),
+		Time:         cfg.clock().Now(),
+	}
+	if err != nil {
+		if err != ErrFillGhostNoAEAD && err != ErrFillGhostUnsupportedVersion && err != ErrFillGhostBudgetExhausted && err != ErrFillGhostOverheadLimit {
+			err = &FillGhostError{Op: "seal", Err: err}
+		}
+		meta.WireLen = 0
+		return meta, err
+	}
+	fg.injectMu.Unlock()
+	err = cfg.Sink(record, meta)
+	fg.injectMu.Lock()
+	if err != nil {
+		meta.WireLen = 0
+		return meta, &FillGhostError{Op: "sink", Err: err}
+	}
+	return meta, nil
+}
+
+// fillGhostExplicitNonceLen 返回 TLS 1.2 记录中显式 nonce 的长度：AES-GCM 为 8，ChaCha20-Poly1305 为 0
+func fillGhostExplicitNonceLen(a cipher.AEAD) int {
+	if e, ok := a.(aead); ok {
+		return e.explicitNonceLen()
+	}
+	return 0
+}
+
+// fillGhostRecordOverhead 返回一条记录除负载外的线上字节数
+func fillGhostRecordOverhead(vers uint16, a cipher.AEAD) int {
+	if vers == VersionTLS13 {
+		return recordHeaderLen + 1 + a.Overhead()
+	}
+	return recordHeaderLen + fillGhostExplicitNonceLen(a) + a.Overhead()
+}
+
+// fillGhostPayloadCeiling 返回 vers 与 a 下单条记录可承载的最大负载：明文不超过 2^14 字节，
+// 且加上内层类型、显式 nonce 与 AEAD 开销后不超过该版本的密文上限（RFC 8446 5.2、RFC 5246 6.2.3）。
+// 现有 AEAD 套件的开销都远小于余量，结果均为 16384
+func fillGhostPayloadCeiling(vers uint16, a cipher.AEAD) int {
+	n := maxCiphertext - fillGhostExplicitNonceLen(a) - a.Overhead()
+	if vers == VersionTLS13 {
+		n = maxCiphertextTLS13 - 1 - a.Overhead()
+	}
+	if n > fillGhostMaxPayload {
+		n = fillGhostMaxPayload
+	}
+	return n
+}
+
+// checkRecordLimit 握手完成后按写方向实际的 AEAD 检查配置的长度能否放进单条记录；
+// 握手前或没有 AEAD 时不检查，由注入时返回错误
+func (fg *FillGhostController) checkRecordLimit(cfg *FillGhostConfig) error {
+	if fg.c == nil || cfg.Fragment {
+		return nil
+	}
+	vers := fg.c.FillGhostVersion()
+	a := fg.c.ExportWriteAEAD()
+	if a == nil || (vers != VersionTLS12 && vers != VersionTLS13) {
+		return nil
+	}
+	ceiling := fillGhostPayloadCeiling(vers, a)
+	name := "TLS 1.2"
+	if vers == VersionTLS13 {
+		name = "TLS 1.3"
+	}
+	if cfg.MaxLen > ceiling {
+		return fmt.Errorf("fillghost: MaxLen %d exceeds %d bytes, the largest payload a %s record with %d bytes of AEAD overhead can carry", cfg.MaxLen, ceiling, name, a.Overhead())
+	}
+	for _, w := range cfg.LengthWeights {
+		if w.Length > ceiling {
+			return fmt.Errorf("fillghost: LengthWeights length %d exceeds %d bytes, the largest payload a %s record with %d bytes of AEAD overhead can carry", w.Length, ceiling, name, a.Overhead())
+		}
+	}
+	if n := len(cfg.LengthBuckets); n > 0 && cfg.LengthBuckets[n-1] > ceiling {
+		return fmt.Errorf("fillghost: LengthBuckets bucket %d exceeds %d bytes, the largest payload a %s record with %d bytes of AEAD overhead can carry", cfg.LengthBuckets[n-1], ceiling, name, a.Overhead())
+	}
+	return nil
+}
+
+// fillGhostMaxRecord 一条填充记录的最大线上长度：记录头、最大负载与最大开销。CBC 的开销最大：
+// 16 字节显式 IV、至多 48 字节的 MAC（SHA-384）与至多一个块的填充，
+// 超过 AEAD 的显式 nonce、内层类型与 16 字节标签之和
+const fillGhostMaxRecord = recordHeaderLen + fillGhostMaxPayload + 16 + 48 + 16
+
+// fillGhostBuf 单次注入使用的可复用缓冲区
+type fillGhostBuf struct {
+	payload [fillGhostMaxPayload]byte
+	record  [fillGhostMaxRecord]byte
+	sealer  fillGhostSealer
+}
+
+// fillGhostSealer 封装记录时传给 AEAD 的 nonce 与 additional_data 的存放处，
+// 随缓冲区复用，避免每次封装在堆上分配
+type fillGhostSealer struct {
+	nonce [8]byte
+	ad    [13]byte
+}
+
+// fillGhostBufPool 复用注入缓冲区，避免每条记录分配负载与密文
+var fillGhostBufPool = sync.Pool{New: func() any { return new(fillGhostBuf) }}
+
+// buildGhostRecord 将一条外层类型为 recordType、记录头版本为 recVers、负载为 payload 的
+// 加密记录追加到 dst 后返回。只做封装，不访问 Conn，也不修改 payload；vers 为协商的版本，
+// 须为 VersionTLS12 或 VersionTLS13，innerType 为 TLS 1.3 的内层类型，TLS 1.2 忽略。
+// dst 剩余容量足够时不分配内存
+func buildGhostRecord(dst []byte, vers uint16, a cipher.AEAD, seq [8]byte, payload []byte, recordType, innerType byte, recVers [2]byte) ([]byte, error) {
+	var s fillGhostSealer
+	return s.build(dst, vers, a, seq, payload, recordType, innerType, recVers)
+}
+
+// build 即 buildGhostRecord，使用 s 中的 nonce 与 additional_data 空间
+func (s *fillGhostSealer) build(dst []byte, vers uint16, a cipher.AEAD, seq [8]byte, payload []byte, recordType, innerType byte, recVers [2]byte) ([]byte, error) {
+	var explicit, inner int
+	switch vers {
+	case VersionTLS13:
+		inner = 1
+	case VersionTLS12:
+		// AES-GCM 以写序号作显式 nonce 放在密文之前，ChaCha20-Poly1305 没有显式 nonce
+		explicit = fillGhostExplicitNonceLen(a)
+	default:
+		return nil, ErrFillGhostUnsupportedVersion
+	}
+	if ceiling := fillGhostPayloadCeiling(vers, a); len(payload) > ceiling {
+		return nil, fmt.Errorf("fillghost: payload of %d bytes exceeds the record limit of %d bytes", len(payload), ceiling)
+	}
+	s.nonce = seq
+	ln := explicit + len(payload) + inner + a.Overhead()
+	start := len(dst)
+	dst = append(dst, recordType, recVers[0], recVers[1], byte(ln>>8), byte(ln))
+	dst = append(dst, seq[:explicit]...)
+	body := len(dst)
+	dst = append(dst, payload...)
+	if vers == VersionTLS13 {
+		dst = append(dst, innerType)
+		// TLS 1.3 的 additional_data 是包含最终长度的记录头
+		header := dst[start : start+recordHeaderLen]
+		return a.Seal(dst[:body], s.nonce[:], dst[body:], header), nil
+	}
+	// TLS 1.2 的 additional_data 为 序号 || 类型 || 版本 || 明文长度
+	copy(s.ad[:], seq[:])
+	s.ad[8], s.ad[9], s.ad[10], s.ad[11], s.ad[12] = recordType, recVers[0], recVers[1], byte(len(payload)>>8), byte(len(payload))
+	dst = a.Seal(dst[:body], s.nonce[:], dst[body:], s.ad[:])
+	// CBC 的填充长度随明文变化，Overhead 只是上限，按实际密文长度改写记录头
+	ln = len(dst) - start - recordHeaderLen
+	dst[start+3], dst[start+4] = byte(ln>>8), byte(ln)
+	return dst, nil
+}
+
+// runDuration 返回每轮运行的时长上限：Duration 与 MaxDuration 中较短的非零值，0 表示不限
+func (cfg *FillGhostConfig) runDuration() time.Duration {
+	if cfg.Duration == 0 || (cfg.MaxDuration > 0 && cfg.MaxDuration < cfg.Duration) {
+		return cfg.MaxDuration
+	}
+	return cfg.Duration
+}
+
+// clock 返回本配置使用的时钟：Clock 或系统时钟
+func (cfg *FillGhostConfig) clock() FillGhostClock {
+	if cfg.Clock != nil {
+		return cfg.Clock
+	}
+	return systemClock{}
+}
+
+// random 返回本配置使用的随机源：Rand 或 crypto/rand
+func (cfg *FillGhostConfig) random() io.Reader {
+	if cfg.Rand != nil {
+		return cfg.Rand
+	}
+	return rand.Reader
+}
+
+// cryptoRandInt 从 r 中取 [min, max] 闭区间内的均匀整数
+func cryptoRandInt(r io.Reader, min, max int) (int, error) {
+	if min == max {
+		return min, nil
+	}
+	if min > max {
+		return 0, errors.New("fillghost: min > max")
+	}
+	diff := big.NewInt(int64(max - min + 1))
+	n, err := rand.Int(r, diff)
+	if err != nil {
+		return 0, err
+	}
+	return int(n.Int64()) + min, nil
+}
+
+// cryptoRandDuration 从 r 中取 [min, max] 闭区间内的均匀时长
+func cryptoRandDuration(r io.Reader, min, max time.Duration) (time.Duration, error) {
+	if min == max {
+		return min, nil
+	}
+	if min > max {
+		return 0, errors.New("fillghost: min > max")
+	}
+	diff := new(big.Int).Sub(big.NewInt(int64(max)), big.NewInt(int64(min)))
+	diff.Add(diff, big.NewInt(1))
+	n, err := rand.Int(r, diff)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(n.Int64()) + min, nil
+}
+
+// cryptoRandUnit 从 r 中取 (0, 1] 内均匀分布的随机数
+func cryptoRandUnit(r io.Reader) (float64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	n := binary.BigEndian.Uint64(b[:]) >> 11
+	return float64(n+1) / (1 << 53), nil
+}
+
+// cryptoRandExp 按 -ln(U)*mean 采样均值为 mean 的指数分布
+func cryptoRandExp(r io.Reader, mean time.Duration) (time.Duration, error) {
+	u, err := cryptoRandUnit(r)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(-math.Log(u) * float64(mean)), nil
 }
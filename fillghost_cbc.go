@@ -0,0 +1,88 @@
+package tls
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// ErrFillGhostUnsupportedCipherSuite 协商的套件无法构造填充记录（RC4 或 TLS 1.0 的隐式 IV CBC），
+// Start 返回的错误以 %w 包装本错误并附带套件名
+var ErrFillGhostUnsupportedCipherSuite = errors.New("fillghost: unsupported cipher suite")
+
+// fillGhostCBC 将 TLS 1.1 及以上的 CBC 写方向（先 MAC 后加密）包装成 cipher.AEAD，
+// 使 buildGhostRecord 与 FillGhostSealAndInject 的回调无需区分套件。
+// nonce 为写序号，additional_data 为 序号 || 类型 || 版本 || 明文长度，与 TLS 1.2 AEAD 一致。
+// 每个实例只能 Seal 一条记录：显式 IV 在构造时从随机源取得，Seal 本身无法返回错误
+type fillGhostCBC struct {
+	c   cbcMode
+	mac hash.Hash
+	iv  []byte
+}
+
+// fillGhostWriteAEADLocked 返回写方向用于封装填充记录的 AEAD；CBC 套件返回以 rand 取得 IV 的
+// 一次性包装，其余情况返回 ErrFillGhostNoAEAD。调用方需持有 c.out 锁
+func (c *Conn) fillGhostWriteAEADLocked(rand io.Reader) (cipher.AEAD, error) {
+	switch ciph := c.out.cipher.(type) {
+	case cipher.AEAD:
+		return ciph, nil
+	case cbcMode:
+		if c.out.version < VersionTLS11 {
+			return nil, ErrFillGhostNoAEAD
+		}
+		iv := make([]byte, ciph.BlockSize())
+		if _, err := io.ReadFull(rand, iv); err != nil {
+			return nil, err
+		}
+		return &fillGhostCBC{c: ciph, mac: c.out.mac, iv: iv}, nil
+	}
+	return nil, ErrFillGhostNoAEAD
+}
+
+// fillGhostCheckCipher 握手完成后检查写方向的版本与套件能否注入，供 Start 提前报错
+func (c *Conn) fillGhostCheckCipher() error {
+	c.out.Lock()
+	defer c.out.Unlock()
+	if v := c.out.version; v != VersionTLS12 && v != VersionTLS13 {
+		return ErrFillGhostUnsupportedVersion
+	}
+	switch c.out.cipher.(type) {
+	case cipher.AEAD, cbcMode:
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrFillGhostUnsupportedCipherSuite, CipherSuiteName(c.cipherSuite))
+}
+
+func (f *fillGhostCBC) NonceSize() int { return 8 }
+
+// Overhead 返回最大开销：显式 IV、MAC 与至多一个块的填充。实际记录可能更短，
+// build 在 Seal 之后按实际长度改写记录头
+func (f *fillGhostCBC) Overhead() int { return 2*f.c.BlockSize() + f.mac.Size() }
+
+func (f *fillGhostCBC) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if f.iv == nil {
+		panic("fillghost: CBC sealer reused")
+	}
+	bs := f.c.BlockSize()
+	n := len(plaintext) + f.mac.Size()
+	padding := bs - n%bs
+	ret, out := sliceForAppend(dst, bs+n+padding)
+	// plaintext 可能与 dst 的空闲容量重叠（原地封装），先把它挪到 IV 之后
+	body := out[bs:]
+	copy(body, plaintext)
+	tls10MAC(f.mac, body[len(plaintext):len(plaintext)], nonce, additionalData[8:], body[:len(plaintext)], nil)
+	for i := n; i < len(body); i++ {
+		body[i] = byte(padding - 1)
+	}
+	copy(out, f.iv)
+	f.c.SetIV(f.iv)
+	f.iv = nil
+	f.c.CryptBlocks(body, body)
+	return ret
+}
+
+func (f *fillGhostCBC) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	return nil, errors.New("fillghost: CBC sealer cannot open records")
+}
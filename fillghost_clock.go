@@ -0,0 +1,137 @@
+package tls
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// FillGhostClock 注入循环使用的时钟，供 FillGhostConfig.Clock 替换系统时钟
+type FillGhostClock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) FillGhostTimer
+}
+
+// FillGhostTimer FillGhostClock 创建的定时器，语义同 time.Timer
+type FillGhostTimer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// systemClock 基于 time 包的默认时钟
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) FillGhostTimer { return systemTimer{time.NewTimer(d)} }
+
+type systemTimer struct{ t *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time        { return t.t.C }
+func (t systemTimer) Stop() bool                 { return t.t.Stop() }
+func (t systemTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+// FakeFillGhostClock 手动推进的时钟，用于测试 InitialDelay、Interval、Duration、
+// IdleThreshold 与 Schedule 而无需真实等待。时间只在调用 Advance 时前进；
+// 控制器启动后 Conn 同样以它记录真实写入的时间
+type FakeFillGhostClock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*fakeFillGhostTimer // 未触发的定时器
+}
+
+// NewFakeFillGhostClock 返回从 start 开始的手动时钟
+func NewFakeFillGhostClock(start time.Time) *FakeFillGhostClock {
+	c := &FakeFillGhostClock{now: start}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now 实现 FillGhostClock
+func (c *FakeFillGhostClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer 实现 FillGhostClock，d <= 0 时立即触发
+func (c *FakeFillGhostClock) NewTimer(d time.Duration) FillGhostTimer {
+	t := &fakeFillGhostTimer{clock: c, ch: make(chan time.Time, 1)}
+	c.mu.Lock()
+	c.scheduleLocked(t, d)
+	c.mu.Unlock()
+	return t
+}
+
+// Advance 将时钟推进 d，并按到期顺序触发期间到期的定时器
+func (c *FakeFillGhostClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.fireLocked()
+}
+
+// BlockUntil 阻塞直到至少有 n 个未触发的定时器，即注入循环已进入等待
+func (c *FakeFillGhostClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
+
+// scheduleLocked 让 t 在 d 之后到期，调用方需持有 c.mu
+func (c *FakeFillGhostClock) scheduleLocked(t *fakeFillGhostTimer, d time.Duration) {
+	t.when = c.now.Add(d)
+	c.timers = append(c.timers, t)
+	c.fireLocked()
+	c.cond.Broadcast()
+}
+
+// fireLocked 触发已到期的定时器
+func (c *FakeFillGhostClock) fireLocked() {
+	sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].when.Before(c.timers[j].when) })
+	i := 0
+	for ; i < len(c.timers) && !c.timers[i].when.After(c.now); i++ {
+		select {
+		case c.timers[i].ch <- c.now:
+		default:
+		}
+	}
+	c.timers = append(c.timers[:0], c.timers[i:]...)
+}
+
+// removeLocked 从待触发列表中移除 t，返回 t 是否仍未触发
+func (c *FakeFillGhostClock) removeLocked(t *fakeFillGhostTimer) bool {
+	for i, p := range c.timers {
+		if p == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeFillGhostTimer struct {
+	clock *FakeFillGhostClock
+	ch    chan time.Time
+	when  time.Time
+}
+
+func (t *fakeFillGhostTimer) C() <-chan time.Time { return t.ch }
+
+func (t *fakeFillGhostTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.removeLocked(t)
+}
+
+func (t *fakeFillGhostTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.clock.removeLocked(t)
+	t.clock.scheduleLocked(t, d)
+	return active
+}
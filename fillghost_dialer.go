@@ -0,0 +1,53 @@
+package tls
+
+import (
+	"context"
+	"net"
+)
+
+// FillGhostDialer 与 Dialer 相同，但每个连接在握手完成后按 Ghost 的副本自动启动控制器，
+// Close 时停止，等同于设置 Config.FillGhost（Config 中的 FillGhostClient 不再使用）。控制器见返回连接 (*Conn) 的 FillGhostController 字段。
+// DialContext 可直接用作 http.Transport.DialTLSContext
+type FillGhostDialer struct {
+	// NetDialer 底层 TCP 连接使用的拨号器，nil 等同于 net.Dialer 零值
+	NetDialer *net.Dialer
+	// Config 新连接的 TLS 配置，会被复制；nil 等同于零值配置
+	Config *Config
+	// Ghost 各连接控制器的配置，Rand、Clock 与回调等引用字段为各连接共用
+	Ghost FillGhostConfig
+}
+
+// Dial 连接 addr 并完成握手，见 DialContext
+func (d *FillGhostDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext 连接 addr、完成握手并启动控制器，返回的连接总是 *Conn。
+// Ghost 不合法或控制器启动失败时关闭连接并返回错误；拨号或握手期间 ctx 取消时
+// 不会遗留注入协程。连接建立之后 ctx 到期不影响连接与注入
+func (d *FillGhostDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if err := d.Ghost.Validate(); err != nil {
+		return nil, err
+	}
+	config := d.Config.Clone()
+	if config == nil {
+		config = &Config{}
+	}
+	fg := d.Ghost
+	config.FillGhost = &fg
+	config.FillGhostClient = nil
+	netDialer := d.NetDialer
+	if netDialer == nil {
+		netDialer = new(net.Dialer)
+	}
+	c, err := dial(ctx, netDialer, network, addr, config)
+	if err != nil {
+		return nil, err
+	}
+	// 只有启动失败才算拨号失败；Err() 还包括健康的控制器随后自行停止的原因（如 MaxPackets）
+	if err := c.FillGhostController.autoStartErr(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
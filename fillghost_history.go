@@ -0,0 +1,86 @@
+package tls
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// InjectLogEntry InjectLog 中的一项：一条已注入的记录，或一次被跳过的注入
+type InjectLogEntry struct {
+	Time         time.Time `json:"time"`
+	Seq          uint64    `json:"seq"`           // 记录使用的 TLS 写序号，跳过时为 0
+	PlaintextLen int       `json:"plaintext_len"` // 明文负载长度，跳过时为计划的长度
+	WireLen      int       `json:"wire_len"`      // 线上记录长度，跳过时为 0
+	Skipped      bool      `json:"skipped"`       // 因 SuppressWhenBusy、MaxOverheadRatio、FillGhostManager 或 OnBeforeInject 跳过
+}
+
+// fillGhostInjectLog 定长环形缓冲，槽位预先分配，记录时不再分配内存
+type fillGhostInjectLog struct {
+	mu    sync.Mutex
+	slots []InjectLogEntry
+	next  int // 下一个写入的槽位
+	n     int // 已写入的项数，不超过 len(slots)
+}
+
+// add 写入一项，size 与当前容量不同时（首次使用或 RestartWithConfig 改变了 InjectLogSize）清空重建
+func (l *fillGhostInjectLog) add(size int, e InjectLogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.slots) != size {
+		l.slots = make([]InjectLogEntry, size)
+		l.next, l.n = 0, 0
+	}
+	l.slots[l.next] = e
+	if l.next++; l.next == size {
+		l.next = 0
+	}
+	if l.n < size {
+		l.n++
+	}
+}
+
+// logInjected 在 InjectLogSize 大于 0 时记录一条已注入的记录
+func (fg *FillGhostController) logInjected(cfg *FillGhostConfig, meta InjectMeta) {
+	if cfg.InjectLogSize > 0 {
+		fg.injectLog.add(cfg.InjectLogSize, InjectLogEntry{Time: meta.Time, Seq: meta.Seq, PlaintextLen: meta.PlaintextLen, WireLen: meta.WireLen})
+	}
+}
+
+// logSkipped 记录一次计划长度为 L、因 reason 被跳过的注入：InjectLogSize 大于 0 时写入 InjectLog，
+// Logger 实现 FillGhostLevelLogger 时限流输出 Debugf
+func (fg *FillGhostController) logSkipped(cfg *FillGhostConfig, L int, reason error) {
+	cfg.debugf(&fg.skipLim, "skipped injection of %d bytes: %v", L, reason)
+	if cfg.InjectLogSize > 0 {
+		fg.injectLog.add(cfg.InjectLogSize, InjectLogEntry{Time: cfg.clock().Now(), PlaintextLen: L, Skipped: true})
+	}
+}
+
+// InjectLog 返回环形缓冲中最近的 InjectLogSize 项，按时间从旧到新；未启用时返回 nil
+func (fg *FillGhostController) InjectLog() []InjectLogEntry {
+	l := &fg.injectLog
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.n == 0 {
+		return nil
+	}
+	out := make([]InjectLogEntry, 0, l.n)
+	start := l.next - l.n
+	if start < 0 {
+		start += len(l.slots)
+	}
+	for i := 0; i < l.n; i++ {
+		out = append(out, l.slots[(start+i)%len(l.slots)])
+	}
+	return out
+}
+
+// DumpJSON 将 InjectLog 以 JSON 数组写入 w，便于与抓包逐条对照；未启用或为空时写入 []
+func (fg *FillGhostController) DumpJSON(w io.Writer) error {
+	entries := fg.InjectLog()
+	if entries == nil {
+		entries = []InjectLogEntry{}
+	}
+	return json.NewEncoder(w).Encode(entries)
+}
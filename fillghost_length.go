@@ -0,0 +1,273 @@
+package tls
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// LengthSampler 负载长度采样器，供 FillGhostConfig.LengthSampler 使用。
+// Sample 返回的长度必须在 [0, 16384] 内，否则本次注入失败
+type LengthSampler interface {
+	Sample() (int, error)
+}
+
+// fillGhostReaderSampler 内置采样器实现的接口，从指定随机源取数以支持 FillGhostConfig.Rand
+type fillGhostReaderSampler interface {
+	sampleFrom(r io.Reader) (int, error)
+}
+
+// UniformLengthSampler 在 [Min, Max] 内均匀采样，与未设置采样器时的 MinLen/MaxLen 行为一致
+type UniformLengthSampler struct {
+	Min, Max int
+}
+
+// Sample 实现 LengthSampler
+func (s UniformLengthSampler) Sample() (int, error) {
+	return s.sampleFrom(rand.Reader)
+}
+
+func (s UniformLengthSampler) sampleFrom(r io.Reader) (int, error) {
+	return cryptoRandInt(r, s.Min, s.Max)
+}
+
+// NormalLengthSampler 按正态分布采样，结果四舍五入并截断到 [1, 16384]
+type NormalLengthSampler struct {
+	Mean   float64
+	StdDev float64
+}
+
+// Sample 实现 LengthSampler
+func (s NormalLengthSampler) Sample() (int, error) {
+	return s.sampleFrom(rand.Reader)
+}
+
+func (s NormalLengthSampler) sampleFrom(r io.Reader) (int, error) {
+	z, err := cryptoRandNormal(r)
+	if err != nil {
+		return 0, err
+	}
+	return clampLength(math.Round(s.Mean+z*s.StdDev), 1, fillGhostMaxPayload), nil
+}
+
+// HistogramLengthSampler 从观测到的长度样本中等概率抽取，重复出现的长度权重更高
+type HistogramLengthSampler struct {
+	sizes []int
+}
+
+// NewHistogramLengthSampler 由观测到的记录长度构造采样器，长度须在 [0, 16384] 内
+func NewHistogramLengthSampler(sizes []int) (*HistogramLengthSampler, error) {
+	if len(sizes) == 0 {
+		return nil, errors.New("fillghost: histogram needs at least one size")
+	}
+	for _, n := range sizes {
+		if n < 0 || n > fillGhostMaxPayload {
+			return nil, fmt.Errorf("fillghost: histogram size %d outside [0, %d]", n, fillGhostMaxPayload)
+		}
+	}
+	return &HistogramLengthSampler{sizes: append([]int(nil), sizes...)}, nil
+}
+
+// Sample 实现 LengthSampler
+func (s *HistogramLengthSampler) Sample() (int, error) {
+	return s.sampleFrom(rand.Reader)
+}
+
+func (s *HistogramLengthSampler) sampleFrom(r io.Reader) (int, error) {
+	i, err := cryptoRandInt(r, 0, len(s.sizes)-1)
+	if err != nil {
+		return 0, err
+	}
+	return s.sizes[i], nil
+}
+
+// fillGhostCDFTolerance CDF 末项与 1.0 比较时允许的浮点误差
+const fillGhostCDFTolerance = 1e-9
+
+// CDFLengthSampler 按经验累积分布采样：取 (0, 1] 内的均匀随机数 u，
+// 返回第一个 cumulative[i] >= u 对应的 sizes[i]
+type CDFLengthSampler struct {
+	sizes      []int
+	cumulative []float64
+}
+
+// NewCDFLengthSampler 由长度及其累积概率构造采样器。cumulative 须单调不减、
+// 位于 [0, 1] 内且末项为 1.0；sizes 与之一一对应，长度须在 [0, 16384] 内
+func NewCDFLengthSampler(sizes []int, cumulative []float64) (*CDFLengthSampler, error) {
+	if len(sizes) == 0 {
+		return nil, errors.New("fillghost: CDF needs at least one size")
+	}
+	if len(sizes) != len(cumulative) {
+		return nil, fmt.Errorf("fillghost: CDF has %d sizes but %d probabilities", len(sizes), len(cumulative))
+	}
+	prev := 0.0
+	for i, p := range cumulative {
+		if n := sizes[i]; n < 0 || n > fillGhostMaxPayload {
+			return nil, fmt.Errorf("fillghost: CDF size %d outside [0, %d]", n, fillGhostMaxPayload)
+		}
+		if math.IsNaN(p) || p < prev || p > 1+fillGhostCDFTolerance {
+			return nil, fmt.Errorf("fillghost: CDF is not monotonic in [0, 1] at index %d (%v)", i, p)
+		}
+		prev = p
+	}
+	if math.Abs(prev-1) > fillGhostCDFTolerance {
+		return nil, fmt.Errorf("fillghost: CDF ends at %v, want 1.0", prev)
+	}
+	s := &CDFLengthSampler{
+		sizes:      append([]int(nil), sizes...),
+		cumulative: append([]float64(nil), cumulative...),
+	}
+	s.cumulative[len(s.cumulative)-1] = 1
+	return s, nil
+}
+
+// Sample 实现 LengthSampler
+func (s *CDFLengthSampler) Sample() (int, error) {
+	return s.sampleFrom(rand.Reader)
+}
+
+func (s *CDFLengthSampler) sampleFrom(r io.Reader) (int, error) {
+	u, err := cryptoRandUnit(r)
+	if err != nil {
+		return 0, err
+	}
+	i := sort.SearchFloat64s(s.cumulative, u)
+	if i == len(s.sizes) {
+		i--
+	}
+	return s.sizes[i], nil
+}
+
+// LengthWeight 离散长度分布中的一项：以 Weight 的相对权重选中 Length
+type LengthWeight struct {
+	Length int
+	Weight int
+}
+
+// validateLengthWeights 检查权重均为正、长度不超过 max
+func validateLengthWeights(weights []LengthWeight, max int) error {
+	for _, w := range weights {
+		if w.Weight <= 0 {
+			return fmt.Errorf("fillghost: weight %d for length %d is not positive", w.Weight, w.Length)
+		}
+		if w.Length < 0 || w.Length > max {
+			return fmt.Errorf("fillghost: weighted length %d outside [0, %d]", w.Length, max)
+		}
+	}
+	return nil
+}
+
+// validateLengthBuckets 检查 LengthBuckets 严格递增且都在 [0, max] 内
+func validateLengthBuckets(buckets []int, max int) error {
+	for i, b := range buckets {
+		if b < 0 || b > max {
+			return fmt.Errorf("fillghost: length bucket %d outside [0, %d]", b, max)
+		}
+		if i > 0 && b <= buckets[i-1] {
+			return fmt.Errorf("fillghost: LengthBuckets are not strictly increasing at %d", b)
+		}
+	}
+	return nil
+}
+
+// snapToBucket 将 n 向上取整到不小于它的最小桶，超过最大桶时取最大桶；buckets 为空时原样返回
+func snapToBucket(buckets []int, n int) int {
+	if len(buckets) == 0 {
+		return n
+	}
+	i := sort.SearchInts(buckets, n)
+	if i == len(buckets) {
+		i--
+	}
+	return buckets[i]
+}
+
+// sampleWeighted 按权重随机选取一个长度
+func sampleWeighted(rnd io.Reader, weights []LengthWeight) (int, error) {
+	total := 0
+	for _, w := range weights {
+		total += w.Weight
+	}
+	r, err := cryptoRandInt(rnd, 0, total-1)
+	if err != nil {
+		return 0, err
+	}
+	for _, w := range weights {
+		if r < w.Weight {
+			return w.Length, nil
+		}
+		r -= w.Weight
+	}
+	return weights[len(weights)-1].Length, nil
+}
+
+// sampleLength 采样本次注入的负载长度：优先使用 LengthSampler，其次 LengthWeights，
+// 否则在 [MinLen, MaxLen] 内按 LengthDistribution 取值
+func (cfg *FillGhostConfig) sampleLength() (int, error) {
+	if cfg.LengthSampler == nil && cfg.LengthDistribution == LengthDistributionNormal {
+		z, err := cryptoRandNormal(cfg.random())
+		if err != nil {
+			return 0, &FillGhostError{Op: "rand", Err: err}
+		}
+		return clampLength(math.Round(cfg.LengthMean+z*cfg.LengthStdDev), cfg.MinLen, cfg.MaxLen), nil
+	}
+	if cfg.LengthSampler == nil && len(cfg.LengthWeights) > 0 {
+		n, err := sampleWeighted(cfg.random(), cfg.LengthWeights)
+		if err != nil {
+			return 0, &FillGhostError{Op: "rand", Err: err}
+		}
+		return n, nil
+	}
+	if cfg.LengthSampler == nil {
+		max := cfg.MaxLen
+		if max == 0 && len(cfg.LengthBuckets) > 0 {
+			max = cfg.LengthBuckets[len(cfg.LengthBuckets)-1]
+		}
+		n, err := cryptoRandInt(cfg.random(), cfg.MinLen, max)
+		if err != nil {
+			return 0, &FillGhostError{Op: "rand", Err: err}
+		}
+		return n, nil
+	}
+	var n int
+	var err error
+	if s, ok := cfg.LengthSampler.(fillGhostReaderSampler); ok {
+		n, err = s.sampleFrom(cfg.random())
+	} else {
+		n, err = cfg.LengthSampler.Sample()
+	}
+	if err != nil {
+		return 0, &FillGhostError{Op: "length", Err: err}
+	}
+	if n < 0 || n > cfg.maxLength() {
+		return 0, &FillGhostError{Op: "length", Err: fmt.Errorf("sampled length %d outside [0, %d]", n, cfg.maxLength())}
+	}
+	return n, nil
+}
+
+// clampLength 将 v 截断到 [min, max] 并转为整数
+func clampLength(v float64, min, max int) int {
+	if math.IsNaN(v) || v < float64(min) {
+		return min
+	}
+	if v > float64(max) {
+		return max
+	}
+	return int(v)
+}
+
+// cryptoRandNormal 用 Box-Muller 变换采样标准正态分布
+func cryptoRandNormal(r io.Reader) (float64, error) {
+	u1, err := cryptoRandUnit(r)
+	if err != nil {
+		return 0, err
+	}
+	u2, err := cryptoRandUnit(r)
+	if err != nil {
+		return 0, err
+	}
+	return math.Sqrt(-2*math.Log(u1)) * math.Cos(2*math.Pi*u2), nil
+}
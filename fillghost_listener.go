@@ -0,0 +1,16 @@
+package tls
+
+import "net"
+
+// NewFillGhostListener 与 NewListener 相同，但每个接受的连接在握手完成后（显式 Handshake
+// 或首次 Read/Write）按 fg 的副本自动启动控制器，Close 时停止，等同于设置 Config.FillGhost
+// （config 中的 FillGhostServer 不再使用）。
+// 控制器见返回连接 (*Conn) 的 FillGhostController 字段。config 会被复制，调用方之后修改
+// config 不影响本监听器；fg 中的 Rand、Clock 与回调等引用字段为各连接共用。
+// fg 不合法时握手照常完成，错误见各连接的 FillGhostController.Err()
+func NewFillGhostListener(inner net.Listener, config *Config, fg FillGhostConfig) net.Listener {
+	config = config.Clone()
+	config.FillGhost = &fg
+	config.FillGhostServer = nil
+	return NewListener(inner, config)
+}
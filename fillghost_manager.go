@@ -0,0 +1,195 @@
+package tls
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrFillGhostSharedBudget FillGhostManager 的共享令牌桶不足以容纳本条记录，本次注入被跳过
+var ErrFillGhostSharedBudget = errors.New("fillghost: shared manager budget empty")
+
+// FillGhostManager 在多个连接的控制器之间共享一个全局填充带宽上限（线上字节/秒）。
+// 经 Attach 创建的控制器每条记录之前查询共享令牌桶，额度不足时跳过本次注入（计入 TicksSkipped），
+// 与 MaxOverheadRatio 一样短暂等待后重试。令牌桶容量为一秒的额度，至少能容纳一条最大的记录。
+// 各控制器并发注入时可能同时看到同一份额度，令牌桶因此短暂透支至多每个控制器一条记录，
+// 透支在之后的补充中扣回，长期平均不超过上限。可并发使用
+type FillGhostManager struct {
+	mu      sync.Mutex
+	rate    float64   // 每秒补充的线上字节数，0 表示不限
+	tokens  float64   // 可用的线上字节数，可为负（透支）
+	last    time.Time // 上次补充的时间
+	ctrls   map[*FillGhostController]struct{}
+	retired FillGhostStats // 已移出的控制器的累计统计
+}
+
+// NewFillGhostManager 构造全局上限为 bytesPerSec 线上字节/秒的管理器，0 表示不限，负数返回错误
+func NewFillGhostManager(bytesPerSec int) (*FillGhostManager, error) {
+	m := &FillGhostManager{ctrls: make(map[*FillGhostController]struct{})}
+	if err := m.SetRate(bytesPerSec); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SetRate 在运行中调整全局上限，0 表示不限；已积累的额度截断到新的容量，透支保留
+func (m *FillGhostManager) SetRate(bytesPerSec int) error {
+	if bytesPerSec < 0 {
+		return fmt.Errorf("fillghost: manager rate %d is negative", bytesPerSec)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	wasUnlimited := m.rate == 0
+	m.refillLocked(now)
+	m.rate = float64(bytesPerSec)
+	m.last = now
+	if c := m.capacityLocked(); wasUnlimited || m.tokens > c {
+		// 从不限切换为限速时以满桶开始
+		m.tokens = c
+	}
+	return nil
+}
+
+// Rate 返回当前的全局上限（线上字节/秒），0 表示不限
+func (m *FillGhostManager) Rate() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int(m.rate)
+}
+
+// Attach 为 c 构造受本管理器约束的控制器，配置检查同 NewFillGhostControllerChecked。
+// 控制器不会自动启动；连接关闭且注入协程退出后，Stats 会自动将其移出并保留其统计
+func (m *FillGhostManager) Attach(c *Conn, cfg FillGhostConfig) (*FillGhostController, error) {
+	fg, err := NewFillGhostControllerChecked(c, cfg)
+	if err != nil {
+		return nil, err
+	}
+	fg.manager = m
+	m.mu.Lock()
+	m.ctrls[fg] = struct{}{}
+	m.mu.Unlock()
+	return fg, nil
+}
+
+// Detach 将 fg 移出管理器，其当前统计并入 Stats 的累计值，之后的注入不再受共享上限约束，
+// 也不再计入 Stats。fg 不属于本管理器时不做任何事；不会停止 fg
+func (m *FillGhostManager) Detach(fg *FillGhostController) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.ctrls[fg]; !ok {
+		return
+	}
+	delete(m.ctrls, fg)
+	fg.mu.Lock()
+	fg.manager = nil
+	fg.mu.Unlock()
+	m.retired.add(fg.Stats())
+}
+
+// Len 返回当前受管理的控制器数量
+func (m *FillGhostManager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.ctrls)
+}
+
+// Stats 返回所有受管理控制器（含已移出的）的累计统计：计数相加，LastInjectAt 与 LastPayloadLen 取最晚一条，
+// StartedAt 取最早，StopReason 总为 nil。顺带移出连接已关闭且注入协程已退出的控制器
+func (m *FillGhostManager) Stats() FillGhostStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	total := m.retired
+	for fg := range m.ctrls {
+		st := fg.Stats()
+		if fg.c.FillGhostClosed() && !fg.loopRunning() {
+			delete(m.ctrls, fg)
+			m.retired.add(st)
+		}
+		total.add(st)
+	}
+	total.StopReason = nil
+	return total
+}
+
+// available 返回当前可用的线上字节数，不限时返回 -1
+func (m *FillGhostManager) available() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.rate == 0 {
+		return -1
+	}
+	m.refillLocked(time.Now())
+	if m.tokens <= 0 {
+		return 0
+	}
+	return int(m.tokens)
+}
+
+// consume 扣除一条已写出记录的线上字节数
+func (m *FillGhostManager) consume(wire int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.rate > 0 {
+		m.refillLocked(time.Now())
+		m.tokens -= float64(wire)
+	}
+}
+
+func (m *FillGhostManager) refillLocked(now time.Time) {
+	if dt := now.Sub(m.last); dt > 0 {
+		m.tokens += m.rate * dt.Seconds()
+		if c := m.capacityLocked(); m.tokens > c {
+			m.tokens = c
+		}
+	}
+	m.last = now
+}
+
+// capacityLocked 令牌桶容量：一秒的额度，至少一条最大的记录
+func (m *FillGhostManager) capacityLocked() float64 {
+	if m.rate < fillGhostMaxRecord {
+		return fillGhostMaxRecord
+	}
+	return m.rate
+}
+
+// add 将 o 的计数累加到 s，时间取 LastInjectAt 最晚（LastPayloadLen 随之）、StartedAt 最早
+func (s *FillGhostStats) add(o FillGhostStats) {
+	s.PacketsInjected += o.PacketsInjected
+	s.BytesInjectedPlaintext += o.BytesInjectedPlaintext
+	s.BytesOnWire += o.BytesOnWire
+	s.Errors += o.Errors
+	s.TicksSkipped += o.TicksSkipped
+	s.Retries += o.Retries
+	if o.LastInjectAt.After(s.LastInjectAt) {
+		s.LastInjectAt = o.LastInjectAt
+		s.LastPayloadLen = o.LastPayloadLen
+	}
+	if !o.StartedAt.IsZero() && (s.StartedAt.IsZero() || o.StartedAt.Before(s.StartedAt)) {
+		s.StartedAt = o.StartedAt
+	}
+}
+
+// sharedManager 返回 fg 所属的管理器，未经 Attach 或已移出时为 nil
+func (fg *FillGhostController) sharedManager() *FillGhostManager {
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+	return fg.manager
+}
+
+// loopRunning 报告注入协程是否仍在运行（含已收到停止信号、尚未退出的情况）
+func (fg *FillGhostController) loopRunning() bool {
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+	if !fg.ran {
+		return false
+	}
+	select {
+	case <-fg.stoppedCh:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,36 @@
+package tls
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultPaddingBuckets NewBucketPaddingPolicy 常用的一组桶长度
+var DefaultPaddingBuckets = []int{256, 512, 1024, 4096, maxPlaintext}
+
+// NewBucketPaddingPolicy 返回供 Config.PaddingPolicy 使用的分桶填充策略：每条记录的明文
+// 填充到不小于其长度的最小桶，使线上长度只剩桶长度加固定开销几种取值。
+// buckets 须非空、严格递增且都在 [1, 16384] 内；比最大桶更长的记录不填充，
+// 最大桶取 16384 时所有长度都会落在桶上
+func NewBucketPaddingPolicy(buckets []int) (func(plaintextLen int) int, error) {
+	if len(buckets) == 0 {
+		return nil, errors.New("fillghost: no padding buckets")
+	}
+	for i, b := range buckets {
+		if b < 1 || b > maxPlaintext {
+			return nil, fmt.Errorf("fillghost: padding bucket %d is outside [1, %d]", b, maxPlaintext)
+		}
+		if i > 0 && b <= buckets[i-1] {
+			return nil, fmt.Errorf("fillghost: padding buckets are not strictly increasing at %d", b)
+		}
+	}
+	bs := append([]int(nil), buckets...)
+	return func(n int) int {
+		for _, b := range bs {
+			if n <= b {
+				return b - n
+			}
+		}
+		return 0
+	}, nil
+}
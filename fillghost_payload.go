@@ -0,0 +1,89 @@
+package tls
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// PayloadGenerator 负载内容生成器，供 FillGhostConfig.PayloadGenerator 使用。
+// Fill 须填满整个 buf；buf 来自复用的缓冲区，返回后不得再持有
+type PayloadGenerator interface {
+	Fill(buf []byte) error
+}
+
+// fillGhostReaderGenerator 内置生成器实现的接口，从指定随机源取数以支持 FillGhostConfig.Rand
+type fillGhostReaderGenerator interface {
+	fillFrom(r io.Reader, buf []byte) error
+}
+
+// RandomPayloadGenerator 以 crypto/rand 随机字节填充，与未设置生成器时的行为一致
+type RandomPayloadGenerator struct{}
+
+// Fill 实现 PayloadGenerator
+func (g RandomPayloadGenerator) Fill(buf []byte) error {
+	return g.fillFrom(rand.Reader, buf)
+}
+
+func (RandomPayloadGenerator) fillFrom(r io.Reader, buf []byte) error {
+	_, err := io.ReadFull(r, buf)
+	return err
+}
+
+// DefaultTextAlphabet TextPayloadGenerator 未指定字母表时使用的字符集
+const DefaultTextAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 "
+
+// TextPayloadGenerator 从给定字母表中均匀选取字符，生成看起来像文本的负载
+type TextPayloadGenerator struct {
+	alphabet []byte
+	limit    int // 小于 limit 的随机字节按模映射，其余重新抽取以避免偏差
+}
+
+// NewTextPayloadGenerator 由 ASCII 字母表构造生成器，空字符串使用 DefaultTextAlphabet。
+// 字母表中的字符不得重复
+func NewTextPayloadGenerator(alphabet string) (*TextPayloadGenerator, error) {
+	if alphabet == "" {
+		alphabet = DefaultTextAlphabet
+	}
+	var seen [128]bool
+	for i := 0; i < len(alphabet); i++ {
+		c := alphabet[i]
+		if c >= 0x80 {
+			return nil, fmt.Errorf("fillghost: alphabet byte %#02x is not ASCII", c)
+		}
+		if seen[c] {
+			return nil, fmt.Errorf("fillghost: alphabet repeats %q", c)
+		}
+		seen[c] = true
+	}
+	n := len(alphabet)
+	return &TextPayloadGenerator{alphabet: []byte(alphabet), limit: 256 - 256%n}, nil
+}
+
+// Fill 实现 PayloadGenerator
+func (g *TextPayloadGenerator) Fill(buf []byte) error {
+	return g.fillFrom(rand.Reader, buf)
+}
+
+func (g *TextPayloadGenerator) fillFrom(r io.Reader, buf []byte) error {
+	if g == nil || len(g.alphabet) == 0 {
+		return errors.New("fillghost: TextPayloadGenerator not initialized, use NewTextPayloadGenerator")
+	}
+	var rnd [64]byte
+	for i := 0; i < len(buf); {
+		if _, err := io.ReadFull(r, rnd[:]); err != nil {
+			return err
+		}
+		for _, b := range rnd {
+			if int(b) >= g.limit {
+				continue
+			}
+			buf[i] = g.alphabet[int(b)%len(g.alphabet)]
+			if i++; i == len(buf) {
+				break
+			}
+		}
+	}
+	return nil
+}
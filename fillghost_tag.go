@@ -0,0 +1,54 @@
+package tls
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+)
+
+// FillGhostTagLen TaggedMarker 写在负载开头的 HMAC 标签长度
+const FillGhostTagLen = 16
+
+// fillGhostTagLabel 派生标记密钥使用的导出器标签（RFC 5705 / RFC 8446 7.5）
+const fillGhostTagLabel = "EXPORTER-FillGhost ghost marker"
+
+// fillGhostTagKeyLen 派生的 HMAC-SHA256 密钥长度
+const fillGhostTagKeyLen = 32
+
+// fillGhostTagKey 返回由导出器派生的标记密钥，首次成功后缓存在 Conn 上。
+// 握手未完成或允许重协商（导出器不可用）时返回错误
+func (c *Conn) fillGhostTagKey() ([]byte, error) {
+	c.fillGhostKeyMu.Lock()
+	defer c.fillGhostKeyMu.Unlock()
+	if c.fillGhostKey != nil {
+		return c.fillGhostKey, nil
+	}
+	cs := c.ConnectionState()
+	if !cs.HandshakeComplete {
+		return nil, errors.New("fillghost: ghost marker key needs a completed handshake")
+	}
+	key, err := cs.ExportKeyingMaterial(fillGhostTagLabel, nil, fillGhostTagKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	c.fillGhostKey = key
+	return key, nil
+}
+
+// fillGhostTag 计算序号 seq 对应记录的标记：HMAC-SHA256(key, seq) 截取前 FillGhostTagLen 字节
+func fillGhostTag(dst, key []byte, seq [8]byte) {
+	m := hmac.New(sha256.New, key)
+	m.Write(seq[:])
+	var sum [sha256.Size]byte
+	copy(dst[:FillGhostTagLen], m.Sum(sum[:0]))
+}
+
+// fillGhostTagged 报告 data 是否以序号 seq 对应的标记开头
+func fillGhostTagged(data, key []byte, seq [8]byte) bool {
+	if len(data) < FillGhostTagLen {
+		return false
+	}
+	var want [FillGhostTagLen]byte
+	fillGhostTag(want[:], key, seq)
+	return hmac.Equal(data[:FillGhostTagLen], want[:])
+}
@@ -0,0 +1,5665 @@
+package tls
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	stdtls "crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	mrand "math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fillGhostTestCertificate 生成测试用的自签名证书
+func fillGhostTestCertificate(t testing.TB) Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fillghost.test"},
+		DNSNames:     []string{"fillghost.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// fillGhostTCPPair 返回一对本地回环 TCP 连接
+func fillGhostTCPPair(t testing.TB) (net.Conn, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			accepted <- nil
+			return
+		}
+		accepted <- c
+	}()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-accepted
+	if server == nil {
+		t.Fatal("accept failed")
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server, client
+}
+
+// fillGhostStdPair 建立 本包服务端 <-> 标准库 crypto/tls 客户端 的连接
+func fillGhostStdPair(t testing.TB) (*Conn, *stdtls.Conn) {
+	t.Helper()
+	sc, cc := fillGhostTCPPair(t)
+	return fillGhostStdHandshake(t, sc, cc)
+}
+
+// fillGhostStdHandshake 在给定的底层连接上完成 TLS 1.3 握手
+func fillGhostStdHandshake(t testing.TB, sc, cc net.Conn) (*Conn, *stdtls.Conn) {
+	t.Helper()
+	return fillGhostHandshake(t, sc, cc, VersionTLS13)
+}
+
+// fillGhostStdVersionPair 建立协商指定版本的连接对；TLS 1.2 下 suites 非空时限定密码套件
+func fillGhostStdVersionPair(t testing.TB, vers uint16, suites ...uint16) (*Conn, *stdtls.Conn) {
+	t.Helper()
+	sc, cc := fillGhostTCPPair(t)
+	return fillGhostHandshake(t, sc, cc, vers, suites...)
+}
+
+// fillGhostForkPair 返回两端都使用本包 Conn 的已握手连接，acceptFillGhost 设置客户端的 Config.AcceptFillGhost
+func fillGhostForkPair(t testing.TB, vers uint16, acceptFillGhost bool) (server, client *Conn) {
+	t.Helper()
+	sc, cc := fillGhostTCPPair(t)
+	server = Server(sc, &Config{
+		Certificates: []Certificate{fillGhostTestCertificate(t)},
+		MinVersion:   vers,
+		MaxVersion:   vers,
+	})
+	client = Client(cc, &Config{InsecureSkipVerify: true, MinVersion: vers, MaxVersion: vers, AcceptFillGhost: acceptFillGhost})
+	errc := make(chan error, 1)
+	go func() { errc <- client.Handshake() }()
+	if err := server.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+func fillGhostHandshake(t testing.TB, sc, cc net.Conn, vers uint16, suites ...uint16) (*Conn, *stdtls.Conn) {
+	t.Helper()
+	server := Server(sc, &Config{
+		Certificates: []Certificate{fillGhostTestCertificate(t)},
+		MinVersion:   vers,
+		MaxVersion:   vers,
+		CipherSuites: suites,
+	})
+	client := stdtls.Client(cc, &stdtls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         vers,
+		MaxVersion:         vers,
+		CipherSuites:       suites,
+	})
+	errc := make(chan error, 1)
+	go func() { errc <- client.Handshake() }()
+	if err := server.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+func TestFillGhostRecordDecryptsAtStockPeer(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 1000, MaxLen: 1000})
+
+	const n = 3
+	for i := 0; i < n; i++ {
+		if err := fg.injectOne(); err != nil {
+			t.Fatalf("injectOne: %v", err)
+		}
+	}
+	stats := fg.Stats()
+	if stats.PacketsInjected != n || stats.BytesInjectedPlaintext != n*1000 || stats.Errors != 0 {
+		t.Errorf("Stats() = %+v, want %d packets of 1000 bytes", stats, n)
+	}
+	if want := uint64(n * (recordHeaderLen + 1000 + 1 + 16)); stats.BytesOnWire != want {
+		t.Errorf("BytesOnWire = %d, want %d", stats.BytesOnWire, want)
+	}
+	msg := []byte("real application data")
+	go server.Write(msg)
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	ghost := make([]byte, n*1000)
+	if _, err := io.ReadFull(client, ghost); err != nil {
+		t.Fatalf("reading ghost records: %v", err)
+	}
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(client, got); err != nil {
+		t.Fatalf("reading real data after ghosts: %v", err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Errorf("real data = %q, want %q", got, msg)
+	}
+}
+
+func TestFillGhostConcurrentWriteAndInject(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	const ghostLen = 700
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen:   ghostLen,
+		MaxLen:   ghostLen,
+		Interval: 50 * time.Microsecond,
+	})
+
+	received := make(chan int64, 1)
+	readErr := make(chan error, 1)
+	go func() {
+		client.SetReadDeadline(time.Now().Add(20 * time.Second))
+		n, err := io.Copy(io.Discard, client)
+		received <- n
+		readErr <- err
+	}()
+
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	msg := bytes.Repeat([]byte{'x'}, 333)
+	var writes int64
+	for deadline := time.Now().Add(200 * time.Millisecond); time.Now().Before(deadline); writes++ {
+		if _, err := server.Write(msg); err != nil {
+			t.Fatalf("Write #%d: %v", writes, err)
+		}
+	}
+	fg.Stop()
+	server.Close()
+
+	n := <-received
+	if err := <-readErr; err != nil {
+		t.Fatalf("peer failed to decrypt the stream: %v", err)
+	}
+	stats := fg.Stats()
+	if stats.PacketsInjected == 0 {
+		t.Fatal("no ghost records were injected")
+	}
+	if ghostBytes := uint64(n - writes*int64(len(msg))); ghostBytes != stats.BytesInjectedPlaintext {
+		t.Errorf("peer received %d ghost bytes, controller reports %d", ghostBytes, stats.BytesInjectedPlaintext)
+	}
+}
+
+func TestFillGhostControllersShareConn(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	small := NewFillGhostController(server, FillGhostConfig{MinLen: 1, MaxLen: 64, Interval: 50 * time.Microsecond})
+	large := NewFillGhostController(server, FillGhostConfig{MinLen: 1000, MaxLen: 1400, Interval: 50 * time.Microsecond})
+
+	received := make(chan int64, 1)
+	readErr := make(chan error, 1)
+	go func() {
+		client.SetReadDeadline(time.Now().Add(20 * time.Second))
+		n, err := io.Copy(io.Discard, client)
+		received <- n
+		readErr <- err
+	}()
+
+	for _, fg := range []*FillGhostController{small, large} {
+		if err := fg.Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msg := bytes.Repeat([]byte{'x'}, 333)
+	var writes int64
+	for deadline := time.Now().Add(200 * time.Millisecond); time.Now().Before(deadline); writes++ {
+		if _, err := server.Write(msg); err != nil {
+			t.Fatalf("Write #%d: %v", writes, err)
+		}
+	}
+	small.Stop()
+	large.Stop()
+	server.Close()
+
+	n := <-received
+	if err := <-readErr; err != nil {
+		t.Fatalf("peer failed to decrypt the stream: %v", err)
+	}
+	s, l := small.Stats(), large.Stats()
+	if s.PacketsInjected == 0 || l.PacketsInjected == 0 {
+		t.Fatalf("injected %d and %d records, want both non-zero", s.PacketsInjected, l.PacketsInjected)
+	}
+	if ghostBytes := uint64(n - writes*int64(len(msg))); ghostBytes != s.BytesInjectedPlaintext+l.BytesInjectedPlaintext {
+		t.Errorf("peer received %d ghost bytes, controllers report %d + %d", ghostBytes, s.BytesInjectedPlaintext, l.BytesInjectedPlaintext)
+	}
+}
+
+func TestFillGhostSequenceStress(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	zeros := make([]byte, maxPlaintext)
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen:      1,
+		MaxLen:      4096,
+		Interval:    20 * time.Microsecond,
+		PayloadFunc: func(n int) []byte { return zeros[:n] },
+	})
+
+	type result struct {
+		total, real int64
+		err         error
+	}
+	done := make(chan result, 1)
+	go func() {
+		client.SetReadDeadline(time.Now().Add(30 * time.Second))
+		var r result
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := client.Read(buf)
+			r.total += int64(n)
+			r.real += int64(n - bytes.Count(buf[:n], []byte{0}))
+			if err != nil {
+				if err != io.EOF {
+					r.err = err
+				}
+				done <- r
+				return
+			}
+		}
+	}()
+
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	const writers = 4
+	var wg sync.WaitGroup
+	var written int64
+	deadline := time.Now().Add(300 * time.Millisecond)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(size int) {
+			defer wg.Done()
+			msg := bytes.Repeat([]byte{'x'}, size)
+			for time.Now().Before(deadline) {
+				if _, err := server.Write(msg); err != nil {
+					t.Errorf("Write: %v", err)
+					return
+				}
+				atomic.AddInt64(&written, int64(size))
+			}
+		}(100 + i*5000)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for time.Now().Before(deadline) {
+			if _, err := fg.InjectNow(2); err != nil {
+				t.Errorf("InjectNow: %v", err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	fg.Stop()
+	server.Close()
+
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("peer failed to decrypt the stream: %v", r.err)
+	}
+	if r.real != written {
+		t.Errorf("peer received %d real bytes, wrote %d", r.real, written)
+	}
+	if ghost := uint64(r.total - r.real); ghost != fg.Stats().BytesInjectedPlaintext {
+		t.Errorf("peer received %d ghost bytes, controller reports %d", ghost, fg.Stats().BytesInjectedPlaintext)
+	}
+}
+
+func TestFillGhostPoissonIntervalMean(t *testing.T) {
+	const rate = 1000.0
+	fg := NewFillGhostController(nil, FillGhostConfig{Distribution: DistributionPoisson, Rate: rate})
+	const samples = 5000
+	var sum time.Duration
+	for i := 0; i < samples; i++ {
+		d, err := fg.nextInterval()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d < 0 {
+			t.Fatalf("negative interval %v", d)
+		}
+		sum += d
+	}
+	mean := sum.Seconds() / samples
+	if want := 1 / rate; math.Abs(mean-want) > 0.1*want {
+		t.Errorf("mean interval = %v, want %v ±10%%", mean, want)
+	}
+}
+
+func TestFillGhostInjectDuringLargeWrite(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 1, MaxLen: 1400})
+
+	readErr := make(chan error, 1)
+	go func() {
+		client.SetReadDeadline(time.Now().Add(20 * time.Second))
+		_, err := io.Copy(io.Discard, client)
+		readErr <- err
+	}()
+
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	big := make([]byte, 1<<20)
+	for i := 0; i < 4; i++ {
+		if _, err := server.Write(big); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fg.Stop()
+	server.Close()
+	if err := <-readErr; err != nil {
+		t.Fatalf("peer failed to parse the stream: %v", err)
+	}
+
+	if err := server.FillGhostInjectRawRecord([]byte{0x17, 0x03, 0x03, 0, 0}); !errors.Is(err, ErrFillGhostClosed) {
+		t.Errorf("FillGhostInjectRawRecord after Close = %v, want ErrFillGhostClosed", err)
+	}
+}
+
+func TestFillGhostConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  FillGhostConfig
+		ok   bool
+	}{
+		{"zero", FillGhostConfig{}, true},
+		{"zero MinLen", FillGhostConfig{MinLen: 0, MaxLen: 100}, true},
+		{"equal lengths", FillGhostConfig{MinLen: 900, MaxLen: 900}, true},
+		{"MaxLen at limit", FillGhostConfig{MinLen: 1, MaxLen: maxPlaintext}, true},
+		{"MaxLen over limit", FillGhostConfig{MinLen: 1, MaxLen: maxPlaintext + 1}, false},
+		{"negative MinLen", FillGhostConfig{MinLen: -1, MaxLen: 100}, false},
+		{"negative MaxLen", FillGhostConfig{MinLen: 0, MaxLen: -1}, false},
+		{"MinLen above MaxLen", FillGhostConfig{MinLen: 1401, MaxLen: 1400}, false},
+		{"zero Interval", FillGhostConfig{MaxLen: 10, Interval: 0}, true},
+		{"negative Interval", FillGhostConfig{MaxLen: 10, Interval: -time.Millisecond}, false},
+		{"negative InitialDelay", FillGhostConfig{MaxLen: 10, InitialDelay: -1}, false},
+		{"negative Jitter", FillGhostConfig{MaxLen: 10, Jitter: -1}, false},
+		{"interval range", FillGhostConfig{MaxLen: 10, IntervalMin: 1, IntervalMax: 2}, true},
+		{"IntervalMin only", FillGhostConfig{MaxLen: 10, IntervalMin: 1}, false},
+		{"inverted interval range", FillGhostConfig{MaxLen: 10, IntervalMin: 2, IntervalMax: 1}, false},
+		{"negative IntervalMin", FillGhostConfig{MaxLen: 10, IntervalMin: -1, IntervalMax: 1}, false},
+		{"Poisson", FillGhostConfig{MaxLen: 10, Distribution: DistributionPoisson, Rate: 10}, true},
+		{"Poisson without Rate", FillGhostConfig{MaxLen: 10, Distribution: DistributionPoisson}, false},
+		{"exponential mean", FillGhostConfig{MaxLen: 10, Distribution: DistributionExponential, IntervalMean: time.Millisecond}, true},
+		{"mean and Rate", FillGhostConfig{MaxLen: 10, Distribution: DistributionExponential, IntervalMean: 1, Rate: 1}, false},
+		{"negative IntervalCap", FillGhostConfig{MaxLen: 10, IntervalCap: -1}, false},
+		{"unknown Distribution", FillGhostConfig{MaxLen: 10, Distribution: 42}, false},
+		{"length weights", FillGhostConfig{LengthWeights: []LengthWeight{{512, 1}, {1400, 3}}}, true},
+		{"zero weight", FillGhostConfig{LengthWeights: []LengthWeight{{512, 0}}}, false},
+		{"negative weight", FillGhostConfig{LengthWeights: []LengthWeight{{512, -1}}}, false},
+		{"oversized weighted length", FillGhostConfig{LengthWeights: []LengthWeight{{maxPlaintext + 1, 1}}}, false},
+		{"InnerContentType", FillGhostConfig{MaxLen: 10, InnerContentType: 0x42}, true},
+		{"application data InnerContentType", FillGhostConfig{MaxLen: 10, InnerContentType: 0x17}, true},
+		{"handshake InnerContentType", FillGhostConfig{MaxLen: 10, InnerContentType: 0x16}, false},
+		{"heartbeat InnerContentType", FillGhostConfig{MaxLen: 10, InnerContentType: 0x18}, false},
+		{"MarkInnerType and InnerContentType", FillGhostConfig{MaxLen: 10, MarkInnerType: true, InnerContentType: 0x42}, false},
+		{"MarkInnerType and its own type", FillGhostConfig{MaxLen: 10, MarkInnerType: true, InnerContentType: FillGhostContentType}, true},
+		{"RecordVersion", FillGhostConfig{MaxLen: 10, RecordVersion: [2]byte{3, 1}}, true},
+		{"bogus RecordVersion", FillGhostConfig{MaxLen: 10, RecordVersion: [2]byte{0x16, 3}}, false},
+		{"future RecordVersion", FillGhostConfig{MaxLen: 10, RecordVersion: [2]byte{3, 5}}, false},
+		{"MaxPacketsPerSec", FillGhostConfig{MaxLen: 10, MaxPacketsPerSec: 100}, true},
+		{"negative MaxPacketsPerSec", FillGhostConfig{MaxLen: 10, MaxPacketsPerSec: -1}, false},
+		{"negative Duration", FillGhostConfig{MaxLen: 10, Duration: -1}, false},
+		{"MaxDuration", FillGhostConfig{MaxLen: 10, MaxPackets: 10, MaxDuration: time.Minute}, true},
+		{"Duration and MaxDuration", FillGhostConfig{MaxLen: 10, Duration: time.Hour, MaxDuration: time.Minute}, true},
+		{"negative MaxDuration", FillGhostConfig{MaxLen: 10, MaxDuration: -1}, false},
+		{"negative InjectTimeout", FillGhostConfig{MaxLen: 10, InjectTimeout: -1}, false},
+		{"negative InjectLogSize", FillGhostConfig{MaxLen: 10, InjectLogSize: -1}, false},
+		{"negative OpeningBurst", FillGhostConfig{MaxLen: 10, OpeningBurst: -1}, false},
+		{"negative OpeningBurstJitter", FillGhostConfig{MaxLen: 10, OpeningBurstJitter: -1}, false},
+		{"OpeningBurst", FillGhostConfig{MaxLen: 10, OpeningBurst: 3, OpeningBurstJitter: time.Millisecond}, true},
+		{"InitialDelayJitter", FillGhostConfig{MaxLen: 10, InitialDelay: time.Second, InitialDelayJitter: time.Second}, true},
+		{"negative InitialDelayJitter", FillGhostConfig{MaxLen: 10, InitialDelayJitter: -1}, false},
+		{"overflowing InitialDelayJitter", FillGhostConfig{MaxLen: 10, InitialDelay: time.Second, InitialDelayJitter: math.MaxInt64}, false},
+		{"Mirror", FillGhostConfig{MaxLen: 10, Mirror: true}, true},
+		{"Mirror and Learn", FillGhostConfig{MaxLen: 10, Mirror: true, Learn: true}, false},
+		{"Warmup", FillGhostConfig{MaxLen: 10, Interval: time.Second, Warmup: time.Minute, WarmupInterval: time.Minute}, true},
+		{"negative Warmup", FillGhostConfig{MaxLen: 10, Warmup: -1}, false},
+		{"negative WarmupInterval", FillGhostConfig{MaxLen: 10, Warmup: time.Minute, WarmupInterval: -1}, false},
+		{"ActiveWindows", FillGhostConfig{MaxLen: 10, ActiveWindows: []FillGhostWindow{{22 * time.Hour, 24 * time.Hour}, {0, 6 * time.Hour}}}, true},
+		{"negative window start", FillGhostConfig{MaxLen: 10, ActiveWindows: []FillGhostWindow{{-time.Second, time.Hour}}}, false},
+		{"window start at 24h", FillGhostConfig{MaxLen: 10, ActiveWindows: []FillGhostWindow{{24 * time.Hour, time.Hour}}}, false},
+		{"window end past 24h", FillGhostConfig{MaxLen: 10, ActiveWindows: []FillGhostWindow{{time.Hour, 25 * time.Hour}}}, false},
+		{"empty window", FillGhostConfig{MaxLen: 10, ActiveWindows: []FillGhostWindow{{time.Hour, time.Hour}}}, false},
+		{"ActiveWindows and Schedule", FillGhostConfig{MaxLen: 10, ActiveWindows: []FillGhostWindow{{0, time.Hour}}, Schedule: []time.Duration{0}}, false},
+		{"normal lengths", FillGhostConfig{MaxLen: 10, LengthDistribution: LengthDistributionNormal, LengthMean: 5, LengthStdDev: 2}, true},
+		{"normal mean outside range", FillGhostConfig{MinLen: 5, MaxLen: 10, LengthDistribution: LengthDistributionNormal, LengthMean: 4}, false},
+		{"NaN normal mean", FillGhostConfig{MaxLen: 10, LengthDistribution: LengthDistributionNormal, LengthMean: math.NaN()}, false},
+		{"negative LengthStdDev", FillGhostConfig{MaxLen: 10, LengthDistribution: LengthDistributionNormal, LengthMean: 5, LengthStdDev: -1}, false},
+		{"infinite LengthStdDev", FillGhostConfig{MaxLen: 10, LengthDistribution: LengthDistributionNormal, LengthMean: 5, LengthStdDev: math.Inf(1)}, false},
+		{"normal lengths and LengthWeights", FillGhostConfig{MaxLen: 10, LengthDistribution: LengthDistributionNormal, LengthMean: 5, LengthWeights: []LengthWeight{{5, 1}}}, false},
+		{"unknown LengthDistribution", FillGhostConfig{MaxLen: 10, LengthDistribution: 7}, false},
+		{"LengthBuckets", FillGhostConfig{LengthBuckets: []int{0, 512, maxPlaintext}}, true},
+		{"unsorted LengthBuckets", FillGhostConfig{LengthBuckets: []int{1024, 512}}, false},
+		{"duplicate LengthBuckets", FillGhostConfig{LengthBuckets: []int{512, 512}}, false},
+		{"negative length bucket", FillGhostConfig{LengthBuckets: []int{-1, 512}}, false},
+		{"oversized length bucket", FillGhostConfig{LengthBuckets: []int{maxPlaintext + 1}}, false},
+		{"fragmented length bucket", FillGhostConfig{Fragment: true, LengthBuckets: []int{maxPlaintext + 1}}, true},
+	}
+	for _, tt := range tests {
+		err := tt.cfg.Validate()
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok = %v", tt.name, err, tt.ok)
+		}
+	}
+
+	fg := NewFillGhostController(nil, FillGhostConfig{MinLen: 10, MaxLen: 1})
+	if err := fg.Start(); err == nil {
+		fg.Stop()
+		t.Error("Start accepted an invalid config")
+	}
+
+	for _, tt := range tests {
+		fg, err := NewFillGhostControllerChecked(new(Conn), tt.cfg)
+		if (err == nil) != tt.ok || (fg != nil) != tt.ok {
+			t.Errorf("%s: NewFillGhostControllerChecked() = %v, %v, want ok = %v", tt.name, fg, err, tt.ok)
+		}
+	}
+	if _, err := NewFillGhostControllerChecked(nil, FillGhostConfig{}); err == nil {
+		t.Error("NewFillGhostControllerChecked accepted a nil Conn")
+	}
+}
+
+func TestFillGhostStartContextCancel(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 100, MaxLen: 200, Interval: time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := fg.StartContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	stoppedCh := fg.Done()
+
+	for fg.Stats().PacketsInjected == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-stoppedCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("loop did not exit after the context was cancelled")
+	}
+	fg.mu.Lock()
+	active := fg.active
+	fg.mu.Unlock()
+	if active {
+		t.Error("controller still active after context cancellation")
+	}
+
+	if started := fg.Stats().StartedAt; started.IsZero() || started.After(time.Now()) {
+		t.Errorf("Stats().StartedAt = %v, want the time of StartContext", started)
+	}
+
+	fg.Stop() // must not block or double-close
+	if err := fg.Start(); err != nil {
+		t.Fatalf("Start after cancellation: %v", err)
+	}
+	fg.Stop()
+}
+
+func TestFillGhostStartContextDeadline(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 10, Interval: time.Millisecond})
+	expired, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := fg.StartContext(expired); err != context.Canceled {
+		t.Fatalf("StartContext with a cancelled context = %v, want context.Canceled", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := fg.StartContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	stoppedCh := fg.Done()
+	select {
+	case <-stoppedCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("loop did not exit after the deadline passed")
+	}
+	if err := fg.Start(); err != nil {
+		t.Fatalf("Start after deadline: %v", err)
+	}
+	fg.Stop()
+}
+
+func TestFillGhostUpdateConfig(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 100, MaxLen: 100, Interval: time.Millisecond})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fg.Stop()
+	for fg.Stats().PacketsInjected == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := fg.UpdateConfig(FillGhostConfig{MinLen: 300, MaxLen: 200}); err == nil {
+		t.Error("UpdateConfig accepted MinLen > MaxLen")
+	}
+	if got := fg.config().MinLen; got != 100 {
+		t.Errorf("invalid UpdateConfig was applied: MinLen = %d", got)
+	}
+
+	if err := fg.UpdateConfig(FillGhostConfig{MinLen: 500, MaxLen: 500, Interval: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	// 更新后每个新包都应为 500 字节
+	before := fg.Stats()
+	for fg.Stats().PacketsInjected < before.PacketsInjected+5 {
+		time.Sleep(time.Millisecond)
+	}
+	fg.Stop()
+	after := fg.Stats()
+	// 更新前已在进行中的一轮最多以旧长度完成
+	packets := after.PacketsInjected - before.PacketsInjected
+	plain := after.BytesInjectedPlaintext - before.BytesInjectedPlaintext
+	if plain != packets*500 && plain != (packets-1)*500+100 {
+		t.Errorf("%d packets carried %d bytes after UpdateConfig, want 500 bytes each", packets, plain)
+	}
+}
+
+var errFillGhostTestWrite = errors.New("injected write failure")
+
+// fillGhostFailingConn 在 fail 置位后所有写操作都失败
+type fillGhostFailingConn struct {
+	net.Conn
+	fail int32 // 原子访问
+}
+
+func (c *fillGhostFailingConn) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(&c.fail) != 0 {
+		return 0, errFillGhostTestWrite
+	}
+	return c.Conn.Write(b)
+}
+
+// fillGhostTemporaryConn 启用后每三次写入有一次不写出任何字节、返回超时错误
+type fillGhostTemporaryConn struct {
+	net.Conn
+	enabled int32 // 原子访问
+	writes  int32
+}
+
+func (c *fillGhostTemporaryConn) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(&c.enabled) != 0 && atomic.AddInt32(&c.writes, 1)%3 == 0 {
+		return 0, os.ErrDeadlineExceeded
+	}
+	return c.Conn.Write(b)
+}
+
+func TestFillGhostRetryTemporaryWrite(t *testing.T) {
+	sc, cc := fillGhostTCPPair(t)
+	flaky := &fillGhostTemporaryConn{Conn: sc}
+	server, client := fillGhostStdHandshake(t, flaky, cc)
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, client)
+		readErr <- err
+	}()
+
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 100, Interval: time.Millisecond, MaxConsecutiveErrors: 3, RetryBackoff: time.Millisecond})
+	atomic.StoreInt32(&flaky.enabled, 1)
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(10 * time.Second); fg.Stats().PacketsInjected < 20; {
+		if err := fg.Err(); err != nil {
+			t.Fatalf("loop exited on a temporary write error: %v", err)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d records injected", fg.Stats().PacketsInjected)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	fg.Stop()
+	if err := fg.Err(); err != nil {
+		t.Errorf("Err() = %v after temporary write errors, want nil", err)
+	}
+	st := fg.Stats()
+	if st.Retries == 0 || st.Retries != st.Errors {
+		t.Errorf("Retries = %d, Errors = %d; want every temporary failure retried", st.Retries, st.Errors)
+	}
+	// 未写出的记录没有消耗序号，对端照常解密后续的记录
+	atomic.StoreInt32(&flaky.enabled, 0)
+	if _, err := server.Write([]byte("real")); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	if err := <-readErr; err != nil {
+		t.Errorf("peer read failed after retried injections: %v", err)
+	}
+
+	// 非临时错误仍使写方向失效，不重试
+	if !fillGhostTemporary(os.ErrDeadlineExceeded) || fillGhostTemporary(errFillGhostTestWrite) {
+		t.Error("fillGhostTemporary misclassifies errors")
+	}
+}
+
+func TestFillGhostWriteErrorSurfaced(t *testing.T) {
+	sc, cc := fillGhostTCPPair(t)
+	failing := &fillGhostFailingConn{Conn: sc}
+	server, _ := fillGhostStdHandshake(t, failing, cc)
+	atomic.StoreInt32(&failing.fail, 1)
+
+	errc := make(chan error, 1)
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen:   10,
+		MaxLen:   10,
+		Interval: time.Millisecond,
+		OnError:  func(err error) { errc <- err },
+	})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	var got error
+	select {
+	case got = <-errc:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnError was not called")
+	}
+	fg.Stop()
+
+	var fgErr *FillGhostError
+	if !errors.As(got, &fgErr) || fgErr.Op != "write" {
+		t.Errorf("OnError got %v, want a write FillGhostError", got)
+	}
+	if err := fg.Err(); !errors.Is(err, errFillGhostTestWrite) {
+		t.Errorf("Err() = %v, want it to wrap %v", err, errFillGhostTestWrite)
+	}
+	if fg.Stats().Errors != 1 {
+		t.Errorf("Stats().Errors = %d, want 1", fg.Stats().Errors)
+	}
+	select {
+	case err := <-fg.Errors():
+		if !errors.Is(err, errFillGhostTestWrite) {
+			t.Errorf("Errors() delivered %v", err)
+		}
+	default:
+		t.Error("Errors() channel is empty")
+	}
+
+	// 握手前没有 AEAD
+	fresh := NewFillGhostController(Server(nil, &Config{}), FillGhostConfig{MinLen: 1, MaxLen: 1})
+	if err := fresh.injectOne(); !errors.Is(err, ErrFillGhostNoAEAD) {
+		t.Errorf("injectOne before handshake = %v, want ErrFillGhostNoAEAD", err)
+	}
+}
+
+func TestFillGhostErrorPolicyContinue(t *testing.T) {
+	sc, cc := fillGhostTCPPair(t)
+	failing := &fillGhostFailingConn{Conn: sc}
+	server, _ := fillGhostStdHandshake(t, failing, cc)
+	atomic.StoreInt32(&failing.fail, 1)
+
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen:      10,
+		MaxLen:      10,
+		Interval:    time.Millisecond,
+		ErrorPolicy: ErrorPolicyContinue,
+	})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fg.Stop()
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-fg.Errors():
+			if !errors.Is(err, errFillGhostTestWrite) {
+				t.Fatalf("Errors() delivered %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d errors reported", i)
+		}
+	}
+	fg.mu.Lock()
+	active := fg.active
+	fg.mu.Unlock()
+	if !active {
+		t.Error("loop exited despite ErrorPolicyContinue")
+	}
+	if err := fg.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil while continuing", err)
+	}
+}
+
+// fillGhostFlakyReader 前 fail 次读取失败，之后从 crypto/rand 读取
+type fillGhostFlakyReader struct {
+	fail int32 // 原子访问
+}
+
+var errFillGhostTestRand = errors.New("injected rand failure")
+
+func (r *fillGhostFlakyReader) Read(p []byte) (int, error) {
+	if atomic.AddInt32(&r.fail, -1) >= 0 {
+		return 0, errFillGhostTestRand
+	}
+	return rand.Read(p)
+}
+
+func TestFillGhostRetry(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	// 暂时性错误在连续失败次数内被重试，循环继续注入
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen: 10, MaxLen: 10, Interval: time.Millisecond,
+		Rand:                 &fillGhostFlakyReader{fail: 2},
+		MaxConsecutiveErrors: 3,
+		RetryBackoff:         time.Millisecond,
+	})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-fg.Errors():
+			if !errors.Is(err, errFillGhostTestRand) {
+				t.Fatalf("Errors() delivered %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d retries reported", i)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for fg.Stats().PacketsInjected == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	fg.Stop()
+	if st := fg.Stats(); st.PacketsInjected == 0 || st.Retries != 2 || st.Errors != 2 {
+		t.Errorf("Stats = %d injected, %d retries, %d errors; want injections after 2 retries", st.PacketsInjected, st.Retries, st.Errors)
+	}
+	if err := fg.Err(); err != nil {
+		t.Errorf("Err() = %v after recovering", err)
+	}
+
+	// 持续失败时退避逐次翻倍，达到上限后退出
+	clk := NewFakeFillGhostClock(time.Now())
+	fg = NewFillGhostController(server, FillGhostConfig{
+		MinLen: 10, MaxLen: 10, Interval: time.Millisecond, Clock: clk,
+		Rand:                 &fillGhostFlakyReader{fail: math.MaxInt32},
+		MaxConsecutiveErrors: 4,
+		RetryBackoff:         10 * time.Millisecond,
+	})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond} {
+		clk.BlockUntil(1)
+		if at, ok := fg.NextInjectAt(); !ok || at.Sub(clk.Now()) != want {
+			t.Fatalf("NextInjectAt() = %v, %v; want a %v backoff", at.Sub(clk.Now()), ok, want)
+		}
+		clk.Advance(want)
+	}
+	if err := fg.Wait(); !errors.Is(err, errFillGhostTestRand) {
+		t.Errorf("Wait() = %v, want the rand error after 4 consecutive failures", err)
+	}
+	if st := fg.Stats(); st.Retries != 3 {
+		t.Errorf("Retries = %d, want 3", st.Retries)
+	}
+
+	// 写坏连接的错误不重试
+	sc, cc := fillGhostTCPPair(t)
+	failing := &fillGhostFailingConn{Conn: sc}
+	broken, _ := fillGhostStdHandshake(t, failing, cc)
+	atomic.StoreInt32(&failing.fail, 1)
+	fg = NewFillGhostController(broken, FillGhostConfig{MinLen: 10, MaxLen: 10, MaxConsecutiveErrors: 5})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fg.Wait(); !errors.Is(err, errFillGhostTestWrite) {
+		t.Errorf("Wait() = %v, want the write error", err)
+	}
+	if st := fg.Stats(); st.Retries != 0 {
+		t.Errorf("write failure retried %d times", st.Retries)
+	}
+
+	for _, cfg := range []FillGhostConfig{
+		{MaxConsecutiveErrors: -1},
+		{RetryBackoff: -1},
+		{MaxConsecutiveErrors: 3, ErrorPolicy: ErrorPolicyContinue},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", cfg)
+		}
+	}
+	if d := (&FillGhostConfig{}).retryBackoff(20); d != time.Second {
+		t.Errorf("backoff after 20 failures = %v, want the 1s cap", d)
+	}
+}
+
+func TestFillGhostConnClosed(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  FillGhostConfig
+	}{
+		// 长间隔的等待被 Close 唤醒，不必等到下一次注入
+		{"sleeping", FillGhostConfig{MinLen: 10, MaxLen: 10, Interval: time.Hour}},
+		// ErrorPolicyContinue 下也不会对着已关闭的连接反复重试
+		{"continue", FillGhostConfig{MinLen: 10, MaxLen: 10, Interval: time.Millisecond, ErrorPolicy: ErrorPolicyContinue}},
+		{"paused", FillGhostConfig{MinLen: 10, MaxLen: 10, Interval: time.Millisecond}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, client := fillGhostStdPair(t)
+			go io.Copy(io.Discard, client)
+			fg := NewFillGhostController(server, tc.cfg)
+			if err := fg.Start(); err != nil {
+				t.Fatal(err)
+			}
+			if tc.name == "paused" {
+				fg.Pause()
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for fg.Stats().PacketsInjected == 0 && tc.name != "paused" && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			if server.FillGhostClosed() {
+				t.Fatal("FillGhostClosed() before Close")
+			}
+			server.Close()
+			if !server.FillGhostClosed() {
+				t.Fatal("FillGhostClosed() = false after Close")
+			}
+			select {
+			case <-fg.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("controller kept running on a closed Conn")
+			}
+			if err := fg.Err(); err != ErrFillGhostClosed {
+				t.Errorf("Err() = %v, want ErrFillGhostClosed", err)
+			}
+			if st := fg.Stats(); st.StopReason != ErrFillGhostClosed {
+				t.Errorf("StopReason = %v, want ErrFillGhostClosed", st.StopReason)
+			}
+			fg.mu.Lock()
+			active := fg.active
+			fg.mu.Unlock()
+			if active {
+				t.Error("controller still active after the Conn closed")
+			}
+		})
+	}
+}
+
+// fillGhostWaitStalled 等到 fg 的注入卡在不读取的对端上：已注入的记录数不再增长
+func fillGhostWaitStalled(t *testing.T, fg *FillGhostController) {
+	t.Helper()
+	last := uint64(0)
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		time.Sleep(20 * time.Millisecond)
+		n := fg.Stats().PacketsInjected
+		if n > 0 && n == last {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("injection never stalled")
+		}
+		last = n
+	}
+}
+
+func TestFillGhostCloseStalledPeer(t *testing.T) {
+	// 对端从不读取，注入阻塞在写出中；Close 看到进行中的写操作，直接关闭底层连接
+	server, _ := fillGhostStdPair(t)
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 16000, MaxLen: 16000, Interval: time.Microsecond})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	fillGhostWaitStalled(t, fg)
+	closed := make(chan struct{})
+	go func() {
+		server.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close hung behind an injection blocked on a stalled peer")
+	}
+	select {
+	case <-fg.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("controller kept running after Close")
+	}
+	if err := server.FillGhostInjectRawRecord([]byte{23, 3, 3, 0, 0}); err != ErrFillGhostClosed {
+		t.Errorf("FillGhostInjectRawRecord after Close = %v, want ErrFillGhostClosed", err)
+	}
+}
+
+func TestFillGhostOnInject(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	var fg *FillGhostController
+	got := make(chan InjectMeta, 1)
+	fg = NewFillGhostController(server, FillGhostConfig{
+		MinLen:   64,
+		MaxLen:   64,
+		Interval: time.Millisecond,
+		OnInject: func(m InjectMeta) {
+			fg.Pause() // 回调中调用控制器方法不能死锁
+			select {
+			case got <- m:
+			default:
+			}
+		},
+	})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fg.Stop()
+	select {
+	case m := <-got:
+		if m.PlaintextLen != 64 || m.WireLen != recordHeaderLen+64+1+16 {
+			t.Errorf("OnInject(%d, %d), want (64, %d)", m.PlaintextLen, m.WireLen, recordHeaderLen+64+1+16)
+		}
+		if m.Time.IsZero() {
+			t.Error("OnInject meta has zero Time")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnInject was not called")
+	}
+}
+
+func TestFillGhostOnInjectMeta(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	var metas []InjectMeta
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen:   10,
+		MaxLen:   200,
+		Interval: time.Hour,
+		OnInject: func(m InjectMeta) { metas = append(metas, m) },
+	})
+	const n = 5
+	if got, err := fg.InjectNow(n); err != nil || got != n {
+		t.Fatalf("InjectNow(%d) = %d, %v", n, got, err)
+	}
+	if len(metas) != n {
+		t.Fatalf("OnInject called %d times, want %d", len(metas), n)
+	}
+	var plain, wire uint64
+	for i, m := range metas {
+		if m.PlaintextLen < 10 || m.PlaintextLen > 200 {
+			t.Errorf("record %d: PlaintextLen = %d, want within [10, 200]", i, m.PlaintextLen)
+		}
+		if m.WireLen != recordHeaderLen+m.PlaintextLen+1+16 {
+			t.Errorf("record %d: WireLen = %d, want %d", i, m.WireLen, recordHeaderLen+m.PlaintextLen+1+16)
+		}
+		if i > 0 && m.Seq != metas[i-1].Seq+1 {
+			t.Errorf("record %d: Seq = %d, want %d", i, m.Seq, metas[i-1].Seq+1)
+		}
+		plain += uint64(m.PlaintextLen)
+		wire += uint64(m.WireLen)
+	}
+	st := fg.Stats()
+	if st.BytesInjectedPlaintext != plain || st.BytesOnWire != wire {
+		t.Errorf("Stats = %d/%d bytes, OnInject summed %d/%d", st.BytesInjectedPlaintext, st.BytesOnWire, plain, wire)
+	}
+	if !st.LastInjectAt.Equal(metas[n-1].Time) {
+		t.Errorf("LastInjectAt = %v, want %v", st.LastInjectAt, metas[n-1].Time)
+	}
+}
+
+func TestFillGhostOnBeforeInject(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	// 未设置回调时使用采样长度
+	var lengths []int
+	cfg := FillGhostConfig{
+		MinLen:   10,
+		MaxLen:   20,
+		Interval: time.Hour,
+		OnInject: func(m InjectMeta) { lengths = append(lengths, m.PlaintextLen) },
+	}
+	fg := NewFillGhostController(server, cfg)
+	if n, err := fg.InjectNow(3); err != nil || n != 3 {
+		t.Fatalf("InjectNow(3) = %d, %v", n, err)
+	}
+	for _, l := range lengths {
+		if l < 10 || l > 20 {
+			t.Errorf("nil hook: injected %d bytes, want within [10, 20]", l)
+		}
+	}
+
+	// 改写长度，不受 MinLen/MaxLen 限制
+	lengths = nil
+	var proposed []int
+	cfg.OnBeforeInject = func(l int) (int, bool) {
+		proposed = append(proposed, l)
+		return 1200, true
+	}
+	fg = NewFillGhostController(server, cfg)
+	if n, err := fg.InjectNow(2); err != nil || n != 2 {
+		t.Fatalf("InjectNow(2) = %d, %v", n, err)
+	}
+	if len(proposed) != 2 || proposed[0] < 10 || proposed[0] > 20 {
+		t.Errorf("OnBeforeInject proposals = %v, want 2 within [10, 20]", proposed)
+	}
+	if len(lengths) != 2 || lengths[0] != 1200 || lengths[1] != 1200 {
+		t.Errorf("resized lengths = %v, want [1200 1200]", lengths)
+	}
+
+	cfg.OnBeforeInject = func(int) (int, bool) { return maxPlaintext + 1, true }
+	fg = NewFillGhostController(server, cfg)
+	if _, err := fg.InjectNow(1); err == nil {
+		t.Error("InjectNow accepted an oversized OnBeforeInject length")
+	}
+	if st := fg.Stats(); st.Errors != 1 || st.PacketsInjected != 0 {
+		t.Errorf("oversized: Stats = %+v, want 1 error", st)
+	}
+
+	// 否决：每次只放行一条，其余计入 TicksSkipped
+	var calls int32
+	cfg.Interval = time.Millisecond
+	cfg.OnInject = nil
+	cfg.OnBeforeInject = func(l int) (int, bool) {
+		return l, atomic.AddInt32(&calls, 1)%4 == 0
+	}
+	fg = NewFillGhostController(server, cfg)
+	if n, err := fg.InjectNow(1); err != ErrFillGhostVetoed || n != 0 {
+		t.Errorf("InjectNow(1) = %d, %v, want ErrFillGhostVetoed", n, err)
+	}
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for fg.Stats().PacketsInjected < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	fg.Stop()
+	st := fg.Stats()
+	if st.PacketsInjected < 3 {
+		t.Fatalf("injected %d records, want at least 3", st.PacketsInjected)
+	}
+	if total := uint64(atomic.LoadInt32(&calls)); st.PacketsInjected+st.TicksSkipped != total {
+		t.Errorf("injected %d + skipped %d, want %d hook calls", st.PacketsInjected, st.TicksSkipped, total)
+	}
+	if err := fg.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil after vetoes", err)
+	}
+}
+
+func TestFillGhostIntervalRange(t *testing.T) {
+	const lo, hi = 2 * time.Millisecond, 5 * time.Millisecond
+	fg := NewFillGhostController(nil, FillGhostConfig{Interval: time.Second, IntervalMin: lo, IntervalMax: hi})
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 1000; i++ {
+		d, err := fg.nextInterval()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d < lo || d > hi {
+			t.Fatalf("interval %v outside [%v, %v]", d, lo, hi)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 2 {
+		t.Error("all sampled intervals are identical")
+	}
+
+	fixed := NewFillGhostController(nil, FillGhostConfig{Interval: 3 * time.Millisecond})
+	if d, _ := fixed.nextInterval(); d != 3*time.Millisecond {
+		t.Errorf("Interval-only config waited %v, want 3ms", d)
+	}
+}
+
+func TestFillGhostExponentialIntervalMean(t *testing.T) {
+	const mean = time.Millisecond
+	fg := NewFillGhostController(nil, FillGhostConfig{Distribution: DistributionExponential, IntervalMean: mean})
+	const samples = 5000
+	var sum time.Duration
+	for i := 0; i < samples; i++ {
+		d, err := fg.nextInterval()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d > fillGhostExpCapFactor*mean {
+			t.Fatalf("interval %v exceeds the default cap", d)
+		}
+		sum += d
+	}
+	if got := sum / samples; math.Abs(float64(got-mean)) > 0.1*float64(mean) {
+		t.Errorf("mean interval = %v, want %v ±10%%", got, mean)
+	}
+
+	capped := NewFillGhostController(nil, FillGhostConfig{Distribution: DistributionExponential, IntervalMean: time.Second, IntervalCap: time.Millisecond})
+	for i := 0; i < 100; i++ {
+		if d, _ := capped.nextInterval(); d > time.Millisecond {
+			t.Fatalf("interval %v exceeds IntervalCap", d)
+		}
+	}
+}
+
+// fillGhostTestLogger 收集日志行
+type fillGhostTestLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *fillGhostTestLogger) Printf(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *fillGhostTestLogger) count(substr string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, line := range l.lines {
+		if strings.Contains(line, substr) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestFillGhostLogger(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	logger := new(fillGhostTestLogger)
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen:   10,
+		MaxLen:   10,
+		Interval: time.Millisecond,
+		Logger:   logger,
+		Verbose:  true,
+	})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for fg.Stats().PacketsInjected < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	fg.Stop()
+	if logger.count("fillghost: injection started") != 1 || logger.count("fillghost: injection stopped") != 1 {
+		t.Errorf("missing lifecycle messages: %q", logger.lines)
+	}
+	if got, want := uint64(logger.count("fillghost: injected 10 bytes")), fg.Stats().PacketsInjected; got != want {
+		t.Errorf("logged %d injections, want %d", got, want)
+	}
+}
+
+// fillGhostTestLevelLogger 按级别收集日志行，实现 FillGhostLevelLogger
+type fillGhostTestLevelLogger struct {
+	fillGhostTestLogger
+}
+
+func (l *fillGhostTestLevelLogger) Debugf(format string, args ...any) {
+	l.Printf("DEBUG "+format, args...)
+}
+
+func (l *fillGhostTestLevelLogger) Warnf(format string, args ...any) {
+	l.Printf("WARN "+format, args...)
+}
+
+func TestFillGhostLevelLogger(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	// 每条注入的 Debugf 每秒至多一条，省略的条数附在下一条中
+	logger := new(fillGhostTestLevelLogger)
+	clk := NewFakeFillGhostClock(time.Now())
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 10, Interval: 100 * time.Millisecond, Clock: clk, Logger: logger})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	clk.BlockUntil(1)
+	for i := 0; i < 10; i++ {
+		clk.Advance(100 * time.Millisecond)
+		clk.BlockUntil(1)
+	}
+	if n := fg.Stats().PacketsInjected; n != 11 {
+		t.Fatalf("%d records injected, want 11", n)
+	}
+	if got := logger.count("DEBUG fillghost: injected 10 bytes"); got != 2 {
+		t.Errorf("%d injection debug lines over 1s, want 2: %q", got, logger.lines)
+	}
+	if logger.count("(9 similar messages suppressed)") != 1 {
+		t.Errorf("second debug line lacks the suppressed count: %q", logger.lines)
+	}
+	fg.Pause()
+	fg.Resume()
+	fg.Stop()
+	for _, want := range []string{"fillghost: injection started", "fillghost: injection paused", "fillghost: injection resumed", "fillghost: injection stopped"} {
+		if logger.count(want) != 1 || logger.count("DEBUG "+want) != 0 {
+			t.Errorf("lifecycle message %q not logged once with Printf: %q", want, logger.lines)
+		}
+	}
+
+	// 被否决的注入以 Debugf 输出，同样限流
+	logger = new(fillGhostTestLevelLogger)
+	veto := NewFillGhostController(server, FillGhostConfig{MaxLen: 10, Logger: logger, OnBeforeInject: func(int) (int, bool) { return 0, false }})
+	for i := 0; i < 3; i++ {
+		veto.InjectNow(1)
+	}
+	if logger.count("DEBUG fillghost: skipped injection") != 1 {
+		t.Errorf("vetoed injections: %q, want one skipped debug line", logger.lines)
+	}
+
+	// 写密钥轮换后序号从 0 重新开始，以 Printf 记录
+	logger = new(fillGhostTestLevelLogger)
+	rot := NewFillGhostController(server, FillGhostConfig{MaxLen: 10, Logger: logger})
+	if _, err := rot.InjectNow(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := fillGhostRequestKeyUpdate(server); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rot.InjectNow(1); err != nil {
+		t.Fatal(err)
+	}
+	if logger.count("fillghost: write key rotated, sequence numbers restarted at 0") != 1 {
+		t.Errorf("key rotation not logged: %q", logger.lines)
+	}
+
+	// 致命错误以 Warnf 输出
+	logger = new(fillGhostTestLevelLogger)
+	failing := NewFillGhostController(server, FillGhostConfig{MaxLen: 10, Logger: logger, Rand: &fillGhostFlakyReader{fail: 1}})
+	if err := failing.Start(); err != nil {
+		t.Fatal(err)
+	}
+	failing.Wait()
+	if logger.count("WARN fillghost: injection error") != 1 {
+		t.Errorf("fatal error not logged with Warnf: %q", logger.lines)
+	}
+}
+
+// fillGhostFixedSampler 总是返回同一个长度
+type fillGhostFixedSampler int
+
+func (s fillGhostFixedSampler) Sample() (int, error) { return int(s), nil }
+
+func TestFillGhostLengthSamplers(t *testing.T) {
+	uniform := UniformLengthSampler{Min: 10, Max: 20}
+	normal := NormalLengthSampler{Mean: 100, StdDev: 10000}
+	hist, err := NewHistogramLengthSampler([]int{1, 1500, 1500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if n, _ := uniform.Sample(); n < 10 || n > 20 {
+			t.Fatalf("uniform sample %d outside [10, 20]", n)
+		}
+		if n, _ := normal.Sample(); n < 1 || n > maxPlaintext {
+			t.Fatalf("normal sample %d not clamped to [1, %d]", n, maxPlaintext)
+		}
+		if n, _ := hist.Sample(); n != 1 && n != 1500 {
+			t.Fatalf("histogram sample %d not in the observed sizes", n)
+		}
+	}
+	if _, err := NewHistogramLengthSampler(nil); err == nil {
+		t.Error("empty histogram accepted")
+	}
+	if _, err := NewHistogramLengthSampler([]int{maxPlaintext + 1}); err == nil {
+		t.Error("oversized histogram entry accepted")
+	}
+
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+	for _, bad := range []int{-1, maxPlaintext + 1} {
+		fg := NewFillGhostController(server, FillGhostConfig{LengthSampler: fillGhostFixedSampler(bad)})
+		var fgErr *FillGhostError
+		if err := fg.injectOne(); !errors.As(err, &fgErr) || fgErr.Op != "length" {
+			t.Errorf("sampled length %d: injectOne = %v, want a length FillGhostError", bad, err)
+		}
+	}
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 1, MaxLen: 1, LengthSampler: fillGhostFixedSampler(777)})
+	if err := fg.injectOne(); err != nil {
+		t.Fatal(err)
+	}
+	if got := fg.Stats().BytesInjectedPlaintext; got != 777 {
+		t.Errorf("injected %d bytes, want the sampler's 777", got)
+	}
+}
+
+func TestFillGhostLengthCDF(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		sizes      []int
+		cumulative []float64
+	}{
+		{"empty", nil, nil},
+		{"mismatched", []int{1, 2}, []float64{1}},
+		{"decreasing", []int{1, 2, 3}, []float64{0.5, 0.4, 1}},
+		{"short of one", []int{1, 2}, []float64{0.5, 0.9}},
+		{"above one", []int{1, 2}, []float64{0.5, 1.1}},
+		{"oversized", []int{maxPlaintext + 1}, []float64{1}},
+	} {
+		if _, err := NewCDFLengthSampler(tc.sizes, tc.cumulative); err == nil {
+			t.Errorf("%s: CDF accepted", tc.name)
+		}
+	}
+
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 1, MaxLen: 1})
+	if err := fg.SetLengthCDF([]int{100, 200, 300}, []float64{0.2, 0.2, 1}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := fg.config()
+	counts := make(map[int]int)
+	const samples = 4000
+	for i := 0; i < samples; i++ {
+		n, err := cfg.sampleLength()
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[n]++
+	}
+	if counts[200] != 0 || len(counts) != 2 {
+		t.Fatalf("sampled lengths %v, want only 100 and 300", counts)
+	}
+	if frac := float64(counts[100]) / samples; math.Abs(frac-0.2) > 0.05 {
+		t.Errorf("length 100 chosen %.2f of the time, want 0.2", frac)
+	}
+	if err := fg.injectOne(); err != nil {
+		t.Fatal(err)
+	}
+	if got := fg.Stats().BytesInjectedPlaintext; got != 100 && got != 300 {
+		t.Errorf("injected %d bytes, want a length from the CDF", got)
+	}
+}
+
+func TestFillGhostLengthWeights(t *testing.T) {
+	cfg := FillGhostConfig{LengthWeights: []LengthWeight{{512, 1}, {1400, 3}}}
+	counts := make(map[int]int)
+	const samples = 4000
+	for i := 0; i < samples; i++ {
+		n, err := cfg.sampleLength()
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[n]++
+	}
+	if len(counts) != 2 {
+		t.Fatalf("sampled lengths %v, want only 512 and 1400", counts)
+	}
+	if frac := float64(counts[1400]) / samples; math.Abs(frac-0.75) > 0.05 {
+		t.Errorf("length 1400 chosen %.2f of the time, want 0.75", frac)
+	}
+
+	fallback := FillGhostConfig{MinLen: 7, MaxLen: 7}
+	if n, _ := fallback.sampleLength(); n != 7 {
+		t.Errorf("empty LengthWeights sampled %d, want MinLen/MaxLen", n)
+	}
+}
+
+func TestFillGhostNormalLength(t *testing.T) {
+	cfg := FillGhostConfig{MinLen: 0, MaxLen: 4000, LengthDistribution: LengthDistributionNormal, LengthMean: 1200, LengthStdDev: 150}
+	const samples = 20000
+	var sum, sumSq float64
+	for i := 0; i < samples; i++ {
+		n, err := cfg.sampleLength()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum += float64(n)
+		sumSq += float64(n) * float64(n)
+	}
+	mean := sum / samples
+	variance := sumSq/samples - mean*mean
+	// 标准误约 150/sqrt(20000) ≈ 1.1，方差的相对标准误约 1%
+	if math.Abs(mean-1200) > 6 {
+		t.Errorf("empirical mean %.1f, want 1200", mean)
+	}
+	if math.Abs(variance-150*150)/(150*150) > 0.06 {
+		t.Errorf("empirical variance %.0f, want %d", variance, 150*150)
+	}
+
+	// 尾部截断到边界：区间远窄于标准差时绝大多数样本落在 MinLen 或 MaxLen
+	tails := FillGhostConfig{MinLen: 100, MaxLen: 110, LengthDistribution: LengthDistributionNormal, LengthMean: 105, LengthStdDev: 1000}
+	counts := make(map[int]int)
+	for i := 0; i < 1000; i++ {
+		n, err := tails.sampleLength()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n < 100 || n > 110 {
+			t.Fatalf("normal sample %d not clamped to [100, 110]", n)
+		}
+		counts[n]++
+	}
+	if counts[100] < 400 || counts[110] < 400 {
+		t.Errorf("tail samples not clamped to the bounds: %v", counts)
+	}
+
+	fixed := FillGhostConfig{MaxLen: 100, LengthDistribution: LengthDistributionNormal, LengthMean: 42.4}
+	if n, _ := fixed.sampleLength(); n != 42 {
+		t.Errorf("zero LengthStdDev sampled %d, want the rounded mean 42", n)
+	}
+	cfg.Rand = &fillGhostFlakyReader{fail: 1}
+	var fgErr *FillGhostError
+	if _, err := cfg.sampleLength(); !errors.As(err, &fgErr) || fgErr.Op != "rand" {
+		t.Errorf("failing Rand: sampleLength = %v, want a rand FillGhostError", err)
+	}
+}
+
+func TestFillGhostLengthBuckets(t *testing.T) {
+	buckets := []int{512, 1024, 1500}
+	fg := NewFillGhostController(nil, FillGhostConfig{})
+	cfg := FillGhostConfig{LengthBuckets: buckets}
+	counts := make(map[int]int)
+	for i := 0; i < 3000; i++ {
+		n, err := fg.proposeLength(&cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[n]++
+	}
+	// MinLen/MaxLen 未设置时在 [0, 1500] 内均匀采样，各桶份额与所覆盖的区间成正比
+	if len(counts) != 3 {
+		t.Fatalf("proposed lengths %v, want only the buckets %v", counts, buckets)
+	}
+	if frac := float64(counts[512]) / 3000; math.Abs(frac-513.0/1501) > 0.05 {
+		t.Errorf("bucket 512 chosen %.2f of the time, want %.2f", frac, 513.0/1501)
+	}
+
+	for _, tt := range []struct{ sampled, want int }{{0, 512}, {512, 512}, {513, 1024}, {1400, 1500}, {9000, 1500}} {
+		cfg := FillGhostConfig{LengthBuckets: buckets, LengthSampler: fillGhostFixedSampler(tt.sampled)}
+		var seen int
+		cfg.OnBeforeInject = func(n int) (int, bool) {
+			seen = n
+			return n, true
+		}
+		if n, err := fg.proposeLength(&cfg); err != nil || n != tt.want || seen != tt.want {
+			t.Errorf("sampled %d: proposed %d (hook saw %d), err %v, want bucket %d", tt.sampled, n, seen, err, tt.want)
+		}
+	}
+
+	guarded := FillGhostConfig{MinLen: 600, MaxLen: 1000, LengthBuckets: buckets}
+	for i := 0; i < 100; i++ {
+		if n, _ := fg.proposeLength(&guarded); n != 1024 {
+			t.Fatalf("lengths drawn from [600, 1000] snapped to %d, want 1024", n)
+		}
+	}
+
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+	inj := NewFillGhostController(server, FillGhostConfig{MinLen: 1, MaxLen: 10, LengthBuckets: []int{333}})
+	for i := 0; i < 3; i++ {
+		if err := inj.injectOne(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := inj.Stats().BytesInjectedPlaintext; got != 3*333 {
+		t.Errorf("injected %d bytes, want 3 records of 333", got)
+	}
+}
+
+func TestFillGhostPayloadFunc(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen:      32,
+		MaxLen:      32,
+		PayloadFunc: func(n int) []byte { return bytes.Repeat([]byte{'z'}, n) },
+	})
+	if err := fg.injectOne(); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got := make([]byte, 32)
+	if _, err := io.ReadFull(client, got); err != nil {
+		t.Fatal(err)
+	}
+	if want := bytes.Repeat([]byte{'z'}, 32); !bytes.Equal(got, want) {
+		t.Errorf("peer received %q, want %q", got, want)
+	}
+
+	short := NewFillGhostController(server, FillGhostConfig{
+		MinLen:      32,
+		MaxLen:      32,
+		PayloadFunc: func(n int) []byte { return make([]byte, n-1) },
+	})
+	var fgErr *FillGhostError
+	if err := short.injectOne(); !errors.As(err, &fgErr) || fgErr.Op != "payload" {
+		t.Errorf("short payload: injectOne = %v, want a payload FillGhostError", err)
+	}
+}
+
+// fillGhostRecordingGenerator 记录每次生成的负载，供测试与对端收到的明文比对
+type fillGhostRecordingGenerator struct {
+	gen  PayloadGenerator
+	seen []byte
+}
+
+func (g *fillGhostRecordingGenerator) Fill(buf []byte) error {
+	if err := g.gen.Fill(buf); err != nil {
+		return err
+	}
+	g.seen = append(g.seen, buf...)
+	return nil
+}
+
+func TestFillGhostPayloadGenerator(t *testing.T) {
+	const alphabet = "<>/abc "
+	text, err := NewTextPayloadGenerator(alphabet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, gen := range []PayloadGenerator{RandomPayloadGenerator{}, text} {
+		server, client := fillGhostStdPair(t)
+		rec := &fillGhostRecordingGenerator{gen: gen}
+		fg := NewFillGhostController(server, FillGhostConfig{
+			MinLen:           100,
+			MaxLen:           2000,
+			PayloadGenerator: rec,
+			PayloadFunc:      func(n int) []byte { panic("PayloadFunc used despite PayloadGenerator") },
+		})
+		if n, err := fg.InjectNow(4); err != nil || n != 4 {
+			t.Fatalf("%T: InjectNow(4) = %d, %v", gen, n, err)
+		}
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		got := make([]byte, len(rec.seen))
+		if _, err := io.ReadFull(client, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, rec.seen) {
+			t.Errorf("%T: peer plaintext differs from generated payload", gen)
+		}
+		if gen == text {
+			if i := bytes.IndexFunc(got, func(r rune) bool { return !strings.ContainsRune(alphabet, r) }); i >= 0 {
+				t.Errorf("text payload byte %q at %d is outside the alphabet", got[i], i)
+			}
+		}
+	}
+
+	for _, bad := range []string{"aa", "ab\xff"} {
+		if _, err := NewTextPayloadGenerator(bad); err == nil {
+			t.Errorf("NewTextPayloadGenerator(%q) accepted an invalid alphabet", bad)
+		}
+	}
+	def, err := NewTextPayloadGenerator("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4096)
+	if err := def.Fill(buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []byte(DefaultTextAlphabet) {
+		if bytes.IndexByte(buf, c) < 0 {
+			t.Errorf("4096 default text bytes never contained %q", c)
+		}
+	}
+
+	server, _ := fillGhostStdPair(t)
+	failing := NewFillGhostController(server, FillGhostConfig{MaxLen: 10, PayloadGenerator: new(TextPayloadGenerator)})
+	var fgErr *FillGhostError
+	if err := failing.injectOne(); !errors.As(err, &fgErr) || fgErr.Op != "payload" {
+		t.Errorf("zero TextPayloadGenerator: injectOne = %v, want a payload FillGhostError", err)
+	}
+}
+
+func TestFillGhostSeededRand(t *testing.T) {
+	text, err := NewTextPayloadGenerator("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hist, err := NewHistogramLengthSampler([]int{10, 20, 30, 40, 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type trace struct {
+		lengths   []int
+		intervals []time.Duration
+		payload   []byte
+	}
+	run := func(cfg FillGhostConfig, seed int64) trace {
+		server, client := fillGhostStdPair(t)
+		var tr trace
+		cfg.Rand = mrand.New(mrand.NewSource(seed))
+		cfg.OnInject = func(m InjectMeta) { tr.lengths = append(tr.lengths, m.PlaintextLen) }
+		fg := NewFillGhostController(server, cfg)
+		for i := 0; i < 10; i++ {
+			if _, err := fg.InjectNow(1); err != nil {
+				t.Fatal(err)
+			}
+			d, err := fg.nextInterval()
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr.intervals = append(tr.intervals, d)
+		}
+		total := 0
+		for _, n := range tr.lengths {
+			total += n
+		}
+		tr.payload = make([]byte, total)
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := io.ReadFull(client, tr.payload); err != nil {
+			t.Fatal(err)
+		}
+		return tr
+	}
+	for _, cfg := range []FillGhostConfig{
+		{MinLen: 1, MaxLen: 1400, Interval: 10 * time.Millisecond, Jitter: 5 * time.Millisecond},
+		{LengthSampler: hist, Distribution: DistributionPoisson, Rate: 100, PayloadGenerator: text},
+		{LengthWeights: []LengthWeight{{100, 1}, {1000, 2}}, IntervalMin: time.Millisecond, IntervalMax: time.Second},
+	} {
+		a, b := run(cfg, 42), run(cfg, 42)
+		if fmt.Sprint(a.lengths) != fmt.Sprint(b.lengths) || fmt.Sprint(a.intervals) != fmt.Sprint(b.intervals) || !bytes.Equal(a.payload, b.payload) {
+			t.Errorf("same seed produced different traces:\n%v %v\n%v %v", a.lengths, a.intervals, b.lengths, b.intervals)
+		}
+		if c := run(cfg, 43); bytes.Equal(a.payload, c.payload) {
+			t.Errorf("different seeds produced the same payload")
+		}
+	}
+}
+
+// 固定长度时不从 Rand 取长度，负载就是 Rand 输出的前若干字节，可以逐字节断言
+func TestFillGhostRandExactPayload(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	want := make([]byte, 96)
+	for i := range want {
+		want[i] = byte(i * 7)
+	}
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 32, MaxLen: 32, Rand: bytes.NewReader(want)})
+	if _, err := fg.InjectNow(3); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(want))
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(client, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("payload bytes = %x, want %x", got, want)
+	}
+	// 随机源耗尽时注入失败，而不是悄悄退回 crypto/rand
+	if _, err := fg.InjectNow(1); err == nil {
+		t.Error("InjectNow succeeded with an exhausted Rand")
+	}
+}
+
+func TestFillGhostPauseResume(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 100})
+	fg.Pause() // 未运行时为空操作
+	fg.Resume()
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for fg.Stats().PacketsInjected == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	fg.Pause()
+	paused := fg.Stats().PacketsInjected
+	time.Sleep(20 * time.Millisecond)
+	if got := fg.Stats().PacketsInjected; got != paused {
+		t.Fatalf("%d packets injected after Pause returned", got-paused)
+	}
+	fg.Pause() // 重复暂停为空操作
+	fg.Resume()
+	for fg.Stats().PacketsInjected == paused {
+		time.Sleep(time.Millisecond)
+	}
+	fg.Resume() // 未暂停时为空操作
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				switch (i + j) % 3 {
+				case 0:
+					fg.Pause()
+				case 1:
+					fg.Resume()
+				case 2:
+					if j%10 == 2 {
+						fg.Stop()
+						fg.Start()
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	fg.Stop()
+}
+
+func TestFillGhostDoneAndWait(t *testing.T) {
+	sc, cc := fillGhostTCPPair(t)
+	failing := &fillGhostFailingConn{Conn: sc}
+	server, _ := fillGhostStdHandshake(t, failing, cc)
+
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 10, Interval: time.Millisecond})
+	done := fg.Done() // 启动前取得
+	select {
+	case <-done:
+		t.Fatal("Done closed before Start")
+	default:
+	}
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&failing.fail, 1)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Done obtained before Start did not close when the loop failed")
+	}
+	if err := fg.Wait(); !errors.Is(err, errFillGhostTestWrite) {
+		t.Errorf("Wait() = %v, want the write failure", err)
+	}
+
+	// Stop 后 Done 关闭，Wait 返回 nil（写错误会粘在 Conn 上，换一条连接）
+	server2, _ := fillGhostStdPair(t)
+	fresh := NewFillGhostController(server2, FillGhostConfig{MinLen: 10, MaxLen: 10, Interval: time.Millisecond})
+	if err := fresh.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go fresh.Stop()
+	if err := fresh.Wait(); err != nil {
+		t.Errorf("Wait() after Stop = %v, want nil", err)
+	}
+}
+
+func TestFillGhostDoneReasons(t *testing.T) {
+	waitDone := func(t *testing.T, fg *FillGhostController) {
+		t.Helper()
+		select {
+		case <-fg.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("Done not closed")
+		}
+	}
+	t.Run("context", func(t *testing.T) {
+		server, client := fillGhostStdPair(t)
+		go io.Copy(io.Discard, client)
+		ctx, cancel := context.WithCancel(context.Background())
+		fg := NewFillGhostController(server, FillGhostConfig{MaxLen: 10, Interval: time.Hour})
+		if err := fg.StartContext(ctx); err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		waitDone(t, fg)
+	})
+	t.Run("limit", func(t *testing.T) {
+		server, client := fillGhostStdPair(t)
+		go io.Copy(io.Discard, client)
+		fg := NewFillGhostController(server, FillGhostConfig{MaxLen: 10, MaxPackets: 3})
+		if err := fg.Start(); err != nil {
+			t.Fatal(err)
+		}
+		waitDone(t, fg)
+		if reason := fg.Stats().StopReason; !errors.Is(reason, ErrFillGhostPacketLimit) {
+			t.Errorf("StopReason = %v, want ErrFillGhostPacketLimit", reason)
+		}
+	})
+	t.Run("closed", func(t *testing.T) {
+		server, client := fillGhostStdPair(t)
+		go io.Copy(io.Discard, client)
+		fg := NewFillGhostController(server, FillGhostConfig{MaxLen: 10, Interval: time.Hour})
+		if err := fg.Start(); err != nil {
+			t.Fatal(err)
+		}
+		server.Close()
+		waitDone(t, fg)
+		if reason := fg.Stats().StopReason; !errors.Is(reason, ErrFillGhostClosed) {
+			t.Errorf("StopReason = %v, want ErrFillGhostClosed", reason)
+		}
+	})
+}
+
+// fillGhostDeadlineConn 记录最近一次设置的写截止时间
+type fillGhostDeadlineConn struct {
+	net.Conn
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func (c *fillGhostDeadlineConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *fillGhostDeadlineConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *fillGhostDeadlineConn) lastWriteDeadline() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.deadline
+}
+
+func TestFillGhostStopInterruptsBlockedInject(t *testing.T) {
+	stopWithin := func(t *testing.T, fg *FillGhostController, d time.Duration) {
+		t.Helper()
+		stopped := make(chan struct{})
+		go func() {
+			fg.Stop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(d):
+			t.Fatal("Stop hung on a blocked injection")
+		}
+		if err := fg.Err(); err != nil {
+			t.Errorf("Err() = %v after Stop, want nil", err)
+		}
+	}
+
+	// 应用的 Write 阻塞在不读取的对端上并持有写方向锁，循环等待该锁；
+	// IdleThreshold、MaxOverheadRatio 与重试在注入前后读取的 Conn 状态同样不能等该锁
+	for _, tc := range []struct {
+		name string
+		cfg  FillGhostConfig
+	}{
+		{"lock", FillGhostConfig{MaxLen: 10, Interval: time.Millisecond}},
+		{"idle", FillGhostConfig{MaxLen: 10, Interval: time.Millisecond, IdleThreshold: time.Millisecond}},
+		{"overhead", FillGhostConfig{MaxLen: 10, Interval: time.Millisecond, MaxOverheadRatio: 1, OverheadAllowance: 1 << 20}},
+		{"retry", FillGhostConfig{MaxLen: 10, Interval: time.Millisecond, InjectTimeout: 10 * time.Millisecond, MaxConsecutiveErrors: 1000}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, _ := fillGhostStdPair(t)
+			// Start 检查套件时也要取写方向锁，须在应用 Write 阻塞之前启动
+			fg := NewFillGhostController(server, tc.cfg)
+			if err := fg.Start(); err != nil {
+				t.Fatal(err)
+			}
+			appDone := make(chan error, 1)
+			go func() {
+				_, err := server.Write(make([]byte, 64<<20))
+				appDone <- err
+			}()
+			time.Sleep(100 * time.Millisecond) // 等 Write 填满缓冲区后阻塞在锁内
+			stopWithin(t, fg, 5*time.Second)
+			// 放弃等锁不影响应用的 Write
+			select {
+			case err := <-appDone:
+				t.Errorf("application Write returned %v while the peer is stalled", err)
+			default:
+			}
+		})
+	}
+
+	// 没有设置 InjectTimeout，注入本身阻塞在已满的发送缓冲区上
+	t.Run("write", func(t *testing.T) {
+		server, _ := fillGhostStdPair(t)
+		fg := NewFillGhostController(server, FillGhostConfig{MinLen: 16000, MaxLen: 16000})
+		if err := fg.Start(); err != nil {
+			t.Fatal(err)
+		}
+		fillGhostWaitStalled(t, fg)
+		stopWithin(t, fg, 5*time.Second)
+	})
+
+	// Conn.Close 同样及时返回：InjectNow 阻塞在写出中，或循环在等应用 Write 占用的写方向锁
+	for _, tc := range []struct {
+		name     string
+		appWrite bool
+	}{
+		{"close write", false},
+		{"close lock", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, _ := fillGhostStdPair(t)
+			fg := NewFillGhostController(server, FillGhostConfig{MinLen: 16000, MaxLen: 16000, Interval: time.Millisecond})
+			injected := make(chan error, 1)
+			if tc.appWrite {
+				if err := fg.Start(); err != nil {
+					t.Fatal(err)
+				}
+				go server.Write(make([]byte, 64<<20))
+			} else {
+				go func() {
+					_, err := fg.InjectNow(1 << 10)
+					injected <- err
+				}()
+			}
+			time.Sleep(200 * time.Millisecond) // 等发送缓冲区写满
+			closed := make(chan struct{})
+			go func() {
+				server.Close()
+				close(closed)
+			}()
+			select {
+			case <-closed:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Close hung on a blocked injection")
+			}
+			if tc.appWrite {
+				select {
+				case <-fg.Done():
+				case <-time.After(5 * time.Second):
+					t.Fatal("controller kept running after Close")
+				}
+				if err := fg.Err(); !errors.Is(err, ErrFillGhostClosed) {
+					t.Errorf("Err() after Close = %v, want ErrFillGhostClosed", err)
+				}
+				return
+			}
+			select {
+			case err := <-injected:
+				if err == nil {
+					t.Error("InjectNow succeeded on a stalled peer")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("InjectNow still blocked after Close")
+			}
+		})
+	}
+}
+
+func TestFillGhostInjectTimeout(t *testing.T) {
+	// 对端正常读取时超时不触发，写截止时间不受影响
+	live, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+	if _, err := NewFillGhostController(live, FillGhostConfig{MaxLen: 100, InjectTimeout: 100 * time.Millisecond}).InjectNow(20); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond) // 已停止的定时器不会再设置截止时间
+	if _, err := live.Write([]byte("real")); err != nil {
+		t.Fatalf("Write after timed injections: %v", err)
+	}
+
+	// 对端从不读取，发送缓冲区很快写满；应用自己设置了较远的写截止时间
+	sc, cc := fillGhostTCPPair(t)
+	dl := &fillGhostDeadlineConn{Conn: sc}
+	server, _ := fillGhostStdHandshake(t, dl, cc)
+	appDeadline := time.Now().Add(time.Hour)
+	server.SetWriteDeadline(appDeadline)
+
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 16000, MaxLen: 16000, InjectTimeout: 200 * time.Millisecond})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// 等到注入不再前进，即写操作阻塞在已满的缓冲区上
+	last := uint64(0)
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		time.Sleep(20 * time.Millisecond)
+		n := fg.Stats().PacketsInjected
+		if n > 0 && n == last {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("injection never stalled")
+		}
+		last = n
+	}
+	stopped := make(chan struct{})
+	go func() {
+		fg.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop hung on a stalled write despite InjectTimeout")
+	}
+	var fgErr *FillGhostError
+	if err := fg.Err(); !errors.As(err, &fgErr) || fgErr.Op != "write" || !errors.Is(err, ErrFillGhostInjectTimeout) {
+		t.Errorf("Err() = %v, want a write FillGhostError wrapping ErrFillGhostInjectTimeout", err)
+	}
+	var netErr net.Error
+	if err := fg.Err(); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("Err() = %v does not wrap the underlying timeout error", err)
+	}
+	// 中断时借用的写截止时间已恢复为应用的设置
+	if d := dl.lastWriteDeadline(); !d.Equal(appDeadline) {
+		t.Errorf("write deadline after the interrupt = %v, want the application's %v", d, appDeadline)
+	}
+	// 中断时可能一个字节都没有写出，写方向仍可用，但缓冲区仍满，Write 只会超时
+	server.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := server.Write([]byte("x")); err == nil {
+		t.Error("Write to a stalled peer succeeded")
+	}
+}
+
+func TestFillGhostAdaptive(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	if !server.FillGhostLastWriteTime().IsZero() {
+		t.Fatal("last write time set before any Write")
+	}
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 10, Interval: 50 * time.Millisecond, Adaptive: true})
+	if _, err := server.Write([]byte("real")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fg.Stop()
+	for deadline := time.Now().Add(200 * time.Millisecond); time.Now().Before(deadline); {
+		if _, err := server.Write([]byte("real")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := fg.Stats().PacketsInjected; got != 0 {
+		t.Errorf("%d ghosts injected while real data was flowing", got)
+	}
+	if since := time.Since(server.FillGhostLastWriteTime()); since > time.Second {
+		t.Errorf("last write time is %v old", since)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for fg.Stats().PacketsInjected == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no ghost injected after the connection went idle")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFillGhostMaxTotalBytes(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	received := make(chan int, 1)
+	go func() {
+		n, _ := io.Copy(io.Discard, client)
+		received <- int(n)
+	}()
+
+	// 第一条记录 5+50+1+16=72 字节，剩余 28 字节只够 6 字节负载
+	const budget = 100
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 50, MaxLen: 50, MaxTotalBytes: budget})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fg.Wait(); err != ErrFillGhostBudgetExhausted {
+		t.Fatalf("Wait() = %v, want ErrFillGhostBudgetExhausted", err)
+	}
+	st := fg.Stats()
+	if st.BytesOnWire != budget || st.PacketsInjected != 2 || st.BytesInjectedPlaintext != 56 {
+		t.Errorf("stats = %+v, want 2 records filling exactly %d bytes", st, budget)
+	}
+	if st.StopReason != ErrFillGhostBudgetExhausted {
+		t.Errorf("StopReason = %v, want ErrFillGhostBudgetExhausted", st.StopReason)
+	}
+	fg.Stop() // 自行停止后 Stop 不阻塞
+
+	server.Close()
+	if n := <-received; n != 56 {
+		t.Errorf("peer received %d bytes of ghost payload, want 56", n)
+	}
+}
+
+func TestFillGhostBurst(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	var mu sync.Mutex
+	var times []time.Time
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen: 10, MaxLen: 100,
+		Burst: FillGhostBurst{BurstSize: 5, BurstInterval: 100 * time.Millisecond},
+		OnInject: func(InjectMeta) {
+			mu.Lock()
+			times = append(times, time.Now())
+			mu.Unlock()
+		},
+	})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for fg.Stats().PacketsInjected < 10 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	fg.Stop()
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 1; i < 10; i++ {
+		gap := times[i].Sub(times[i-1])
+		if i%5 == 0 && gap < 50*time.Millisecond {
+			t.Errorf("gap %v between bursts, want about 100ms", gap)
+		}
+		if i%5 != 0 && gap > 50*time.Millisecond {
+			t.Errorf("gap %v inside a burst, want back-to-back records", gap)
+		}
+	}
+
+	// 突发内记录之间的等待同样可被 Stop 打断
+	slow := NewFillGhostController(server, FillGhostConfig{
+		MinLen: 10, MaxLen: 10,
+		Burst: FillGhostBurst{BurstSize: 1000, Jitter: time.Hour},
+	})
+	if err := slow.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for slow.Stats().PacketsInjected == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		slow.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked in the middle of a burst")
+	}
+
+	cfg := FillGhostConfig{Burst: FillGhostBurst{BurstSize: -1}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("negative BurstSize accepted")
+	}
+}
+
+func TestFillGhostMaxPackets(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	received := make(chan int, 1)
+	go func() {
+		n, _ := io.Copy(io.Discard, client)
+		received <- int(n)
+	}()
+
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 10, Interval: time.Millisecond, MaxPackets: 5})
+	for run := 1; run <= 2; run++ {
+		if err := fg.Start(); err != nil {
+			t.Fatal(err)
+		}
+		if err := fg.Wait(); err != ErrFillGhostPacketLimit {
+			t.Fatalf("run %d: Wait() = %v, want ErrFillGhostPacketLimit", run, err)
+		}
+		st := fg.Stats()
+		if st.PacketsInjected != uint64(5*run) {
+			t.Errorf("run %d: %d packets injected, want %d", run, st.PacketsInjected, 5*run)
+		}
+		if st.StopReason != ErrFillGhostPacketLimit {
+			t.Errorf("run %d: StopReason = %v, want ErrFillGhostPacketLimit", run, st.StopReason)
+		}
+	}
+	server.Close()
+	if n := <-received; n != 2*5*10 {
+		t.Errorf("peer received %d bytes, want exactly ten 10-byte records", n)
+	}
+}
+
+func TestFillGhostMaxPacketsPerSec(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	const rate = 200
+	var mu sync.Mutex
+	var times []time.Time
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen: 10, MaxLen: 10,
+		Burst:            FillGhostBurst{BurstSize: 5},
+		MaxPacketsPerSec: rate,
+		OnInject: func(meta InjectMeta) {
+			mu.Lock()
+			times = append(times, meta.Time)
+			mu.Unlock()
+		},
+	})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	fg.Stop()
+	server.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(times) < 10 {
+		t.Fatalf("only %d records injected in 500ms", len(times))
+	}
+	// 任意 100ms 窗口内不超过 rate/10 条，留一条余量给计时抖动
+	for i := range times {
+		n := 0
+		for j := i; j < len(times) && times[j].Sub(times[i]) < 100*time.Millisecond; j++ {
+			n++
+		}
+		if n > rate/10+1 {
+			t.Fatalf("%d records within 100ms starting at record %d, want at most %d", n, i, rate/10+1)
+		}
+	}
+}
+
+func TestFillGhostDuration(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	const d = 100 * time.Millisecond
+	const epsilon = 50 * time.Millisecond
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 10, Interval: 5 * time.Millisecond, Duration: d})
+	start := time.Now()
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fg.Wait(); err != ErrFillGhostDurationElapsed {
+		t.Fatalf("Wait() = %v, want ErrFillGhostDurationElapsed", err)
+	}
+	if elapsed := time.Since(start); elapsed < d || elapsed > d+epsilon {
+		t.Errorf("loop ran for %v, want %v", elapsed, d)
+	}
+	st := fg.Stats()
+	if st.PacketsInjected == 0 || st.LastInjectAt.Sub(start) > d+epsilon {
+		t.Errorf("stats = %+v, want injections only within the first %v", st, d)
+	}
+	if st.StopReason != ErrFillGhostDurationElapsed {
+		t.Errorf("StopReason = %v, want ErrFillGhostDurationElapsed", st.StopReason)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		fg.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop blocked after the duration elapsed")
+	}
+
+	// 到期时正处于长时间等待中也会退出
+	fg = NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 10, Interval: time.Hour, Duration: d})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-fg.Done():
+	case <-time.After(d + time.Second):
+		t.Fatal("Duration did not interrupt the interval sleep")
+	}
+}
+
+func TestFillGhostTargetBytesPerSec(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	const target = 200 << 10
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 100, MaxLen: 4000, TargetBytesPerSec: target})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	fg.Stop()
+	st := fg.Stats()
+	rate := float64(st.BytesOnWire) / st.LastInjectAt.Sub(st.StartedAt).Seconds()
+	if rate < 0.5*target || rate > 1.2*target {
+		t.Errorf("ghost throughput %.0f B/s, want about %d B/s", rate, target)
+	}
+
+	cfg := FillGhostConfig{MinLen: 1, MaxLen: 1, Interval: time.Second, TargetBytesPerSec: target}
+	if err := cfg.Validate(); err == nil {
+		t.Error("TargetBytesPerSec together with Interval accepted")
+	}
+}
+
+func TestFillGhostIdleThreshold(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 10, Interval: time.Millisecond, IdleThreshold: 50 * time.Millisecond})
+	write := func(d time.Duration) {
+		for deadline := time.Now().Add(d); time.Now().Before(deadline); {
+			if _, err := server.Write([]byte("real")); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if _, err := server.Write([]byte("real")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fg.Stop()
+	write(150 * time.Millisecond)
+	if got := fg.Stats().PacketsInjected; got != 0 {
+		t.Fatalf("%d ghosts injected while real writes were flowing", got)
+	}
+
+	// 真实写停止后开始注入
+	deadline := time.Now().Add(5 * time.Second)
+	for fg.Stats().PacketsInjected == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no ghost injected after the writes stopped")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// 真实流量恢复后自动暂停
+	if _, err := server.Write([]byte("real")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond) // 让进行中的一次注入完成
+	resumed := fg.Stats().PacketsInjected
+	write(100 * time.Millisecond)
+	if got := fg.Stats().PacketsInjected; got != resumed {
+		t.Errorf("%d ghosts injected after real traffic resumed", got-resumed)
+	}
+}
+
+func TestFillGhostVersions(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		vers   uint16
+		suites []uint16
+	}{
+		{"TLS13", VersionTLS13, nil},
+		{"TLS12-AES-GCM", VersionTLS12, []uint16{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}},
+		{"TLS12-AES-256-GCM", VersionTLS12, []uint16{TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}},
+		{"TLS12-ChaCha20", VersionTLS12, []uint16{TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305}},
+		{"TLS12-AES-CBC-SHA", VersionTLS12, []uint16{TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA}},
+		{"TLS12-AES-CBC-SHA256", VersionTLS12, []uint16{TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, client := fillGhostStdVersionPair(t, tc.vers, tc.suites...)
+			if v := server.FillGhostVersion(); v != tc.vers {
+				t.Fatalf("FillGhostVersion() = %#04x, want %#04x", v, tc.vers)
+			}
+			fg := NewFillGhostController(server, FillGhostConfig{MinLen: 500, MaxLen: 500})
+			msg := []byte("real application data")
+			// 注入与真实写入交替进行，TLS 1.2 的显式 nonce 与附加数据中的序号须与真实记录衔接
+			go func() {
+				for round := 0; round < 2; round++ {
+					for i := 0; i < 3; i++ {
+						if err := fg.injectOne(); err != nil {
+							t.Errorf("injectOne: %v", err)
+							return
+						}
+					}
+					server.Write(msg)
+				}
+			}()
+			got, err := io.ReadAll(io.LimitReader(client, int64(2*(3*500+len(msg)))))
+			if err != nil {
+				t.Fatalf("peer rejected the ghost records: %v", err)
+			}
+			if len(got) != 2*(3*500+len(msg)) || !bytes.Equal(got[3*500:3*500+len(msg)], msg) || !bytes.HasSuffix(got, msg) {
+				t.Error("real data did not follow the ghost records")
+			}
+		})
+	}
+
+	server, _ := fillGhostStdVersionPair(t, VersionTLS11)
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 10})
+	if err := fg.injectOne(); err != ErrFillGhostUnsupportedVersion {
+		t.Errorf("injectOne on TLS 1.1 = %v, want ErrFillGhostUnsupportedVersion", err)
+	}
+	if err := fg.Start(); err != ErrFillGhostUnsupportedVersion {
+		fg.Stop()
+		t.Errorf("Start on TLS 1.1 = %v, want ErrFillGhostUnsupportedVersion", err)
+	}
+}
+
+func TestFillGhostCBC(t *testing.T) {
+	server, client := fillGhostStdVersionPair(t, VersionTLS12, TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA)
+	// 覆盖每一种填充长度，另有一条分片负载与一条零长度负载
+	const maxLen = 48
+	total := 0
+	go func() {
+		for L := 0; L <= maxLen; L++ {
+			fg := NewFillGhostController(server, FillGhostConfig{MinLen: L, MaxLen: L})
+			if err := fg.injectOne(); err != nil {
+				t.Errorf("injectOne(%d): %v", L, err)
+				return
+			}
+		}
+		fg := NewFillGhostController(server, FillGhostConfig{MinLen: 20000, MaxLen: 20000, Fragment: true})
+		if err := fg.injectOne(); err != nil {
+			t.Errorf("fragmented injectOne: %v", err)
+			return
+		}
+		server.Write([]byte("done"))
+	}()
+	for L := 0; L <= maxLen; L++ {
+		total += L
+	}
+	total += 20000 + len("done")
+	got, err := io.ReadAll(io.LimitReader(client, int64(total)))
+	if err != nil {
+		t.Fatalf("peer rejected the CBC ghost records: %v", err)
+	}
+	if len(got) != total || !bytes.HasSuffix(got, []byte("done")) {
+		t.Errorf("peer read %d bytes, want %d ending in the real write", len(got), total)
+	}
+
+	seqs := make(map[[8]byte]bool)
+	for i := 0; i < 2; i++ {
+		server.FillGhostSealAndInject(func(a cipher.AEAD, seq [8]byte) ([]byte, error) {
+			seqs[seq] = true
+			return nil, errors.New("skip")
+		})
+	}
+	if len(seqs) != 1 {
+		t.Error("a failed seal advanced the write sequence number")
+	}
+
+	// 最长的 CBC 记录（最大负载、48 字节 MAC、整块填充）也放得进池中的缓冲区，不重新分配
+	f := &fillGhostCBC{c: cipherAES(make([]byte, 32), make([]byte, 16), false).(cbcMode), mac: hmac.New(sha512.New384, make([]byte, 48)), iv: make([]byte, 16)}
+	buf := new(fillGhostBuf)
+	payload := buf.payload[:fillGhostPayloadCeiling(VersionTLS12, f)]
+	rec, err := buildGhostRecord(buf.record[:0], VersionTLS12, f, [8]byte{}, payload, byte(recordTypeApplicationData), byte(recordTypeApplicationData), [2]byte{3, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := recordHeaderLen + 16 + len(payload) + 48 + 16; len(rec) != want {
+		t.Errorf("max-length CBC record is %d bytes, want %d", len(rec), want)
+	}
+	if &rec[0] != &buf.record[0] {
+		t.Errorf("max-length CBC record of %d bytes was reallocated, buffer holds %d", len(rec), len(buf.record))
+	}
+
+	// RC4 无法封装，Start 应直接报出套件名，而不是启动后在协程中失败
+	c := &Conn{handshakeStatus: 1, cipherSuite: TLS_RSA_WITH_RC4_128_SHA}
+	c.out.version = VersionTLS12
+	c.out.cipher = cipherRC4(make([]byte, 16), nil, false)
+	err = NewFillGhostController(c, FillGhostConfig{MinLen: 10, MaxLen: 10}).Start()
+	if !errors.Is(err, ErrFillGhostUnsupportedCipherSuite) || !strings.Contains(err.Error(), "TLS_RSA_WITH_RC4_128_SHA") {
+		t.Errorf("Start on RC4 = %v, want ErrFillGhostUnsupportedCipherSuite naming the suite", err)
+	}
+}
+
+func TestFillGhostRecordType(t *testing.T) {
+	sc, cc := fillGhostTCPPair(t)
+	server, _ := fillGhostHandshake(t, sc, cc, VersionTLS12)
+
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 10, RecordType: byte(recordTypeAlert)})
+	if err := fg.injectOne(); err != nil {
+		t.Fatal(err)
+	}
+	// TLS 1.2 握手后没有其他待读记录，直接从底层连接读取注入记录的头部
+	header := make([]byte, recordHeaderLen)
+	if _, err := io.ReadFull(cc, header); err != nil {
+		t.Fatal(err)
+	}
+	if header[0] != byte(recordTypeAlert) {
+		t.Errorf("outer content type %#02x, want %#02x", header[0], byte(recordTypeAlert))
+	}
+
+	for _, typ := range []byte{0x13, 0x18, 0xff} {
+		cfg := FillGhostConfig{RecordType: typ}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("RecordType %#02x accepted", typ)
+		}
+	}
+}
+
+func TestFillGhostRecordVersion(t *testing.T) {
+	for _, vers := range []uint16{VersionTLS12, VersionTLS13} {
+		server, _ := fillGhostStdVersionPair(t, vers)
+		if got := server.FillGhostRecordVersion(); got != [2]byte{3, 3} {
+			t.Errorf("%x: FillGhostRecordVersion() = % x, want 03 03", vers, got)
+		}
+	}
+	if got := Server(nil, &Config{}).FillGhostRecordVersion(); got != [2]byte{3, 1} {
+		t.Errorf("before handshake: FillGhostRecordVersion() = % x, want 03 01", got)
+	}
+
+	// TLS 1.2 握手后没有其他待读记录，默认版本与真实记录一致
+	sc, cc := fillGhostTCPPair(t)
+	server, _ := fillGhostHandshake(t, sc, cc, VersionTLS12)
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 10})
+	if err := fg.injectOne(); err != nil {
+		t.Fatal(err)
+	}
+	header := make([]byte, recordHeaderLen)
+	if _, err := io.ReadFull(cc, header); err != nil {
+		t.Fatal(err)
+	}
+	if header[1] != 3 || header[2] != 3 {
+		t.Errorf("TLS 1.2 record version % x, want 03 03", header[1:3])
+	}
+
+	fg = NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 10, RecordVersion: [2]byte{3, 1}})
+	if err := fg.injectOne(); err != nil {
+		t.Fatal(err)
+	}
+	// 跳过上一条记录的剩余部分
+	if _, err := io.ReadFull(cc, make([]byte, int(header[3])<<8|int(header[4]))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(cc, header); err != nil {
+		t.Fatal(err)
+	}
+	if header[1] != 3 || header[2] != 1 {
+		t.Errorf("spoofed record version % x, want 03 01", header[1:3])
+	}
+}
+
+func TestFillGhostInnerContentType(t *testing.T) {
+	sc, cc := fillGhostTCPPair(t)
+	server := Server(sc, &Config{Certificates: []Certificate{fillGhostTestCertificate(t)}})
+	client := Client(cc, &Config{InsecureSkipVerify: true})
+	errc := make(chan error, 1)
+	go func() { errc <- client.Handshake() }()
+	if err := server.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	// 直接从底层连接读出记录，用客户端的读方向解密，检查记录头与内层类型字节
+	next := func() (header []byte, inner recordType) {
+		t.Helper()
+		for {
+			record := make([]byte, recordHeaderLen)
+			if _, err := io.ReadFull(cc, record); err != nil {
+				t.Fatal(err)
+			}
+			body := make([]byte, int(record[3])<<8|int(record[4]))
+			if _, err := io.ReadFull(cc, body); err != nil {
+				t.Fatal(err)
+			}
+			record = append(record, body...)
+			_, typ, err := client.in.decrypt(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 跳过握手后服务端发出的 NewSessionTicket
+			if typ != recordTypeHandshake {
+				return record[:recordHeaderLen], typ
+			}
+		}
+	}
+	for _, tc := range []struct {
+		name    string
+		cfg     FillGhostConfig
+		inner   recordType
+		recVers [2]byte
+	}{
+		{"default", FillGhostConfig{}, recordTypeApplicationData, [2]byte{3, 3}},
+		{"InnerContentType", FillGhostConfig{InnerContentType: 0x42}, 0x42, [2]byte{3, 3}},
+		{"MarkInnerType", FillGhostConfig{MarkInnerType: true}, recordType(FillGhostContentType), [2]byte{3, 3}},
+		{"RecordVersion", FillGhostConfig{InnerContentType: 0xfe, RecordVersion: [2]byte{3, 1}}, 0xfe, [2]byte{3, 1}},
+	} {
+		cfg := tc.cfg
+		cfg.MinLen, cfg.MaxLen = 10, 10
+		if err := NewFillGhostController(server, cfg).injectOne(); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		header, inner := next()
+		if header[0] != byte(recordTypeApplicationData) || header[1] != tc.recVers[0] || header[2] != tc.recVers[1] {
+			t.Errorf("%s: header % x, want 17 % x", tc.name, header[:3], tc.recVers)
+		}
+		if inner != tc.inner {
+			t.Errorf("%s: inner content type %#02x, want %#02x", tc.name, inner, tc.inner)
+		}
+	}
+
+	server12, _ := fillGhostStdVersionPair(t, VersionTLS12)
+	fg := NewFillGhostController(server12, FillGhostConfig{MinLen: 10, MaxLen: 10, InnerContentType: 0x42})
+	if err := fg.injectOne(); err != ErrFillGhostUnsupportedVersion {
+		t.Errorf("InnerContentType on TLS 1.2 = %v, want ErrFillGhostUnsupportedVersion", err)
+	}
+}
+
+// fillGhostWriteLogConn 在 record 置位后记录每次写操作的长度，握手后每条记录恰好对应一次写
+type fillGhostWriteLogConn struct {
+	net.Conn
+	mu     sync.Mutex
+	record bool
+	writes []int
+}
+
+func (c *fillGhostWriteLogConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	if c.record {
+		c.writes = append(c.writes, len(b))
+	}
+	c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+func TestFillGhostPaddingPolicy(t *testing.T) {
+	for _, vers := range []uint16{VersionTLS13, VersionTLS12} {
+		sc, cc := fillGhostTCPPair(t)
+		logged := &fillGhostWriteLogConn{Conn: sc}
+		var policyCalls []int
+		server := Server(logged, &Config{
+			Certificates:                []Certificate{fillGhostTestCertificate(t)},
+			MinVersion:                  vers,
+			MaxVersion:                  vers,
+			CipherSuites:                []uint16{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			SessionTicketsDisabled:      true,
+			DynamicRecordSizingDisabled: true,
+			PaddingPolicy: func(n int) int {
+				policyCalls = append(policyCalls, n)
+				switch {
+				case n == 7:
+					return -5
+				case n > 10000:
+					return 1 << 20
+				}
+				return (512 - n%512) % 512
+			},
+		})
+		client := stdtls.Client(cc, &stdtls.Config{InsecureSkipVerify: true, MinVersion: vers, MaxVersion: vers})
+		errc := make(chan error, 1)
+		go func() { errc <- client.Handshake() }()
+		if err := server.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+		logged.mu.Lock()
+		logged.record = true
+		logged.mu.Unlock()
+
+		sizes := []int{1, 100, 512, 7, maxPlaintext + 500}
+		var sent []byte
+		for i, n := range sizes {
+			msg := bytes.Repeat([]byte{byte('a' + i)}, n)
+			sent = append(sent, msg...)
+			if _, err := server.Write(msg); err != nil {
+				t.Fatal(err)
+			}
+			// 填充记录不影响注入：注入记录不经过 PaddingPolicy
+			if i == 0 {
+				if err := NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 10}).injectOne(); err != nil {
+					t.Fatal(err)
+				}
+				sent = append(sent, make([]byte, 10)...)
+			}
+		}
+		got := make([]byte, len(sent))
+		if _, err := io.ReadFull(client, got); err != nil {
+			t.Fatalf("%x: peer read: %v", vers, err)
+		}
+		// 注入记录的负载是随机字节，只比较真实数据
+		if !bytes.Equal(got[:1], sent[:1]) || !bytes.Equal(got[11:], sent[11:]) {
+			t.Errorf("%x: peer read different data than was written", vers)
+		}
+
+		overhead := recordHeaderLen + 1 + 16
+		want := []int{512 + overhead, 10 + overhead, 512 + overhead, 512 + overhead, 7 + overhead, maxPlaintext + overhead, 512 + overhead}
+		if vers == VersionTLS12 {
+			overhead = recordHeaderLen + 8 + 16
+			want = []int{1 + overhead, 10 + overhead, 100 + overhead, 512 + overhead, 7 + overhead, maxPlaintext + overhead, 500 + overhead}
+			if policyCalls != nil {
+				t.Errorf("PaddingPolicy called on TLS 1.2 with %v", policyCalls)
+			}
+		} else if !reflect.DeepEqual(policyCalls, []int{1, 100, 512, 7, maxPlaintext, 500}) {
+			t.Errorf("PaddingPolicy called with %v", policyCalls)
+		}
+		logged.mu.Lock()
+		if !reflect.DeepEqual(logged.writes, want) {
+			t.Errorf("%x: record sizes on the wire %v, want %v", vers, logged.writes, want)
+		}
+		logged.mu.Unlock()
+	}
+}
+
+func TestFillGhostBucketPaddingPolicy(t *testing.T) {
+	policy, err := NewBucketPaddingPolicy(DefaultPaddingBuckets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, cc := fillGhostTCPPair(t)
+	logged := &fillGhostWriteLogConn{Conn: sc}
+	server := Server(logged, &Config{
+		Certificates:                []Certificate{fillGhostTestCertificate(t)},
+		SessionTicketsDisabled:      true,
+		DynamicRecordSizingDisabled: true,
+		PaddingPolicy:               policy,
+	})
+	client := stdtls.Client(cc, &stdtls.Config{InsecureSkipVerify: true})
+	errc := make(chan error, 1)
+	go func() { errc <- client.Handshake() }()
+	if err := server.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	logged.mu.Lock()
+	logged.record = true
+	logged.mu.Unlock()
+
+	for _, n := range []int{1, 255, 256, 257, 700, 1025, 4096, 9000, maxPlaintext, 3*maxPlaintext + 3000} {
+		msg := make([]byte, n)
+		rand.Read(msg)
+		go server.Write(msg)
+		got := make([]byte, n)
+		if _, err := io.ReadFull(client, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Fatalf("peer read different data for a %d-byte write", n)
+		}
+	}
+
+	// TLS 1.3 下每条记录的开销固定为记录头、内层类型与 16 字节标签
+	const overhead = recordHeaderLen + 1 + 16
+	onBucket := make(map[int]bool)
+	for _, b := range DefaultPaddingBuckets {
+		onBucket[b+overhead] = true
+	}
+	logged.mu.Lock()
+	defer logged.mu.Unlock()
+	if len(logged.writes) < 10 {
+		t.Fatalf("only %d records written", len(logged.writes))
+	}
+	for _, n := range logged.writes {
+		if !onBucket[n] {
+			t.Errorf("record of %d bytes on the wire is not a bucket size plus %d", n, overhead)
+		}
+	}
+
+	for _, buckets := range [][]int{nil, {0, 512}, {512, 256}, {256, 256}, {256, maxPlaintext + 1}} {
+		if _, err := NewBucketPaddingPolicy(buckets); err == nil {
+			t.Errorf("NewBucketPaddingPolicy(%v) succeeded", buckets)
+		}
+	}
+	small, err := NewBucketPaddingPolicy([]int{100, 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, want := range map[int]int{0: 100, 1: 99, 100: 0, 101: 99, 200: 0, 201: 0} {
+		if got := small(n); got != want {
+			t.Errorf("padding for %d bytes = %d, want %d", n, got, want)
+		}
+	}
+}
+
+func TestFillGhostFakeClock(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	packets := func(fg *FillGhostController) uint64 { return fg.Stats().PacketsInjected }
+
+	// InitialDelay 与 Interval
+	clk := NewFakeFillGhostClock(time.Now())
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 10, InitialDelay: time.Hour, Interval: time.Minute, Clock: clk})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	clk.BlockUntil(1)
+	if n := packets(fg); n != 0 {
+		t.Fatalf("%d records injected during InitialDelay", n)
+	}
+	for want := uint64(1); want <= 3; want++ {
+		if want == 1 {
+			clk.Advance(time.Hour)
+		} else {
+			clk.Advance(time.Minute)
+		}
+		clk.BlockUntil(1)
+		if n := packets(fg); n != want {
+			t.Fatalf("after %d intervals: %d records injected", want, n)
+		}
+	}
+	fg.Stop()
+
+	// Duration 按 Clock 计时，另有一个定时器等待 Duration 到期
+	clk = NewFakeFillGhostClock(time.Now())
+	fg = NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 10, Interval: time.Minute, Duration: 10 * time.Minute, Clock: clk})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 9; i++ {
+		clk.BlockUntil(2)
+		clk.Advance(time.Minute)
+	}
+	clk.BlockUntil(2)
+	clk.Advance(time.Minute)
+	if err := fg.Wait(); err != ErrFillGhostDurationElapsed {
+		t.Errorf("Wait() = %v, want ErrFillGhostDurationElapsed", err)
+	}
+	if n := packets(fg); n < 10 || n > 11 {
+		t.Errorf("%d records injected in 10 intervals, want 10 or 11", n)
+	}
+
+	// MaxDuration 与 Duration 计时相同，两者都设置时较短者先到期
+	for _, tc := range []struct {
+		name string
+		cfg  FillGhostConfig
+	}{
+		{"MaxDuration", FillGhostConfig{MaxDuration: 3 * time.Minute}},
+		{"shorter MaxDuration", FillGhostConfig{Duration: time.Hour, MaxDuration: 3 * time.Minute}},
+		{"shorter Duration", FillGhostConfig{Duration: 3 * time.Minute, MaxDuration: time.Hour}},
+	} {
+		clk = NewFakeFillGhostClock(time.Now())
+		cfg := tc.cfg
+		cfg.MinLen, cfg.MaxLen, cfg.Interval, cfg.Clock = 10, 10, time.Minute, clk
+		fg = NewFillGhostController(server, cfg)
+		if err := fg.Start(); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			clk.BlockUntil(2)
+			clk.Advance(time.Minute)
+		}
+		select {
+		case <-fg.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: run did not end after 3 minutes", tc.name)
+		}
+		if err := fg.Err(); err != ErrFillGhostDurationElapsed {
+			t.Errorf("%s: Err() = %v, want ErrFillGhostDurationElapsed", tc.name, err)
+		}
+	}
+
+	// Schedule
+	clk = NewFakeFillGhostClock(time.Now())
+	fg = NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 10, Schedule: []time.Duration{time.Second, 5 * time.Second, time.Hour}, Clock: clk})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for i, step := range []time.Duration{time.Second, 4 * time.Second} {
+		clk.BlockUntil(1)
+		clk.Advance(step)
+		clk.BlockUntil(1)
+		if n := packets(fg); n != uint64(i+1) {
+			t.Fatalf("schedule step %d: %d records injected", i, n)
+		}
+	}
+	clk.Advance(time.Hour)
+	if err := fg.Wait(); err != ErrFillGhostScheduleDone {
+		t.Errorf("Wait() = %v, want ErrFillGhostScheduleDone", err)
+	}
+	if n := packets(fg); n != 3 {
+		t.Errorf("schedule injected %d records, want 3", n)
+	}
+
+	// IdleThreshold：启动后 Conn 以同一时钟记录真实写入，起点与系统时间无关
+	clk = NewFakeFillGhostClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	fg = NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 10, Interval: time.Second, IdleThreshold: time.Minute, Clock: clk})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fg.Stop()
+	clk.BlockUntil(1)
+	if n := packets(fg); n != 1 {
+		t.Fatalf("%d records injected on an idle connection, want 1", n)
+	}
+	if _, err := server.Write([]byte("real")); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.FillGhostLastWriteTime(); !got.Equal(clk.Now()) {
+		t.Errorf("FillGhostLastWriteTime() = %v, want the fake clock's %v", got, clk.Now())
+	}
+	clk.Advance(time.Second)
+	clk.BlockUntil(1)
+	if n := packets(fg); n != 1 {
+		t.Fatalf("%d records injected one second after a real write, want still 1", n)
+	}
+	clk.Advance(time.Minute - time.Second)
+	clk.BlockUntil(1)
+	if n := packets(fg); n != 2 {
+		t.Errorf("%d records injected after the idle threshold, want 2", n)
+	}
+}
+
+func TestFillGhostZeroInterval(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	for _, tc := range []struct {
+		name    string
+		cfg     FillGhostConfig
+		perWait uint64
+	}{
+		{"Interval", FillGhostConfig{MinLen: 10, MaxLen: 10}, 1},
+		{"Jitter", FillGhostConfig{MinLen: 10, MaxLen: 10, Jitter: time.Nanosecond}, 1},
+		{"Burst", FillGhostConfig{MinLen: 10, MaxLen: 10, Burst: FillGhostBurst{BurstSize: 3}}, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// 时钟不前进时循环必须停在 FillGhostMinInterval 的等待上，而不是持续注入
+			clk := NewFakeFillGhostClock(time.Now())
+			cfg := tc.cfg
+			cfg.Clock = clk
+			fg := NewFillGhostController(server, cfg)
+			if err := fg.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer fg.Stop()
+			for want := tc.perWait; want <= 3*tc.perWait; want += tc.perWait {
+				clk.BlockUntil(1)
+				time.Sleep(10 * time.Millisecond)
+				if n := fg.Stats().PacketsInjected; n != want {
+					t.Fatalf("%d records injected without the clock advancing, want %d", n, want)
+				}
+				if at, ok := fg.NextInjectAt(); !ok || at.Sub(clk.Now()) != FillGhostMinInterval {
+					t.Fatalf("NextInjectAt() = %v, %v; want %v from now", at, ok, FillGhostMinInterval)
+				}
+				clk.Advance(FillGhostMinInterval)
+			}
+		})
+	}
+}
+
+func TestFillGhostActiveWindows(t *testing.T) {
+	at := func(h, m int) time.Time { return time.Date(2026, 3, 2, h, m, 0, 0, time.UTC) }
+	cfg := FillGhostConfig{
+		ActiveWindows:  []FillGhostWindow{{9 * time.Hour, 17 * time.Hour}, {22 * time.Hour, 6 * time.Hour}},
+		WindowLocation: time.UTC,
+	}
+	for _, tt := range []struct {
+		now  time.Time
+		want time.Duration
+	}{
+		{at(9, 0), 0},
+		{at(16, 59), 0},
+		{at(17, 0), 5 * time.Hour},
+		{at(23, 0), 0},
+		{at(0, 30), 0},
+		{at(6, 0), 3 * time.Hour},
+		{at(8, 45), 15 * time.Minute},
+		{at(8, 45).In(time.FixedZone("UTC+8", 8*3600)), 15 * time.Minute},
+	} {
+		if got := cfg.untilWindow(tt.now); got != tt.want {
+			t.Errorf("untilWindow(%v) = %v, want %v", tt.now, got, tt.want)
+		}
+	}
+
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+	clk := NewFakeFillGhostClock(at(8, 0))
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen: 10, MaxLen: 10, Interval: time.Second, Clock: clk,
+		ActiveWindows:  []FillGhostWindow{{8*time.Hour + 30*time.Minute, 9 * time.Hour}},
+		WindowLocation: time.UTC,
+	})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// 时段之外按至多一分钟分段空闲，直到 8:30 才开始注入
+	for i := 0; i < 30; i++ {
+		clk.BlockUntil(1)
+		if next, ok := fg.NextInjectAt(); !ok || next.Sub(clk.Now()) != fillGhostWindowPoll {
+			t.Fatalf("idle wait until %v, want %v from now", next, fillGhostWindowPoll)
+		}
+		if n := fg.Stats().PacketsInjected; n != 0 {
+			t.Fatalf("%d records injected at %v, outside the window", n, clk.Now())
+		}
+		clk.Advance(fillGhostWindowPoll)
+	}
+	for i := 0; i < 3; i++ {
+		clk.BlockUntil(1)
+		clk.Advance(time.Second)
+	}
+	clk.BlockUntil(1)
+	if n := fg.Stats().PacketsInjected; n < 3 {
+		t.Errorf("%d records injected inside the window, want at least 3", n)
+	}
+
+	// UpdateConfig 移走时段后回到空闲，空闲时 Stop 立即返回
+	if err := fg.UpdateConfig(FillGhostConfig{
+		MinLen: 10, MaxLen: 10, Interval: time.Second, Clock: clk,
+		ActiveWindows:  []FillGhostWindow{{10 * time.Hour, 11 * time.Hour}},
+		WindowLocation: time.UTC,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Second)
+	clk.BlockUntil(1)
+	before := fg.Stats().PacketsInjected
+	if next, ok := fg.NextInjectAt(); !ok || next.Sub(clk.Now()) != fillGhostWindowPoll {
+		t.Errorf("after UpdateConfig: waiting until %v, want an idle wait of %v", next, fillGhostWindowPoll)
+	}
+	if err := fg.StopTimeout(time.Second); err != nil {
+		t.Errorf("Stop while idle: %v", err)
+	}
+	if n := fg.Stats().PacketsInjected; n != before {
+		t.Errorf("%d records injected after the window moved away, want %d", n, before)
+	}
+}
+
+func TestFillGhostWarmup(t *testing.T) {
+	cfg := FillGhostConfig{Warmup: 10 * time.Second, WarmupInterval: 5 * time.Second}
+	for _, tt := range []struct{ elapsed, want time.Duration }{
+		{0, 5 * time.Second},
+		{2500 * time.Millisecond, 4 * time.Second},
+		{5 * time.Second, 3 * time.Second},
+		{9 * time.Second, 1400 * time.Millisecond},
+		{10 * time.Second, time.Second},
+		{time.Hour, time.Second},
+	} {
+		if got := cfg.warmupInterval(time.Second, tt.elapsed); got != tt.want {
+			t.Errorf("interval after %v of warmup = %v, want %v", tt.elapsed, got, tt.want)
+		}
+	}
+	dflt := FillGhostConfig{Warmup: time.Second}
+	if got := dflt.warmupInterval(100*time.Millisecond, 0); got != 100*time.Millisecond*fillGhostWarmupFactor {
+		t.Errorf("default warmup start = %v, want %d times the interval", got, fillGhostWarmupFactor)
+	}
+	if got := (&FillGhostConfig{}).warmupInterval(time.Second, 0); got != time.Second {
+		t.Errorf("zero Warmup changed the interval to %v", got)
+	}
+
+	// 循环中的实际等待：每条记录之后按注入时距 Start 的时间取预热间隔
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+	clk := NewFakeFillGhostClock(time.Now())
+	start := clk.Now()
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen: 10, MaxLen: 10, Interval: time.Second, Clock: clk,
+		Warmup: 10 * time.Second, WarmupInterval: 5 * time.Second,
+	})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fg.Stop()
+	for _, want := range []time.Duration{5 * time.Second, 3 * time.Second, 1800 * time.Millisecond, 1080 * time.Millisecond, time.Second, time.Second} {
+		clk.BlockUntil(1)
+		next, ok := fg.NextInjectAt()
+		if !ok {
+			t.Fatal("loop is not waiting")
+		}
+		if got := next.Sub(clk.Now()); got != want {
+			t.Errorf("wait after %v = %v, want %v", clk.Now().Sub(start), got, want)
+		}
+		clk.Advance(next.Sub(clk.Now()))
+	}
+}
+
+func TestFillGhostInitialDelayJitter(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+	firstWait := func(cfg FillGhostConfig) time.Duration {
+		t.Helper()
+		clk := NewFakeFillGhostClock(time.Now())
+		cfg.Clock = clk
+		fg := NewFillGhostController(server, cfg)
+		if err := fg.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer fg.Stop()
+		clk.BlockUntil(1)
+		at, ok := fg.NextInjectAt()
+		if !ok {
+			t.Fatal("loop is not waiting for the first injection")
+		}
+		if n := fg.Stats().PacketsInjected; n != 0 {
+			t.Fatalf("%d records injected before the initial delay", n)
+		}
+		return at.Sub(clk.Now())
+	}
+
+	cfg := FillGhostConfig{MaxLen: 10, Interval: time.Hour, InitialDelay: time.Second, InitialDelayJitter: time.Second}
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 20; i++ {
+		d := firstWait(cfg)
+		if d < time.Second || d > 2*time.Second {
+			t.Fatalf("first wait %v outside [1s, 2s]", d)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 10 {
+		t.Errorf("only %d distinct first waits in 20 starts", len(seen))
+	}
+	cfg.InitialDelayJitter = 0
+	if d := firstWait(cfg); d != time.Second {
+		t.Errorf("first wait with zero jitter = %v, want exactly InitialDelay", d)
+	}
+
+	// 随机的初始等待期间取消，循环立即退出
+	ctx, cancel := context.WithCancel(context.Background())
+	fg := NewFillGhostController(server, FillGhostConfig{MaxLen: 10, InitialDelayJitter: time.Hour})
+	if err := fg.StartContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case <-fg.Done():
+	case <-time.After(time.Second):
+		t.Fatal("loop did not exit after cancel during the initial wait")
+	}
+
+	failing := NewFillGhostController(server, FillGhostConfig{MaxLen: 10, InitialDelayJitter: time.Second, Rand: &fillGhostFlakyReader{fail: 1}})
+	if err := failing.Start(); err != nil {
+		t.Fatal(err)
+	}
+	var fgErr *FillGhostError
+	if err := failing.Wait(); !errors.As(err, &fgErr) || fgErr.Op != "rand" {
+		t.Errorf("failing Rand: Wait() = %v, want a rand FillGhostError", err)
+	}
+}
+
+func TestFillGhostStartBeforeHandshake(t *testing.T) {
+	newPair := func(t *testing.T) (server, client *Conn) {
+		sc, cc := fillGhostTCPPair(t)
+		server = Server(sc, &Config{Certificates: []Certificate{fillGhostTestCertificate(t)}})
+		client = Client(cc, &Config{InsecureSkipVerify: true})
+		t.Cleanup(func() { server.Close(); client.Close() })
+		return server, client
+	}
+	cfg := FillGhostConfig{MinLen: 10, MaxLen: 10, Interval: time.Millisecond, InitialDelay: 50 * time.Millisecond}
+
+	t.Run("before", func(t *testing.T) {
+		server, client := newPair(t)
+		fg := NewFillGhostController(server, cfg)
+		if err := fg.Start(); err != ErrFillGhostHandshakeNotComplete {
+			t.Fatalf("Start = %v before the handshake, want ErrFillGhostHandshakeNotComplete", err)
+		}
+		if err := fg.Restart(); err != ErrFillGhostHandshakeNotComplete {
+			t.Fatalf("Restart = %v before the handshake, want ErrFillGhostHandshakeNotComplete", err)
+		}
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			client.Handshake()
+			go io.Copy(io.Discard, client)
+		}()
+		// 服务端握手由 StartAfterHandshake 驱动
+		if err := fg.StartAfterHandshake(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		handshakeDone := time.Now()
+		defer fg.Stop()
+		for fg.Stats().PacketsInjected == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		if d := fg.Stats().LastInjectAt.Sub(handshakeDone); d < cfg.InitialDelay-5*time.Millisecond {
+			t.Errorf("first injection %v after the handshake, want at least InitialDelay %v", d, cfg.InitialDelay)
+		}
+	})
+
+	t.Run("during", func(t *testing.T) {
+		server, client := newPair(t)
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			client.Handshake()
+			go io.Copy(io.Discard, client)
+		}()
+		errc := make(chan error, 1)
+		go func() { errc <- server.Handshake() }()
+		fg := NewFillGhostController(server, cfg)
+		if err := fg.StartAfterHandshake(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer fg.Stop()
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+		if !server.ConnectionState().HandshakeComplete {
+			t.Error("StartAfterHandshake returned before the handshake completed")
+		}
+	})
+
+	t.Run("after", func(t *testing.T) {
+		server, client := newPair(t)
+		errc := make(chan error, 1)
+		go func() { errc <- client.Handshake() }()
+		if err := server.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+		go io.Copy(io.Discard, client)
+		fg := NewFillGhostController(server, cfg)
+		if err := fg.Start(); err != nil {
+			t.Fatal(err)
+		}
+		fg.Stop()
+		if err := fg.StartAfterHandshake(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		fg.Stop()
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		server, _ := newPair(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		fg := NewFillGhostController(server, cfg)
+		if err := fg.StartAfterHandshake(ctx); err == nil {
+			fg.Stop()
+			t.Fatal("StartAfterHandshake succeeded without a peer")
+		}
+	})
+}
+
+func TestFillGhostRestart(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 10, Interval: time.Millisecond})
+	// 未运行时等同于 Start
+	if err := fg.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				var err error
+				if j%2 == 0 {
+					err = fg.Restart()
+				} else {
+					err = fg.RestartWithConfig(FillGhostConfig{MinLen: 20 + i, MaxLen: 20 + i, Interval: time.Millisecond})
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := fg.Start(); err == nil {
+		t.Fatal("controller not running after concurrent restarts")
+	}
+	done := fg.Done()
+	if err := fg.RestartWithConfig(FillGhostConfig{MinLen: 10, MaxLen: 1}); err == nil {
+		t.Error("RestartWithConfig accepted an invalid config")
+	}
+	select {
+	case <-done:
+		t.Error("invalid RestartWithConfig stopped the running loop")
+	default:
+	}
+	if err := fg.RestartWithConfig(FillGhostConfig{MinLen: 33, MaxLen: 33, Interval: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	before := fg.Stats()
+	for deadline := time.Now().Add(5 * time.Second); fg.Stats().PacketsInjected < before.PacketsInjected+3; {
+		if time.Now().After(deadline) {
+			t.Fatal("no injections after RestartWithConfig")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	fg.Stop()
+	if got := fg.Stats().BytesInjectedPlaintext - before.BytesInjectedPlaintext; got%33 != 0 {
+		t.Errorf("injected %d bytes since RestartWithConfig, want a multiple of 33", got)
+	}
+	// 停止后同样可以重启
+	if err := fg.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	fg.Stop()
+}
+
+func TestFillGhostNextInjectAt(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	start := time.Now()
+	clk := NewFakeFillGhostClock(start)
+	var fg *FillGhostController
+	during := make(chan bool, 10)
+	fg = NewFillGhostController(server, FillGhostConfig{
+		MinLen: 10, MaxLen: 10,
+		InitialDelay: time.Hour,
+		Interval:     time.Minute,
+		Clock:        clk,
+		OnBeforeInject: func(n int) (int, bool) {
+			_, ok := fg.NextInjectAt()
+			during <- ok
+			return n, true
+		},
+	})
+	if _, ok := fg.NextInjectAt(); ok {
+		t.Error("NextInjectAt reported a wake-up before Start")
+	}
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	clk.BlockUntil(1)
+	if at, ok := fg.NextInjectAt(); !ok || !at.Equal(start.Add(time.Hour)) {
+		t.Errorf("NextInjectAt() = %v, %v during InitialDelay, want %v, true", at, ok, start.Add(time.Hour))
+	}
+	clk.Advance(time.Hour)
+	if <-during {
+		t.Error("NextInjectAt reported sleeping while injecting")
+	}
+	clk.BlockUntil(1)
+	if at, ok := fg.NextInjectAt(); !ok || !at.Equal(start.Add(time.Hour+time.Minute)) {
+		t.Errorf("NextInjectAt() = %v, %v after the first record, want %v, true", at, ok, start.Add(time.Hour+time.Minute))
+	}
+	fg.Stop()
+	if _, ok := fg.NextInjectAt(); ok {
+		t.Error("NextInjectAt reported a wake-up after Stop")
+	}
+}
+
+func TestFakeFillGhostClockTimers(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clk := NewFakeFillGhostClock(start)
+	a := clk.NewTimer(2 * time.Second)
+	b := clk.NewTimer(time.Second)
+	if !b.Stop() || b.Stop() {
+		t.Error("Stop should report true once for a pending timer")
+	}
+	clk.Advance(time.Second)
+	select {
+	case <-a.C():
+		t.Fatal("timer fired early")
+	case <-b.C():
+		t.Fatal("stopped timer fired")
+	default:
+	}
+	if !a.Reset(3 * time.Second) {
+		t.Error("Reset of a pending timer should report true")
+	}
+	clk.Advance(2 * time.Second)
+	select {
+	case <-a.C():
+		t.Fatal("reset timer fired at its old deadline")
+	default:
+	}
+	clk.Advance(time.Second)
+	select {
+	case now := <-a.C():
+		if want := start.Add(4 * time.Second); !now.Equal(want) {
+			t.Errorf("timer fired at %v, want %v", now, want)
+		}
+	default:
+		t.Fatal("timer did not fire")
+	}
+	if got := clk.Now(); !got.Equal(start.Add(4 * time.Second)) {
+		t.Errorf("Now() = %v", got)
+	}
+	select {
+	case <-clk.NewTimer(0).C():
+	default:
+		t.Error("zero-duration timer did not fire immediately")
+	}
+}
+
+func TestFillGhostStripping(t *testing.T) {
+	magic := []byte("\x8f\x1cfillghost\x00\x7e\x93\x02")
+	for _, vers := range []uint16{VersionTLS12, VersionTLS13} {
+		for _, strip := range []bool{true, false} {
+			server, client := fillGhostForkPair(t, vers, false)
+			if strip {
+				client.EnableFillGhostStripping(magic)
+			}
+			fg := NewFillGhostController(server, FillGhostConfig{MinLen: 0, MaxLen: 300, Magic: magic})
+			done := make(chan []byte, 1)
+			go func() {
+				client.SetReadDeadline(time.Now().Add(10 * time.Second))
+				b, _ := io.ReadAll(client)
+				done <- b
+			}()
+			for _, msg := range []string{"hello", ", ", "world"} {
+				if _, err := fg.InjectNow(5); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := server.Write([]byte(msg)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			server.Close()
+			got := <-done
+			rs := client.FillGhostRecvStats()
+			if strip {
+				if string(got) != "hello, world" {
+					t.Errorf("%x: stripping peer read %q, want %q", vers, got, "hello, world")
+				}
+				if st := fg.Stats(); rs.Records != st.PacketsInjected || rs.Bytes != st.BytesInjectedPlaintext {
+					t.Errorf("%x: FillGhostRecvStats() = %+v, sender injected %d records of %d bytes", vers, rs, st.PacketsInjected, st.BytesInjectedPlaintext)
+				}
+				continue
+			}
+			if rs != (FillGhostRecvStats{}) {
+				t.Errorf("%x: FillGhostRecvStats() = %+v without stripping", vers, rs)
+			}
+			if n := bytes.Count(got, magic); n != 15 {
+				t.Errorf("%x: peer without stripping saw %d ghost records, want 15", vers, n)
+			}
+		}
+	}
+
+	cfg := FillGhostConfig{MaxLen: 10, Magic: make([]byte, maxPlaintext+1)}
+	if err := cfg.Validate(); err == nil {
+		t.Error("oversized Magic accepted")
+	}
+}
+
+func TestFillGhostTaggedMarker(t *testing.T) {
+	for _, vers := range []uint16{VersionTLS12, VersionTLS13} {
+		for _, match := range []bool{true, false} {
+			server, client := fillGhostForkPair(t, vers, false)
+			if err := client.EnableFillGhostTagStripping(); err != nil {
+				t.Fatal(err)
+			}
+			if !match {
+				// 另一条连接导出的密钥，模拟不知道会话密钥的一方
+				other, _ := fillGhostForkPair(t, vers, false)
+				key, err := other.fillGhostTagKey()
+				if err != nil {
+					t.Fatal(err)
+				}
+				client.in.Lock()
+				client.fillGhostStripKey = key
+				client.in.Unlock()
+			}
+			fg := NewFillGhostController(server, FillGhostConfig{MinLen: 0, MaxLen: 300, TaggedMarker: true})
+			done := make(chan []byte, 1)
+			go func() {
+				client.SetReadDeadline(time.Now().Add(10 * time.Second))
+				b, _ := io.ReadAll(client)
+				done <- b
+			}()
+			for _, msg := range []string{"hello", ", ", "world"} {
+				if _, err := fg.InjectNow(5); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := server.Write([]byte(msg)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			server.Close()
+			got := <-done
+			st := fg.Stats()
+			if st.Errors != 0 || st.PacketsInjected != 15 {
+				t.Fatalf("%x: Stats() = %+v, want 15 records without errors", vers, st)
+			}
+			rs := client.FillGhostRecvStats()
+			if match {
+				if string(got) != "hello, world" {
+					t.Errorf("%x: peer with the exporter key read %q, want %q", vers, got, "hello, world")
+				}
+				if rs.Records != st.PacketsInjected || rs.Bytes != st.BytesInjectedPlaintext {
+					t.Errorf("%x: FillGhostRecvStats() = %+v, sender injected %d records of %d bytes", vers, rs, st.PacketsInjected, st.BytesInjectedPlaintext)
+				}
+				continue
+			}
+			if rs != (FillGhostRecvStats{}) {
+				t.Errorf("%x: FillGhostRecvStats() = %+v with a mismatched key", vers, rs)
+			}
+			if want := uint64(len("hello, world")) + st.BytesInjectedPlaintext; uint64(len(got)) != want {
+				t.Errorf("%x: peer with a mismatched key read %d bytes, want %d", vers, len(got), want)
+			}
+		}
+	}
+
+	// 应用数据恰好以其他序号的有效标签开头时照常交付
+	server, client := fillGhostForkPair(t, VersionTLS13, false)
+	if err := client.EnableFillGhostTagStripping(); err != nil {
+		t.Fatal(err)
+	}
+	key, err := server.fillGhostTagKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := make([]byte, FillGhostTagLen+4)
+	fillGhostTag(msg, key, [8]byte{7: 42})
+	go func() {
+		server.Write(msg)
+		server.Close()
+	}()
+	client.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if got, _ := io.ReadAll(client); !bytes.Equal(got, msg) {
+		t.Errorf("read %x, want %x", got, msg)
+	}
+
+	cfg := FillGhostConfig{MaxLen: 10, TaggedMarker: true, Magic: []byte("x")}
+	if err := cfg.Validate(); err == nil {
+		t.Error("TaggedMarker with Magic accepted")
+	}
+}
+
+func TestFillGhostMirror(t *testing.T) {
+	server, client := fillGhostForkPair(t, VersionTLS13, false)
+	go io.Copy(io.Discard, client)
+	var mu sync.Mutex
+	var lengths []int
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen: 7, MaxLen: 7,
+		Mirror: true,
+		OnInject: func(m InjectMeta) {
+			mu.Lock()
+			lengths = append(lengths, m.PlaintextLen)
+			mu.Unlock()
+		},
+	})
+	last := func() int {
+		t.Helper()
+		if _, err := fg.InjectNow(1); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return lengths[len(lengths)-1]
+	}
+
+	if n, ok := server.FillGhostLastWriteSize(); ok {
+		t.Fatalf("FillGhostLastWriteSize() = %d before any Write", n)
+	}
+	if got := last(); got != 7 {
+		t.Errorf("ghost length before any Write = %d, want the [MinLen, MaxLen] fallback", got)
+	}
+	for _, tt := range []struct{ write, want int }{
+		{321, 321},
+		{1, 1},
+		{1000, 1000},
+	} {
+		if _, err := server.Write(make([]byte, tt.write)); err != nil {
+			t.Fatal(err)
+		}
+		if n, ok := server.FillGhostLastWriteSize(); !ok || n != tt.want {
+			t.Errorf("after a %d-byte Write: FillGhostLastWriteSize() = %d, %v; want %d", tt.write, n, ok, tt.want)
+		}
+		if got := last(); got != tt.want {
+			t.Errorf("after a %d-byte Write: ghost length %d, want %d", tt.write, got, tt.want)
+		}
+	}
+	// 填充记录本身不改变被模仿的长度
+	if got := last(); got != 1000 {
+		t.Errorf("second ghost length %d, want 1000", got)
+	}
+
+	// 大块写入按记录拆分，取最后一条记录的长度
+	if _, err := server.Write(make([]byte, 3*maxPlaintext+1)); err != nil {
+		t.Fatal(err)
+	}
+	n, _ := server.FillGhostLastWriteSize()
+	if n <= 0 || n > maxPlaintext {
+		t.Errorf("after a multi-record Write: FillGhostLastWriteSize() = %d, want the length of its last record", n)
+	}
+	if got := last(); got != n {
+		t.Errorf("after a multi-record Write: ghost length %d, want %d", got, n)
+	}
+}
+
+func TestFillGhostLearn(t *testing.T) {
+	server, client := fillGhostForkPair(t, VersionTLS13, false)
+	go io.Copy(io.Discard, client)
+	var mu sync.Mutex
+	lengths := make(map[int]int)
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen: 7, MaxLen: 7,
+		Learn: true,
+		OnInject: func(m InjectMeta) {
+			mu.Lock()
+			lengths[m.PlaintextLen]++
+			mu.Unlock()
+		},
+	})
+	inject := func(n int) map[int]int {
+		t.Helper()
+		mu.Lock()
+		lengths = make(map[int]int)
+		mu.Unlock()
+		if _, err := fg.InjectNow(n); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return lengths
+	}
+
+	if got := server.FillGhostWriteSizeSamples(); len(got) != 0 {
+		t.Fatalf("FillGhostWriteSizeSamples() = %v before any Write", got)
+	}
+	for i := 0; i < fillGhostLearnMinSamples-1; i++ {
+		if _, err := server.Write(make([]byte, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := inject(10); got[7] != 10 {
+		t.Errorf("lengths with too few samples = %v, want the [MinLen, MaxLen] fallback", got)
+	}
+	server.Write(make([]byte, 100))
+	for i := 0; i < fillGhostLearnMinSamples; i++ {
+		server.Write(make([]byte, 2000))
+	}
+	want := []LengthWeight{{100, fillGhostLearnMinSamples}, {2000, fillGhostLearnMinSamples}}
+	if got := server.FillGhostWriteSizeSamples(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("FillGhostWriteSizeSamples() = %v, want %v", got, want)
+	}
+	if got := inject(200); len(got) != 2 || got[100] == 0 || got[2000] == 0 {
+		t.Errorf("learned lengths = %v, want a mix of 100 and 2000", got)
+	}
+
+	// 旧样本在窗口填满后被覆盖
+	for i := 0; i < fillGhostWriteSizeWindow; i++ {
+		server.Write(make([]byte, 5))
+	}
+	want = []LengthWeight{{5, fillGhostWriteSizeWindow}}
+	if got := server.FillGhostWriteSizeSamples(); !reflect.DeepEqual(got, want) {
+		t.Errorf("FillGhostWriteSizeSamples() = %v, want %v", got, want)
+	}
+	if got := inject(20); got[5] != 20 {
+		t.Errorf("lengths after decay = %v, want all 5", got)
+	}
+	if got := server.FillGhostWriteSizeSamples(); !reflect.DeepEqual(got, want) {
+		t.Errorf("FillGhostWriteSizeSamples() = %v after injecting, want %v; ghost records must not be sampled", got, want)
+	}
+}
+
+func TestFillGhostNegotiation(t *testing.T) {
+	handshake := func(t *testing.T, serverCfg, clientCfg *Config) (server, client *Conn) {
+		t.Helper()
+		sc, cc := fillGhostTCPPair(t)
+		serverCfg.Certificates = []Certificate{fillGhostTestCertificate(t)}
+		clientCfg.InsecureSkipVerify = true
+		server, client = Server(sc, serverCfg), Client(cc, clientCfg)
+		errc := make(chan error, 1)
+		go func() { errc <- client.Handshake() }()
+		if err := server.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+		return server, client
+	}
+	for _, vers := range []uint16{VersionTLS12, VersionTLS13} {
+		for _, tc := range []struct {
+			name           string
+			server, client bool
+			curves         []CurveID // 服务端只接受 P-256 时 TLS 1.3 客户端需经 HelloRetryRequest 重发 ClientHello
+		}{
+			{"both", true, true, nil},
+			{"both/HRR", true, true, []CurveID{CurveP256}},
+			{"client only", false, true, nil},
+			{"server only", true, false, nil},
+			{"neither", false, false, nil},
+		} {
+			t.Run(fmt.Sprintf("%x/%s", vers, tc.name), func(t *testing.T) {
+				server, client := handshake(t,
+					&Config{MinVersion: vers, MaxVersion: vers, NegotiateFillGhost: tc.server, CurvePreferences: tc.curves},
+					&Config{MinVersion: vers, MaxVersion: vers, NegotiateFillGhost: tc.client})
+				defer client.Close()
+				want := tc.server && tc.client
+				if got := server.FillGhostNegotiated(); got != want {
+					t.Errorf("server FillGhostNegotiated() = %v, want %v", got, want)
+				}
+				if got := client.FillGhostNegotiated(); got != want {
+					t.Errorf("client FillGhostNegotiated() = %v, want %v", got, want)
+				}
+				go io.Copy(io.Discard, client)
+				for _, c := range []*Conn{server, client} {
+					fg := NewFillGhostController(c, FillGhostConfig{MaxLen: 100, Interval: time.Millisecond, RequireNegotiation: true})
+					err := fg.Start()
+					if want {
+						if err != nil {
+							t.Fatalf("Start = %v after negotiation", err)
+						}
+						fg.Stop()
+					} else if err != ErrFillGhostNotNegotiated {
+						if err == nil {
+							fg.Stop()
+						}
+						t.Errorf("Start = %v, want ErrFillGhostNotNegotiated", err)
+					}
+				}
+			})
+		}
+	}
+
+	// 标准库的对端忽略未知扩展，握手照常完成但不会协商
+	t.Run("stock peers", func(t *testing.T) {
+		sc, cc := fillGhostTCPPair(t)
+		server := Server(sc, &Config{Certificates: []Certificate{fillGhostTestCertificate(t)}, NegotiateFillGhost: true})
+		stdClient := stdtls.Client(cc, &stdtls.Config{InsecureSkipVerify: true})
+		go stdClient.Handshake()
+		if err := server.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		stdClient.Close()
+		if server.FillGhostNegotiated() {
+			t.Error("negotiated with a crypto/tls client")
+		}
+
+		sc, cc = fillGhostTCPPair(t)
+		cert := fillGhostTestCertificate(t)
+		stdServer := stdtls.Server(sc, &stdtls.Config{Certificates: []stdtls.Certificate{{Certificate: cert.Certificate, PrivateKey: cert.PrivateKey}}})
+		go stdServer.Handshake()
+		client := Client(cc, &Config{InsecureSkipVerify: true, NegotiateFillGhost: true})
+		if err := client.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		stdServer.Close()
+		if client.FillGhostNegotiated() {
+			t.Error("negotiated with a crypto/tls server")
+		}
+	})
+
+	if cfg := (&Config{NegotiateFillGhost: true}).Clone(); !cfg.NegotiateFillGhost {
+		t.Error("Clone dropped NegotiateFillGhost")
+	}
+}
+
+func TestFillGhostConfigAutoController(t *testing.T) {
+	sc, cc := fillGhostTCPPair(t)
+	server := Server(sc, &Config{
+		Certificates: []Certificate{fillGhostTestCertificate(t)},
+		MinVersion:   VersionTLS13,
+		FillGhost:    &FillGhostConfig{MinLen: 1, MaxLen: 200, Interval: time.Millisecond, MarkInnerType: true},
+	})
+	client := Client(cc, &Config{InsecureSkipVerify: true, AcceptFillGhost: true})
+	if server.FillGhostController != nil {
+		t.Fatal("controller created before the handshake")
+	}
+	done := make(chan []byte, 1)
+	go func() {
+		client.SetReadDeadline(time.Now().Add(10 * time.Second))
+		b, _ := io.ReadAll(client)
+		done <- b
+	}()
+	if err := server.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	fg := server.FillGhostController
+	if fg == nil || !server.FillGhostEnabled {
+		t.Fatal("no controller after the handshake")
+	}
+	for deadline := time.Now().Add(5 * time.Second); fg.Stats().PacketsInjected < 5; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Stats() = %+v, want ghosts flowing", fg.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := server.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-fg.Done():
+	default:
+		t.Error("injection loop still running after Close returned")
+	}
+	if got := <-done; string(got) != "hello" {
+		t.Errorf("client read %q, want %q", got, "hello")
+	}
+	if rs := client.FillGhostRecvStats(); rs.Records == 0 {
+		t.Error("client dropped no ghost records")
+	}
+
+	// 配置不合法时握手照常完成，错误记在控制器上
+	sc, cc = fillGhostTCPPair(t)
+	server = Server(sc, &Config{
+		Certificates: []Certificate{fillGhostTestCertificate(t)},
+		FillGhost:    &FillGhostConfig{MinLen: 10, MaxLen: 1},
+	})
+	client = Client(cc, &Config{InsecureSkipVerify: true})
+	go client.Handshake()
+	if err := server.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if fg := server.FillGhostController; fg == nil || fg.Err() == nil {
+		t.Error("invalid Config.FillGhost did not surface through Err()")
+	}
+	server.Close()
+	client.Close()
+
+	// 对端握手后不再读取，自动控制器的注入阻塞在写出中：Close 中断它并及时返回
+	sc, cc = fillGhostTCPPair(t)
+	server = Server(sc, &Config{Certificates: []Certificate{fillGhostTestCertificate(t)}, FillGhost: &FillGhostConfig{MinLen: 16000, MaxLen: 16000, Interval: time.Microsecond}})
+	stalled := stdtls.Client(cc, &stdtls.Config{InsecureSkipVerify: true})
+	go stalled.Handshake()
+	if err := server.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	fg = server.FillGhostController
+	if fg == nil {
+		t.Fatal("no controller after the handshake")
+	}
+	fillGhostWaitStalled(t, fg)
+	closed := make(chan struct{})
+	go func() {
+		server.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close hung behind the auto controller's blocked injection")
+	}
+	select {
+	case <-fg.Done():
+	default:
+		t.Error("Close returned before the auto controller exited")
+	}
+	stalled.Close()
+
+	// 握手前关闭的连接不会再创建控制器
+	sc, cc = fillGhostTCPPair(t)
+	server = Server(sc, &Config{Certificates: []Certificate{fillGhostTestCertificate(t)}, FillGhost: &FillGhostConfig{MaxLen: 10}})
+	server.Close()
+	cc.Close()
+	server.Handshake()
+	if server.FillGhostController != nil {
+		t.Error("controller created on a closed connection")
+	}
+}
+
+func TestFillGhostListener(t *testing.T) {
+	before := runtime.NumGoroutine()
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{Certificates: []Certificate{fillGhostTestCertificate(t)}}
+	ln := NewFillGhostListener(inner, config, FillGhostConfig{MinLen: 100, MaxLen: 100, Interval: time.Millisecond})
+	if config.FillGhost != nil {
+		t.Error("NewFillGhostListener modified the caller's Config")
+	}
+
+	const n = 4
+	accepted := make(chan *Conn, n)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			// 握手由第一次 Read 触发，之后控制器已在运行
+			go func() {
+				c.Read(make([]byte, 1))
+				accepted <- c.(*Conn)
+			}()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	clients := make([]*stdtls.Conn, n)
+	for i := range clients {
+		c, err := stdtls.Dial("tcp", inner.Addr().String(), &stdtls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients[i] = c
+		// 客户端先写一个字节，服务端 Read 返回即握手完成
+		if _, err := c.Write([]byte{'x'}); err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if _, err := io.ReadFull(c, make([]byte, 5*100)); err != nil {
+				t.Errorf("client received no ghost records: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		c := <-accepted
+		fg := c.FillGhostController
+		if fg == nil {
+			t.Fatal("accepted Conn has no FillGhostController")
+		}
+		if st := fg.Stats(); st.PacketsInjected < 5 {
+			t.Errorf("conn %d: %d records injected, want at least 5", i, st.PacketsInjected)
+		}
+		c.Close()
+		select {
+		case <-fg.Done():
+		default:
+			t.Errorf("conn %d: controller still running after Close", i)
+		}
+	}
+	for _, c := range clients {
+		c.Close()
+	}
+	ln.Close()
+	if _, ok := <-accepted; ok {
+		t.Error("Accept returned a connection after the listener closed")
+	}
+
+	// 控制器、Accept 与读取协程都应退出
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if g := runtime.NumGoroutine(); g > before {
+		t.Errorf("%d goroutines after closing everything, %d before", g, before)
+	}
+}
+
+func TestFillGhostRoleProfiles(t *testing.T) {
+	// 两端共用同一个 Config，各自按所在的一端选取配置
+	config := &Config{
+		Certificates:       []Certificate{fillGhostTestCertificate(t)},
+		InsecureSkipVerify: true,
+		AcceptFillGhost:    true,
+		FillGhost:          &FillGhostConfig{MinLen: 5000, MaxLen: 5000},
+		FillGhostClient:    &FillGhostConfig{MinLen: 20, MaxLen: 40, Interval: time.Millisecond, MarkInnerType: true},
+		FillGhostServer:    &FillGhostConfig{MinLen: 1000, MaxLen: 1200, Interval: time.Millisecond, MarkInnerType: true},
+	}
+	sc, cc := fillGhostTCPPair(t)
+	server, client := Server(sc, config), Client(cc, config)
+	defer server.Close()
+	defer client.Close()
+	go io.Copy(io.Discard, server)
+	go io.Copy(io.Discard, client)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for server.FillGhostRecvStats().Records < 10 || client.FillGhostRecvStats().Records < 10 {
+		if time.Now().After(deadline) {
+			t.Fatalf("ghosts received: server %+v, client %+v", server.FillGhostRecvStats(), client.FillGhostRecvStats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	for _, tt := range []struct {
+		name     string
+		recv     *Conn
+		min, max uint64
+	}{
+		{"client to server", server, 20, 40},
+		{"server to client", client, 1000, 1200},
+	} {
+		st := tt.recv.FillGhostRecvStats()
+		if avg := st.Bytes / st.Records; avg < tt.min || avg > tt.max {
+			t.Errorf("%s: average ghost record of %d bytes, want within [%d, %d]", tt.name, avg, tt.min, tt.max)
+		}
+	}
+	if cfg := client.FillGhostController.config(); cfg.MaxLen != 40 {
+		t.Errorf("client controller MaxLen = %d, want FillGhostClient's 40", cfg.MaxLen)
+	}
+
+	// 未设置本端配置时回退到 FillGhost
+	fallback := &Config{FillGhost: config.FillGhost, FillGhostServer: config.FillGhostServer}
+	if got := (&Conn{config: fallback, isClient: true}).fillGhostAutoConfig(); got != config.FillGhost {
+		t.Errorf("client without FillGhostClient uses %+v, want FillGhost", got)
+	}
+	if got := (&Conn{config: fallback}).fillGhostAutoConfig(); got != config.FillGhostServer {
+		t.Errorf("server uses %+v, want FillGhostServer", got)
+	}
+	if got := config.Clone(); got.FillGhostClient != config.FillGhostClient || got.FillGhostServer != config.FillGhostServer {
+		t.Error("Clone dropped FillGhostClient or FillGhostServer")
+	}
+}
+
+func TestFillGhostSealGhostRecord(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		vers   uint16
+		suites []uint16
+	}{
+		{"TLS13", VersionTLS13, nil},
+		{"TLS12-GCM", VersionTLS12, []uint16{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}},
+		{"TLS12-CBC", VersionTLS12, []uint16{TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, client := fillGhostStdVersionPair(t, tc.vers, tc.suites...)
+			sizes := []int{0, 1, 300, maxPlaintext}
+			var records [][]byte
+			for _, n := range sizes {
+				rec, err := server.SealGhostRecord(n)
+				if err != nil {
+					t.Fatalf("SealGhostRecord(%d): %v", n, err)
+				}
+				records = append(records, rec)
+			}
+			// 由调用方自己的传输层按序送出，之后的真实数据仍能解密
+			go func() {
+				for _, rec := range records {
+					server.conn.Write(rec)
+				}
+				server.Write([]byte("real"))
+			}()
+			total := 0
+			for _, n := range sizes {
+				total += n
+			}
+			client.SetReadDeadline(time.Now().Add(5 * time.Second))
+			got := make([]byte, total+4)
+			if _, err := io.ReadFull(client, got); err != nil {
+				t.Fatalf("peer could not read the sealed records: %v", err)
+			}
+			if tail := string(got[total:]); tail != "real" {
+				t.Errorf("real data after the ghosts = %q, want %q", tail, "real")
+			}
+		})
+	}
+
+	server, _ := fillGhostStdPair(t)
+	for _, n := range []int{-1, maxPlaintext + 1} {
+		if _, err := server.SealGhostRecord(n); err == nil {
+			t.Errorf("SealGhostRecord(%d) succeeded", n)
+		}
+	}
+	server.Close()
+	if _, err := server.SealGhostRecord(10); !errors.Is(err, ErrFillGhostClosed) {
+		t.Errorf("SealGhostRecord after Close = %v, want ErrFillGhostClosed", err)
+	}
+	sc, _ := fillGhostTCPPair(t)
+	fresh := Server(sc, &Config{Certificates: []Certificate{fillGhostTestCertificate(t)}})
+	if _, err := fresh.SealGhostRecord(10); err != ErrFillGhostNoAEAD {
+		t.Errorf("SealGhostRecord before the handshake = %v, want ErrFillGhostNoAEAD", err)
+	}
+}
+
+func TestFillGhostSink(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	var mu sync.Mutex
+	var records [][]byte
+	var metas []InjectMeta
+	sink := func(record []byte, meta InjectMeta) error {
+		mu.Lock()
+		defer mu.Unlock()
+		records = append(records, append([]byte(nil), record...))
+		metas = append(metas, meta)
+		return nil
+	}
+
+	// 完整的注入循环按假时钟运行，记录只交给 Sink
+	clk := NewFakeFillGhostClock(time.Now())
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen: 100, MaxLen: 100, Interval: time.Second, Clock: clk, MaxPackets: 5,
+		Sink: sink,
+	})
+	seq := server.ExportWriteSeq()
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		clk.BlockUntil(1)
+		clk.Advance(time.Second)
+	}
+	if err := fg.Wait(); err != ErrFillGhostPacketLimit {
+		t.Fatalf("Wait() = %v, want ErrFillGhostPacketLimit", err)
+	}
+	mu.Lock()
+	if len(records) != 5 {
+		t.Fatalf("sink received %d records, want 5", len(records))
+	}
+	for i, m := range metas {
+		if m.PlaintextLen != 100 || m.WireLen != len(records[i]) || m.Seq != metas[0].Seq {
+			t.Errorf("record %d: meta %+v, want 100-byte payloads sharing one sequence number", i, m)
+		}
+	}
+	mu.Unlock()
+	if st := fg.Stats(); st.PacketsInjected != 5 || st.BytesOnWire != uint64(5*len(records[0])) {
+		t.Errorf("stats = %+v, want 5 records counted", st)
+	}
+	if got := server.ExportWriteSeq(); got != seq {
+		t.Errorf("write sequence moved from %x to %x without ConsumeSeq", seq, got)
+	}
+	// 线上没有任何填充记录，对端只收到真实数据
+	go server.Write([]byte("real"))
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(client, got); err != nil || string(got) != "real" {
+		t.Fatalf("peer read %q, %v; want only the real data", got, err)
+	}
+
+	// ConsumeSeq：序号递增，调用方按序送出的记录能被对端解密
+	mu.Lock()
+	records, metas = nil, nil
+	mu.Unlock()
+	consume := NewFillGhostController(server, FillGhostConfig{MinLen: 50, MaxLen: 50, Sink: sink, ConsumeSeq: true})
+	if _, err := consume.InjectNow(3); err != nil {
+		t.Fatal(err)
+	}
+	if metas[2].Seq != metas[0].Seq+2 {
+		t.Errorf("sequence numbers %d..%d, want three consecutive", metas[0].Seq, metas[2].Seq)
+	}
+	go func() {
+		for _, rec := range records {
+			server.conn.Write(rec)
+		}
+		server.Write([]byte("tail"))
+	}()
+	got = make([]byte, 3*50+4)
+	if _, err := io.ReadFull(client, got); err != nil {
+		t.Fatalf("peer could not decrypt the sink records: %v", err)
+	}
+	if string(got[150:]) != "tail" {
+		t.Errorf("real data after the sink records = %q", got[150:])
+	}
+
+	failing := NewFillGhostController(server, FillGhostConfig{MaxLen: 10, Sink: func([]byte, InjectMeta) error { return io.ErrShortWrite }})
+	var fgErr *FillGhostError
+	if _, err := failing.InjectNow(1); !errors.As(err, &fgErr) || fgErr.Op != "sink" || !errors.Is(err, io.ErrShortWrite) {
+		t.Errorf("failing Sink: InjectNow = %v, want a sink FillGhostError", err)
+	}
+	if st := failing.Stats(); st.Errors != 1 || st.BytesOnWire != 0 {
+		t.Errorf("failing Sink stats = %+v, want one error and nothing counted", st)
+	}
+
+	// 循环中由 Sink 调用 Pause 不会死锁
+	var pausing *FillGhostController
+	pausing = NewFillGhostController(server, FillGhostConfig{MaxLen: 10, Interval: time.Millisecond, Sink: func([]byte, InjectMeta) error {
+		pausing.Pause()
+		return nil
+	}})
+	if err := pausing.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); !pausing.isPaused(); {
+		if time.Now().After(deadline) {
+			t.Fatal("Pause from Sink never took effect")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := pausing.StopTimeout(5 * time.Second); err != nil {
+		t.Fatalf("Stop after Pause from Sink: %v", err)
+	}
+	if st := pausing.Stats(); st.PacketsInjected != 1 {
+		t.Errorf("PacketsInjected after Pause from Sink = %d, want 1", st.PacketsInjected)
+	}
+}
+
+func TestFillGhostDialerHTTPS(t *testing.T) {
+	var mu sync.Mutex
+	var serverConns []*Conn
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			serverConns = append(serverConns, c.(*Conn))
+			mu.Unlock()
+		}
+	}
+	// 服务端使用本库，以 AcceptFillGhost 丢弃客户端注入的标记记录，HTTP 流不受影响
+	srv.Listener = NewListener(srv.Listener, &Config{
+		Certificates:    []Certificate{fillGhostTestCertificate(t)},
+		AcceptFillGhost: true,
+	})
+	srv.Start()
+	defer srv.Close()
+
+	config := &Config{InsecureSkipVerify: true}
+	d := &FillGhostDialer{
+		Config: config,
+		Ghost:  FillGhostConfig{MinLen: 50, MaxLen: 50, Interval: time.Millisecond, MarkInnerType: true},
+	}
+	var clientConn *Conn
+	tr := &http.Transport{DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := d.DialContext(ctx, network, addr)
+		if err == nil {
+			clientConn = c.(*Conn)
+		}
+		return c, err
+	}}
+	defer tr.CloseIdleConnections()
+	client := &http.Client{Transport: tr, Timeout: 5 * time.Second}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get("https://" + srv.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || string(body) != "hello" {
+			t.Fatalf("round trip %d: body %q, err %v", i, body, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if config.FillGhost != nil {
+		t.Error("FillGhostDialer modified the caller's Config")
+	}
+	fg := clientConn.FillGhostController
+	if fg == nil {
+		t.Fatal("dialed Conn has no FillGhostController")
+	}
+	if n := fg.Stats().PacketsInjected; n == 0 {
+		t.Error("no ghost records injected")
+	}
+	mu.Lock()
+	if len(serverConns) != 1 {
+		t.Errorf("server saw %d connections, want 1 reused connection", len(serverConns))
+	} else if got := serverConns[0].FillGhostRecvStats().Records; got == 0 {
+		t.Error("server dropped no ghost records")
+	}
+	mu.Unlock()
+	tr.CloseIdleConnections()
+	select {
+	case <-fg.Done():
+	case <-time.After(5 * time.Second):
+		t.Error("controller still running after the transport closed the connection")
+	}
+}
+
+func TestFillGhostDialerErrors(t *testing.T) {
+	before := runtime.NumGoroutine()
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inner.Close()
+	d := &FillGhostDialer{
+		Config: &Config{InsecureSkipVerify: true},
+		Ghost:  FillGhostConfig{MaxLen: 10, Interval: time.Millisecond},
+	}
+
+	// 对端接受连接但不应答，握手期间取消上下文
+	var held []net.Conn
+	var heldMu sync.Mutex
+	go func() {
+		for {
+			c, err := inner.Accept()
+			if err != nil {
+				return
+			}
+			heldMu.Lock()
+			held = append(held, c)
+			heldMu.Unlock()
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if c, err := d.DialContext(ctx, "tcp", inner.Addr().String()); !errors.Is(err, context.DeadlineExceeded) || c != nil {
+		t.Errorf("DialContext = %v, %v, want a nil Conn and DeadlineExceeded", c, err)
+	}
+
+	bad := &FillGhostDialer{Ghost: FillGhostConfig{MinLen: 10, MaxLen: 1}}
+	if _, err := bad.Dial("tcp", inner.Addr().String()); err == nil {
+		t.Error("Dial accepted an invalid Ghost config")
+	}
+
+	// 握手成功但控制器启动失败：对端未协商 FillGhost
+	tlsInner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := NewListener(tlsInner, &Config{Certificates: []Certificate{fillGhostTestCertificate(t)}})
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				c.(*Conn).Handshake()
+				io.Copy(io.Discard, c)
+				c.Close()
+			}()
+		}
+	}()
+	strict := &FillGhostDialer{
+		Config: &Config{InsecureSkipVerify: true, NegotiateFillGhost: true},
+		Ghost:  FillGhostConfig{MaxLen: 10, RequireNegotiation: true},
+	}
+	if _, err := strict.Dial("tcp", tlsInner.Addr().String()); !errors.Is(err, ErrFillGhostNotNegotiated) {
+		t.Errorf("Dial to a peer without FillGhost = %v, want ErrFillGhostNotNegotiated", err)
+	}
+
+	// 控制器启动后很快因 MaxPackets 自行停止，不影响拨号结果
+	quick := &FillGhostDialer{
+		Config: &Config{InsecureSkipVerify: true},
+		Ghost:  FillGhostConfig{MinLen: 10, MaxLen: 10, MaxPackets: 1, Interval: time.Millisecond},
+	}
+	for i := 0; i < 200; i++ {
+		c, err := quick.Dial("tcp", tlsInner.Addr().String())
+		if err != nil {
+			t.Fatalf("dial %d with MaxPackets 1: %v", i, err)
+		}
+		fg := c.(*Conn).FillGhostController
+		<-fg.Done()
+		if err := fg.Err(); !errors.Is(err, ErrFillGhostPacketLimit) {
+			t.Errorf("dial %d: Err() = %v, want ErrFillGhostPacketLimit", i, err)
+		}
+		c.Close()
+	}
+	ln.Close()
+
+	heldMu.Lock()
+	for _, c := range held {
+		c.Close()
+	}
+	heldMu.Unlock()
+	inner.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if g := runtime.NumGoroutine(); g > before {
+		t.Errorf("%d goroutines after the failed dials, %d before", g, before)
+	}
+}
+
+// fillGhostRequestKeyUpdate 让 c 发送 update_requested 的 KeyUpdate 并轮换写密钥，
+// 对端读到后轮换读密钥，并回复 KeyUpdate、轮换自己的写密钥
+func fillGhostRequestKeyUpdate(c *Conn) error {
+	c.out.Lock()
+	defer c.out.Unlock()
+	if _, err := c.writeRecordLocked(recordTypeHandshake, (&keyUpdateMsg{updateRequested: true}).marshal()); err != nil {
+		return err
+	}
+	suite := cipherSuiteTLS13ByID(c.cipherSuite)
+	c.out.setTrafficSecret(suite, suite.nextTrafficSecret(c.out.trafficSecret))
+	return nil
+}
+
+func TestFillGhostKeyUpdate(t *testing.T) {
+	server, client := fillGhostForkPair(t, VersionTLS13, true)
+	go io.Copy(io.Discard, server) // 服务端在 Read 中处理 KeyUpdate 并轮换写密钥
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen:        0,
+		MaxLen:        2000,
+		Interval:      10 * time.Microsecond,
+		BurstMin:      1,
+		BurstMax:      4,
+		MarkInnerType: true,
+	})
+	type result struct {
+		b   []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		client.SetReadDeadline(time.Now().Add(30 * time.Second))
+		b, err := io.ReadAll(client)
+		done <- result{b, err}
+	}()
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	const updates = 200
+	for i := 0; i < updates; i++ {
+		// 每次轮换之间至少注入一条，保证轮换发生在注入进行中
+		for n := fg.Stats().PacketsInjected; fg.Stats().PacketsInjected == n; {
+			time.Sleep(10 * time.Microsecond)
+		}
+		if err := fillGhostRequestKeyUpdate(client); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.Write([]byte{'x'}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fg.Stop()
+	// 只发送 close_notify，避免服务端还有未读的 KeyUpdate 时关闭套接字触发 RST
+	if err := server.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	r := <-done
+	server.Close()
+	if r.err != nil {
+		t.Fatalf("peer read failed during key updates: %v", r.err)
+	}
+	if string(r.b) != strings.Repeat("x", updates) {
+		t.Errorf("peer read %d bytes of real data, want %d", len(r.b), updates)
+	}
+	st := fg.Stats()
+	if st.Errors != 0 || st.PacketsInjected == 0 {
+		t.Errorf("Stats() = %+v, want injections without errors", st)
+	}
+	if rs := client.FillGhostRecvStats(); rs.Records != st.PacketsInjected {
+		t.Errorf("peer dropped %d ghost records, sender injected %d", rs.Records, st.PacketsInjected)
+	}
+}
+
+func TestFillGhostAcceptInnerType(t *testing.T) {
+	server, client := fillGhostForkPair(t, VersionTLS13, true)
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen:   1,
+		MaxLen:   maxPlaintext,
+		Interval: 10 * time.Microsecond,
+		BurstMin: 1, BurstMax: 8,
+		MarkInnerType: true,
+	})
+
+	file := make([]byte, 4<<20)
+	if _, err := rand.Read(file); err != nil {
+		t.Fatal(err)
+	}
+	want := sha256.Sum256(file)
+	type result struct {
+		sum [32]byte
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		client.SetReadDeadline(time.Now().Add(30 * time.Second))
+		h := sha256.New()
+		n, err := io.Copy(h, client)
+		var r result
+		copy(r.sum[:], h.Sum(nil))
+		r.n, r.err = int(n), err
+		done <- r
+	}()
+
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for off := 0; off < len(file); off += 32 << 10 {
+		if _, err := server.Write(file[off : off+32<<10]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 负载较重时写入可能在循环第一次注入之前就已完成
+	for deadline := time.Now().Add(5 * time.Second); fg.Stats().PacketsInjected == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	fg.Stop()
+	server.Close()
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("peer Read: %v", r.err)
+	}
+	if r.n != len(file) || r.sum != want {
+		t.Errorf("peer received %d bytes with a different hash, want the %d-byte file", r.n, len(file))
+	}
+	st, rs := fg.Stats(), client.FillGhostRecvStats()
+	if st.PacketsInjected == 0 {
+		t.Fatal("no ghost records were injected")
+	}
+	if rs.Records != st.PacketsInjected || rs.Bytes != st.BytesInjectedPlaintext {
+		t.Errorf("FillGhostRecvStats() = %+v, sender injected %d records of %d bytes", rs, st.PacketsInjected, st.BytesInjectedPlaintext)
+	}
+
+	// 未开启 AcceptFillGhost 的对端按标准拒绝未知的内层类型
+	server, client = fillGhostForkPair(t, VersionTLS13, false)
+	fg = NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 10, MarkInnerType: true})
+	if err := fg.injectOne(); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.Read(make([]byte, 10)); err == nil {
+		t.Error("peer without AcceptFillGhost accepted a marked record")
+	}
+
+	server12, _ := fillGhostStdVersionPair(t, VersionTLS12)
+	fg = NewFillGhostController(server12, FillGhostConfig{MinLen: 10, MaxLen: 10, MarkInnerType: true})
+	if err := fg.injectOne(); err != ErrFillGhostUnsupportedVersion {
+		t.Errorf("TLS 1.2 MarkInnerType: injectOne = %v, want ErrFillGhostUnsupportedVersion", err)
+	}
+}
+
+func TestFillGhostFragment(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	const total = 2*maxPlaintext + 7232
+	var metas []InjectMeta
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen:   total,
+		MaxLen:   total,
+		Fragment: true,
+		OnInject: func(m InjectMeta) { metas = append(metas, m) },
+	})
+	if n, err := fg.InjectNow(1); err != nil || n != 1 {
+		t.Fatalf("InjectNow(1) = %d, %v", n, err)
+	}
+	if len(metas) != 3 {
+		t.Fatalf("injected %d records, want 3", len(metas))
+	}
+	for i, want := range []int{maxPlaintext, maxPlaintext, 7232} {
+		if m := metas[i]; m.PlaintextLen != want || m.WireLen != recordHeaderLen+want+1+16 {
+			t.Errorf("record %d: %d bytes (%d on wire), want %d", i, m.PlaintextLen, m.WireLen, want)
+		}
+		if i > 0 && metas[i].Seq != metas[i-1].Seq+1 {
+			t.Errorf("record %d: Seq = %d, want %d", i, metas[i].Seq, metas[i-1].Seq+1)
+		}
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(client, make([]byte, total)); err != nil {
+		t.Fatalf("peer read of the fragmented payload: %v", err)
+	}
+	if st := fg.Stats(); st.PacketsInjected != 3 || st.BytesInjectedPlaintext != total {
+		t.Errorf("Stats = %d records, %d bytes; want 3, %d", st.PacketsInjected, st.BytesInjectedPlaintext, total)
+	}
+
+	for _, tt := range []struct {
+		cfg FillGhostConfig
+		ok  bool
+	}{
+		{FillGhostConfig{MaxLen: total}, false},
+		{FillGhostConfig{MaxLen: total, Fragment: true}, true},
+		{FillGhostConfig{MaxLen: 64*maxPlaintext + 1, Fragment: true}, false},
+		{FillGhostConfig{LengthWeights: []LengthWeight{{total, 1}}}, false},
+		{FillGhostConfig{LengthWeights: []LengthWeight{{total, 1}}, Fragment: true}, true},
+		{FillGhostConfig{MaxLen: total, Fragment: true, FragmentJitter: maxPlaintext / 2}, true},
+		{FillGhostConfig{MaxLen: total, Fragment: true, FragmentJitter: maxPlaintext/2 + 1}, false},
+		{FillGhostConfig{MaxLen: total, Fragment: true, FragmentJitter: -1}, false},
+		{FillGhostConfig{MaxLen: 100, FragmentJitter: 10}, false},
+	} {
+		if err := tt.cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("Validate(MaxLen %d, weights %v, Fragment %v) = %v, want ok = %v", tt.cfg.MaxLen, tt.cfg.LengthWeights, tt.cfg.Fragment, err, tt.ok)
+		}
+	}
+}
+
+func TestFillGhostFragmentJitter(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	const total = 50 << 10
+	var metas []InjectMeta
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen:         total,
+		MaxLen:         total,
+		Fragment:       true,
+		FragmentJitter: 2000,
+		OnInject:       func(m InjectMeta) { metas = append(metas, m) },
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 4; i++ {
+			if _, err := fg.InjectNow(1); err != nil {
+				t.Errorf("InjectNow: %v", err)
+				return
+			}
+		}
+	}()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(client, make([]byte, 4*total)); err != nil {
+		t.Fatalf("peer read of the fragmented payloads: %v", err)
+	}
+	<-done
+
+	sum, boundaries := 0, make(map[int]bool)
+	for i, m := range metas {
+		sum += m.PlaintextLen
+		if sum%total == 0 {
+			continue
+		}
+		// 非末尾记录只会比满长短，且不超过 FragmentJitter
+		if m.PlaintextLen > maxPlaintext || m.PlaintextLen < maxPlaintext-2000 {
+			t.Errorf("record %d: %d bytes, want within [%d, %d]", i, m.PlaintextLen, maxPlaintext-2000, maxPlaintext)
+		}
+		boundaries[m.PlaintextLen] = true
+	}
+	if sum != 4*total || len(metas) < 4*4 {
+		t.Fatalf("%d records with %d bytes, want at least 16 records with %d bytes", len(metas), sum, 4*total)
+	}
+	if len(boundaries) < 2 {
+		t.Errorf("all fragments were %v bytes long, want randomized split points", boundaries)
+	}
+}
+
+// fillGhostWideAEAD 声称更大的 AEAD 开销，用于检查按实际开销计算的记录上限
+type fillGhostWideAEAD struct {
+	cipher.AEAD
+	extra int
+}
+
+func (a fillGhostWideAEAD) Overhead() int { return a.AEAD.Overhead() + a.extra }
+
+func TestFillGhostRecordLimit(t *testing.T) {
+	key := make([]byte, 16)
+	for _, a := range []cipher.AEAD{aeadAESGCMTLS13(key, make([]byte, 12)), aeadChaCha20Poly1305(make([]byte, 32), make([]byte, 12))} {
+		for _, vers := range []uint16{VersionTLS12, VersionTLS13} {
+			if got := fillGhostPayloadCeiling(vers, a); got != maxPlaintext {
+				t.Errorf("%x/%T: ceiling %d, want %d", vers, a, got, maxPlaintext)
+			}
+		}
+	}
+	wide := fillGhostWideAEAD{aeadAESGCMTLS13(key, make([]byte, 12)), 284}
+	if got, want := fillGhostPayloadCeiling(VersionTLS13, wide), maxCiphertextTLS13-1-300; got != want {
+		t.Errorf("TLS 1.3 ceiling with 300 bytes of overhead = %d, want %d", got, want)
+	}
+	if got := fillGhostPayloadCeiling(VersionTLS12, wide); got != maxPlaintext {
+		t.Errorf("TLS 1.2 ceiling with 300 bytes of overhead = %d, want %d", got, maxPlaintext)
+	}
+	if _, err := buildGhostRecord(nil, VersionTLS13, wide, [8]byte{}, make([]byte, maxPlaintext), 0x17, 0x17, [2]byte{3, 3}); err == nil {
+		t.Error("buildGhostRecord accepted a payload above the computed ceiling")
+	}
+
+	server, _ := fillGhostStdPair(t)
+	cfg := FillGhostConfig{MinLen: 1, MaxLen: maxPlaintext}
+	fg, err := NewFillGhostControllerChecked(server, cfg)
+	if err != nil {
+		t.Fatalf("MaxLen %d rejected on a real connection: %v", maxPlaintext, err)
+	}
+	server.out.Lock()
+	server.out.cipher = fillGhostWideAEAD{server.out.cipher.(cipher.AEAD), 284}
+	server.out.Unlock()
+	err = fg.UpdateConfig(cfg)
+	if err == nil || !strings.Contains(err.Error(), "MaxLen") || !strings.Contains(err.Error(), fmt.Sprint(maxCiphertextTLS13-1-300)) {
+		t.Errorf("UpdateConfig = %v, want an error naming MaxLen and the ceiling", err)
+	}
+	if err := fg.Start(); err == nil {
+		fg.Stop()
+		t.Error("Start accepted MaxLen above the ceiling")
+	}
+	cfg.MaxLen = 100
+	cfg.LengthWeights = []LengthWeight{{maxPlaintext, 1}}
+	if err := fg.UpdateConfig(cfg); err == nil || !strings.Contains(err.Error(), "LengthWeights") {
+		t.Errorf("UpdateConfig = %v, want an error naming LengthWeights", err)
+	}
+}
+
+// fillGhostSlowConn 写超过 1000 字节时先等待 delay 纳秒，模拟真实大块数据阻塞在拥塞的连接上
+type fillGhostSlowConn struct {
+	net.Conn
+	delay int64 // 原子访问
+}
+
+func (c *fillGhostSlowConn) Write(b []byte) (int, error) {
+	if len(b) > 1000 {
+		time.Sleep(time.Duration(atomic.LoadInt64(&c.delay)))
+	}
+	return c.Conn.Write(b)
+}
+
+func TestFillGhostSuppressWhenBusy(t *testing.T) {
+	sc, cc := fillGhostTCPPair(t)
+	slow := &fillGhostSlowConn{Conn: sc}
+	server, client := fillGhostStdHandshake(t, slow, cc)
+	go io.Copy(io.Discard, client)
+
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 10, Interval: time.Millisecond, SuppressWhenBusy: true})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fg.Stop()
+	for fg.Stats().PacketsInjected == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	atomic.StoreInt64(&slow.delay, int64(200*time.Millisecond))
+	written := make(chan error, 1)
+	go func() {
+		_, err := server.Write(make([]byte, 4000))
+		written <- err
+	}()
+	for !server.FillGhostWriteBusy() {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond) // 等待进行中的注入结束
+	before := fg.Stats()
+	time.Sleep(100 * time.Millisecond)
+	during := fg.Stats()
+	atomic.StoreInt64(&slow.delay, 0)
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	if during.PacketsInjected != before.PacketsInjected {
+		t.Errorf("%d ghosts injected while a real Write was blocked", during.PacketsInjected-before.PacketsInjected)
+	}
+	if during.TicksSkipped <= before.TicksSkipped {
+		t.Error("no ticks skipped while a real Write was blocked")
+	}
+	for fg.Stats().PacketsInjected == during.PacketsInjected {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFillGhostSelfStopRacesStop(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	for _, cfg := range []FillGhostConfig{
+		{MinLen: 1, MaxLen: 10, MaxPackets: 1},
+		{MinLen: 1, MaxLen: 10, Interval: time.Millisecond, Duration: time.Millisecond},
+	} {
+		fg := NewFillGhostController(server, cfg)
+		for i := 0; i < 200; i++ {
+			if err := fg.Start(); err != nil {
+				t.Fatal(err)
+			}
+			done := fg.Done()
+			var wg sync.WaitGroup
+			for j := 0; j < 3; j++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					fg.Stop()
+				}()
+			}
+			wg.Wait()
+			<-done
+		}
+	}
+}
+
+func TestFillGhostConcurrentStop(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	// 从未启动过时 Stop 立即返回
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 1, MaxLen: 10, Interval: time.Millisecond})
+	fg.Stop()
+	fg.Stop()
+
+	for round := 0; round < 20; round++ {
+		if err := fg.Start(); err != nil {
+			t.Fatal(err)
+		}
+		done := fg.Done()
+		var wg sync.WaitGroup
+		for i := 0; i < 32; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 5; j++ {
+					switch i % 4 {
+					case 0:
+						fg.StopTimeout(time.Second)
+					case 1:
+						fg.Stats()
+					default:
+						fg.Stop()
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		select {
+		case <-done:
+		default:
+			t.Fatal("loop still running after concurrent Stop calls returned")
+		}
+		if st := fg.Stats(); st.StopReason != nil {
+			t.Errorf("StopReason = %v after Stop, want nil", st.StopReason)
+		}
+		fg.Stop() // 已停止时是空操作
+	}
+}
+
+func TestFillGhostStartStopInterleaved(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+	before := runtime.NumGoroutine()
+
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 1, MaxLen: 10, Interval: time.Millisecond})
+	// 单个调用方快速交替：每次 Stop 都等到本轮退出，下一次 Start 不会报错
+	for i := 0; i < 200; i++ {
+		if err := fg.Start(); err != nil {
+			t.Fatalf("Start #%d: %v", i, err)
+		}
+		done := fg.Done()
+		fg.Stop()
+		select {
+		case <-done:
+		default:
+			t.Fatalf("round %d still running after Stop", i)
+		}
+	}
+
+	// 多个调用方交错 Start/Stop：Start 可能因已在运行而失败，但最后的 Stop 之后不能有残留的循环
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				fg.Start()
+				fg.Stop()
+			}
+		}()
+	}
+	wg.Wait()
+	fg.Stop()
+	select {
+	case <-fg.Done():
+	case <-time.After(time.Second):
+		t.Fatal("loop still running after the last Stop")
+	}
+	if fg.loopRunning() {
+		t.Error("loopRunning reports a live loop after Stop")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if g := runtime.NumGoroutine(); g > before {
+		t.Errorf("%d goroutines after alternating Start and Stop, %d before", g, before)
+	}
+}
+
+func TestFillGhostMaxOverheadRatio(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	const ratio = 0.15
+	const allowance = 500
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 50, MaxLen: 200, MaxOverheadRatio: ratio, OverheadAllowance: allowance})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fg.Stop()
+
+	// 尚无真实数据时只能用掉初始额度
+	time.Sleep(20 * time.Millisecond)
+	if st := fg.Stats(); st.PacketsInjected == 0 || st.BytesOnWire > allowance {
+		t.Fatalf("before real traffic: %d ghost bytes, want some but at most %d", st.BytesOnWire, allowance)
+	}
+
+	chunk := make([]byte, 10000)
+	for i := 0; i < 20; i++ {
+		if _, err := server.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+		realBytes := server.FillGhostRealBytesWritten()
+		if ghost := fg.Stats().BytesOnWire; float64(ghost) > allowance+ratio*float64(realBytes) {
+			t.Fatalf("%d ghost bytes for %d real bytes exceeds the %.2f cap", ghost, realBytes, ratio)
+		}
+	}
+	if realBytes := server.FillGhostRealBytesWritten(); realBytes != 20*int64(len(chunk)) {
+		t.Errorf("FillGhostRealBytesWritten() = %d, want %d", realBytes, 20*len(chunk))
+	}
+	st := fg.Stats()
+	if st.BytesOnWire < allowance || st.TicksSkipped == 0 {
+		t.Errorf("stats = %+v, want ghosts credited by real traffic and skipped ticks once the bucket ran dry", st)
+	}
+}
+
+func TestFillGhostManager(t *testing.T) {
+	const rate = 20000
+	if _, err := NewFillGhostManager(-1); err == nil {
+		t.Error("negative manager rate accepted")
+	}
+	begin := time.Now()
+	m, err := NewFillGhostManager(rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Attach(nil, FillGhostConfig{MaxLen: 10}); err == nil {
+		t.Error("Attach accepted a nil Conn")
+	}
+	const n = 4
+	var ctrls []*FillGhostController
+	var conns []*Conn
+	for i := 0; i < n; i++ {
+		server, client := fillGhostStdPair(t)
+		go io.Copy(io.Discard, client)
+		fg, err := m.Attach(server, FillGhostConfig{MinLen: 1000, MaxLen: 1000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fg.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer fg.Stop()
+		ctrls = append(ctrls, fg)
+		conns = append(conns, server)
+	}
+	if m.Len() != n {
+		t.Errorf("Len() = %d, want %d", m.Len(), n)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	st := m.Stats()
+	elapsed := time.Since(begin).Seconds()
+	// 满桶的一秒额度、经过时间的补充，再加上每个控制器至多透支一条记录
+	if limit := rate + rate*elapsed + n*1100; float64(st.BytesOnWire) > limit {
+		t.Errorf("%d ghost bytes in %.2fs across %d conns, want at most %.0f", st.BytesOnWire, elapsed, n, limit)
+	}
+	if st.BytesOnWire < rate || st.TicksSkipped == 0 {
+		t.Errorf("stats = %+v, want the shared bucket drained and ticks skipped", st)
+	}
+	var sum uint64
+	for _, fg := range ctrls {
+		sum += fg.Stats().PacketsInjected
+	}
+	if got := m.Stats().PacketsInjected; got < sum {
+		t.Errorf("aggregate PacketsInjected %d, want at least the per-controller sum %d", got, sum)
+	}
+
+	// 运行中解除上限，吞吐立即不再受限
+	if err := m.SetRate(0); err != nil {
+		t.Fatal(err)
+	}
+	if m.Rate() != 0 {
+		t.Errorf("Rate() = %d after SetRate(0)", m.Rate())
+	}
+	before := m.Stats().BytesOnWire
+	time.Sleep(100 * time.Millisecond)
+	if grown := m.Stats().BytesOnWire - before; grown < 2*rate {
+		t.Errorf("%d ghost bytes in 100ms without a cap, want more than %d", grown, 2*rate)
+	}
+	if err := m.SetRate(rate); err != nil {
+		t.Fatal(err)
+	}
+
+	// Detach 后统计保留在累计值中；连接关闭后控制器自动移出
+	total := m.Stats().PacketsInjected
+	ctrls[0].Stop()
+	m.Detach(ctrls[0])
+	conns[1].Close()
+	<-ctrls[1].Done()
+	if got := m.Stats().PacketsInjected; got < total {
+		t.Errorf("aggregate PacketsInjected went from %d to %d after removing controllers", total, got)
+	}
+	if m.Len() != n-2 {
+		t.Errorf("Len() = %d after Detach and Close, want %d", m.Len(), n-2)
+	}
+}
+
+func TestFillGhostBurstRange(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	received := make(chan int, 1)
+	go func() {
+		n, _ := io.Copy(io.Discard, client)
+		received <- int(n)
+	}()
+
+	var mu sync.Mutex
+	var times []time.Time
+	lengths := make(map[int]bool)
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen: 1, MaxLen: 1000,
+		Interval: 100 * time.Millisecond,
+		BurstMin: 3, BurstMax: 6,
+		OnInject: func(m InjectMeta) {
+			mu.Lock()
+			times = append(times, time.Now())
+			lengths[m.PlaintextLen] = true
+			mu.Unlock()
+		},
+	})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(450 * time.Millisecond)
+	fg.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	var bursts []int
+	size := 1
+	for i := 1; i < len(times); i++ {
+		if times[i].Sub(times[i-1]) > 50*time.Millisecond {
+			bursts = append(bursts, size)
+			size = 0
+		}
+		size++
+	}
+	if len(bursts) < 3 {
+		t.Fatalf("bursts %v, want at least 3 complete bursts", bursts)
+	}
+	for _, n := range bursts {
+		if n < 3 || n > 6 {
+			t.Errorf("burst of %d back-to-back records, want 3-6 (bursts %v)", n, bursts)
+		}
+	}
+	if len(lengths) < 2 {
+		t.Error("records in a burst did not sample their lengths independently")
+	}
+	server.Close()
+	if n, want := <-received, int(fg.Stats().BytesInjectedPlaintext); n != want {
+		t.Errorf("peer received %d bytes, want %d", n, want)
+	}
+
+	for _, cfg := range []FillGhostConfig{
+		{BurstMin: 4, BurstMax: 2},
+		{BurstMax: 2, Burst: FillGhostBurst{BurstSize: 3}},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+}
+
+func TestFillGhostStopTimeout(t *testing.T) {
+	sc, cc := fillGhostTCPPair(t)
+	slow := &fillGhostSlowConn{Conn: sc}
+	server, client := fillGhostStdHandshake(t, slow, cc)
+	go io.Copy(io.Discard, client)
+
+	// 每条填充记录超过 1000 字节，写操作都会卡住 300ms
+	atomic.StoreInt64(&slow.delay, int64(300*time.Millisecond))
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 2000, MaxLen: 2000})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := fg.StopTimeout(20 * time.Millisecond); err != ErrFillGhostStopTimeout {
+		t.Fatalf("StopTimeout = %v, want ErrFillGhostStopTimeout", err)
+	}
+	if err := fg.Start(); err == nil {
+		t.Fatal("Start succeeded while the previous loop was still blocked")
+	}
+	atomic.StoreInt64(&slow.delay, 0)
+	select {
+	case <-fg.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("loop did not exit once the write returned")
+	}
+	fg.Stop() // 已退出，立即返回
+
+	if err := fg.Start(); err != nil {
+		t.Fatalf("Start after the blocked loop exited: %v", err)
+	}
+	if err := fg.StopTimeout(5 * time.Second); err != nil {
+		t.Errorf("StopTimeout on a healthy connection = %v", err)
+	}
+}
+
+// BenchmarkFillGhostSleep 衡量注入循环每个间隔的等待开销，复用定时器后不应产生分配
+func BenchmarkFillGhostSleep(b *testing.B) {
+	stopCh := make(chan struct{})
+	ctx := context.Background()
+	run := fillGhostRun{clock: systemClock{}}
+	defer run.stopTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if !run.sleep(ctx, stopCh, time.Nanosecond) {
+			b.Fatal("sleep interrupted")
+		}
+	}
+}
+
+func TestFillGhostSleepReusesTimer(t *testing.T) {
+	stopCh := make(chan struct{})
+	ctx := context.Background()
+	run := fillGhostRun{clock: systemClock{}}
+	defer run.stopTimer()
+	allocs := testing.AllocsPerRun(100, func() { run.sleep(ctx, stopCh, time.Nanosecond) })
+	if allocs != 0 {
+		t.Errorf("sleep allocates %v times per call, want 0", allocs)
+	}
+
+	// 被停止打断后，定时器不能在下一次 sleep 中提前返回
+	close(stopCh)
+	if run.sleep(ctx, stopCh, time.Hour) {
+		t.Fatal("sleep ignored stop")
+	}
+	start := time.Now()
+	if !run.sleep(ctx, make(chan struct{}), 20*time.Millisecond) {
+		t.Fatal("sleep interrupted")
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("sleep returned after %v, want at least 20ms", d)
+	}
+}
+
+// fillGhostCountingClock 统计 NewTimer 调用次数的系统时钟
+type fillGhostCountingClock struct {
+	systemClock
+	timers int32
+}
+
+func (c *fillGhostCountingClock) NewTimer(d time.Duration) FillGhostTimer {
+	atomic.AddInt32(&c.timers, 1)
+	return c.systemClock.NewTimer(d)
+}
+
+func TestFillGhostLoopTimerCount(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	// InitialDelay、逐条间隔、突发间隔与 MaxPacketsPerSec 的等待共用一个定时器，Duration 另占一个
+	for _, tc := range []struct {
+		name string
+		cfg  FillGhostConfig
+		want int32
+	}{
+		{"Interval", FillGhostConfig{InitialDelay: time.Millisecond, Interval: time.Millisecond, Jitter: time.Millisecond / 2}, 1},
+		{"Burst", FillGhostConfig{Burst: FillGhostBurst{BurstSize: 5, BurstInterval: time.Millisecond, Jitter: time.Millisecond}}, 1},
+		{"MaxPacketsPerSec", FillGhostConfig{BurstMin: 2, BurstMax: 4, MaxPacketsPerSec: 2000}, 1},
+		{"Duration", FillGhostConfig{Interval: time.Millisecond, Duration: time.Hour}, 2},
+	} {
+		clk := new(fillGhostCountingClock)
+		cfg := tc.cfg
+		cfg.MinLen, cfg.MaxLen, cfg.MaxPackets, cfg.Clock = 10, 10, 50, clk
+		fg := NewFillGhostController(server, cfg)
+		if err := fg.Start(); err != nil {
+			t.Fatal(err)
+		}
+		if err := fg.Wait(); err != ErrFillGhostPacketLimit {
+			t.Fatalf("%s: Wait() = %v, want ErrFillGhostPacketLimit", tc.name, err)
+		}
+		if n := atomic.LoadInt32(&clk.timers); n != tc.want {
+			t.Errorf("%s: %d timers created for 50 records, want %d", tc.name, n, tc.want)
+		}
+	}
+}
+
+// BenchmarkFillGhostLoop 经完整注入循环注入 b.N 条记录，报告每条记录的分配次数
+func BenchmarkFillGhostLoop(b *testing.B) {
+	server, client := fillGhostStdPair(b)
+	go io.Copy(io.Discard, client)
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen: 1000, MaxLen: 1400,
+		Burst:      FillGhostBurst{BurstSize: 1000},
+		MaxPackets: uint64(b.N),
+	})
+	b.ReportAllocs()
+	b.ResetTimer()
+	if err := fg.Start(); err != nil {
+		b.Fatal(err)
+	}
+	if err := fg.Wait(); err != ErrFillGhostPacketLimit {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkFillGhostInject(b *testing.B) {
+	for _, tc := range []struct {
+		name   string
+		vers   uint16
+		suites []uint16
+	}{
+		{"TLS13", VersionTLS13, nil},
+		{"TLS12", VersionTLS12, []uint16{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			server, client := fillGhostStdVersionPair(b, tc.vers, tc.suites...)
+			go io.Copy(io.Discard, client)
+			fg := NewFillGhostController(server, FillGhostConfig{MinLen: 1000, MaxLen: 1400})
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := fg.injectOne(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestFillGhostInjectNow(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	received := make(chan int, 1)
+	go func() {
+		n, _ := io.Copy(io.Discard, client)
+		received <- int(n)
+	}()
+
+	fg := NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 500, Interval: time.Millisecond})
+	if n, err := fg.InjectNow(5); n != 5 || err != nil {
+		t.Fatalf("InjectNow while stopped = %d, %v", n, err)
+	}
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if n, err := fg.InjectNow(50); n != 50 || err != nil {
+				t.Errorf("InjectNow = %d, %v", n, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if _, err := server.Write([]byte("real")); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	fg.Stop()
+	if got := fg.Stats().PacketsInjected; got < 5+4*50 {
+		t.Errorf("%d packets injected, want at least %d", got, 5+4*50)
+	}
+	capped := NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 10, MaxTotalBytes: 1})
+	if n, err := capped.InjectNow(3); n != 0 || err != ErrFillGhostBudgetExhausted {
+		t.Errorf("InjectNow over budget = %d, %v", n, err)
+	}
+
+	var fe *FillGhostError
+	broken := NewFillGhostController(server, FillGhostConfig{MinLen: 10, MaxLen: 10, Rand: bytes.NewReader(nil)})
+	if n, err := broken.InjectNow(1); n != 0 || !errors.As(err, &fe) || fe.Op != "rand" {
+		t.Errorf("InjectNow with a failing Rand = %d, %v, want a rand *FillGhostError", n, err)
+	}
+
+	server.Close()
+	if n, want := <-received, int(fg.Stats().BytesInjectedPlaintext)+4*20*4; n != want {
+		t.Errorf("peer received %d bytes, want %d", n, want)
+	}
+	if n, err := fg.InjectNow(1); n != 0 || !errors.Is(err, ErrFillGhostClosed) {
+		t.Errorf("InjectNow after Close = %d, %v, want ErrFillGhostClosed", n, err)
+	}
+
+	sc, cc := fillGhostTCPPair(t)
+	defer cc.Close()
+	early := NewFillGhostController(Server(sc, &Config{}), FillGhostConfig{MinLen: 10, MaxLen: 10})
+	if n, err := early.InjectNow(1); n != 0 || err != ErrFillGhostNoAEAD {
+		t.Errorf("InjectNow before the handshake = %d, %v, want ErrFillGhostNoAEAD", n, err)
+	}
+}
+
+func TestFillGhostSchedule(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	schedule := []time.Duration{10 * time.Millisecond, 30 * time.Millisecond, 35 * time.Millisecond, 80 * time.Millisecond}
+	var mu sync.Mutex
+	var times []time.Time
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen: 10, MaxLen: 10,
+		Schedule: schedule,
+		OnInject: func(InjectMeta) {
+			mu.Lock()
+			times = append(times, time.Now())
+			mu.Unlock()
+		},
+	})
+	start := time.Now()
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fg.Wait(); err != ErrFillGhostScheduleDone {
+		t.Fatalf("Wait() = %v, want ErrFillGhostScheduleDone", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(times) != len(schedule) {
+		t.Fatalf("%d records injected, want %d", len(times), len(schedule))
+	}
+	for i, off := range schedule {
+		if got := times[i].Sub(start); got < off || got > off+20*time.Millisecond {
+			t.Errorf("record %d injected at %v, want about %v", i, got, off)
+		}
+	}
+
+	for _, bad := range [][]time.Duration{
+		{-time.Millisecond},
+		{20 * time.Millisecond, 10 * time.Millisecond},
+		{10 * time.Millisecond, 10 * time.Millisecond},
+	} {
+		cfg := FillGhostConfig{Schedule: bad}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Schedule %v accepted", bad)
+		}
+	}
+}
+
+func TestBuildGhostRecord(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 16)
+	chachaKey := bytes.Repeat([]byte{0x42}, 32)
+	seq := [8]byte{0, 0, 0, 0, 0, 0, 1, 2}
+	payload := []byte("known ghost payload")
+
+	for _, tc := range []struct {
+		name     string
+		vers     uint16
+		aead     func() cipher.AEAD
+		explicit int
+		recVers  [2]byte
+	}{
+		{"TLS13-AES-GCM", VersionTLS13, func() cipher.AEAD { return aeadAESGCMTLS13(key, make([]byte, 12)) }, 0, [2]byte{3, 3}},
+		{"TLS13-ChaCha20", VersionTLS13, func() cipher.AEAD { return aeadChaCha20Poly1305(chachaKey, make([]byte, 12)) }, 0, [2]byte{3, 3}},
+		{"TLS13-spoofed-version", VersionTLS13, func() cipher.AEAD { return aeadAESGCMTLS13(key, make([]byte, 12)) }, 0, [2]byte{3, 1}},
+		{"TLS12-AES-GCM", VersionTLS12, func() cipher.AEAD { return aeadAESGCM(key, make([]byte, 4)) }, 8, [2]byte{3, 3}},
+		{"TLS12-ChaCha20", VersionTLS12, func() cipher.AEAD { return aeadChaCha20Poly1305(chachaKey, make([]byte, 12)) }, 0, [2]byte{3, 3}},
+		{"TLS12-spoofed-version", VersionTLS12, func() cipher.AEAD { return aeadAESGCM(key, make([]byte, 4)) }, 8, [2]byte{3, 2}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := tc.aead()
+			orig := append([]byte(nil), payload...)
+			record, err := buildGhostRecord(nil, tc.vers, a, seq, payload, byte(recordTypeApplicationData), byte(recordTypeApplicationData), tc.recVers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(payload, orig) {
+				t.Error("buildGhostRecord modified the payload")
+			}
+			if record[0] != byte(recordTypeApplicationData) || record[1] != tc.recVers[0] || record[2] != tc.recVers[1] {
+				t.Errorf("header % x, want 17 % x", record[:3], tc.recVers)
+			}
+			if n := int(record[3])<<8 | int(record[4]); n != len(record)-recordHeaderLen {
+				t.Errorf("header length %d, record body is %d bytes", n, len(record)-recordHeaderLen)
+			}
+			if want := len(payload) + fillGhostRecordOverhead(tc.vers, a); len(record) != want {
+				t.Errorf("record is %d bytes, want %d", len(record), want)
+			}
+
+			var plain []byte
+			body := record[recordHeaderLen+tc.explicit:]
+			if tc.vers == VersionTLS13 {
+				plain, err = a.Open(nil, seq[:], body, record[:recordHeaderLen])
+				if err == nil {
+					if inner := plain[len(plain)-1]; inner != byte(recordTypeApplicationData) {
+						t.Errorf("inner content type %#02x", inner)
+					}
+					plain = plain[:len(plain)-1]
+				}
+			} else {
+				if tc.explicit > 0 && !bytes.Equal(record[recordHeaderLen:recordHeaderLen+tc.explicit], seq[:]) {
+					t.Error("explicit nonce is not the sequence number")
+				}
+				ad := append(seq[:], record[0], record[1], record[2], byte(len(payload)>>8), byte(len(payload)))
+				plain, err = a.Open(nil, seq[:], body, ad)
+			}
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			if !bytes.Equal(plain, payload) {
+				t.Errorf("round-tripped payload %q, want %q", plain, payload)
+			}
+		})
+	}
+
+	a := aeadAESGCMTLS13(key, make([]byte, 12))
+	if _, err := buildGhostRecord(nil, VersionTLS11, a, seq, payload, byte(recordTypeApplicationData), byte(recordTypeApplicationData), [2]byte{3, 2}); err != ErrFillGhostUnsupportedVersion {
+		t.Errorf("TLS 1.1: err = %v, want ErrFillGhostUnsupportedVersion", err)
+	}
+	if _, err := buildGhostRecord(nil, VersionTLS13, a, seq, make([]byte, maxPlaintext+1), byte(recordTypeApplicationData), byte(recordTypeApplicationData), [2]byte{3, 3}); err == nil {
+		t.Error("oversized payload accepted")
+	}
+}
+
+func TestFillGhostOpeningBurst(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+	clk := NewFakeFillGhostClock(time.Now())
+	fg := NewFillGhostController(server, FillGhostConfig{MaxLen: 10, Interval: time.Hour, OpeningBurst: 5, Clock: clk})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// 背靠背的开场突发结束后等待一个正常间隔
+	clk.BlockUntil(1)
+	if n := fg.Stats().PacketsInjected; n != 5 {
+		t.Fatalf("%d records after the opening burst, want 5", n)
+	}
+	at, ok := fg.NextInjectAt()
+	if !ok || at.Sub(clk.Now()) != time.Hour {
+		t.Fatalf("wait after the opening burst = %v (%v), want Interval", at.Sub(clk.Now()), ok)
+	}
+	clk.Advance(time.Hour)
+	clk.BlockUntil(1)
+	if n := fg.Stats().PacketsInjected; n != 6 {
+		t.Errorf("%d records after one interval, want 6", n)
+	}
+	fg.Stop()
+
+	// 带抖动的突发中途 Stop，循环及时退出且不再注入
+	clk = NewFakeFillGhostClock(time.Now())
+	fg = NewFillGhostController(server, FillGhostConfig{MaxLen: 10, Interval: time.Hour, OpeningBurst: 100, OpeningBurstJitter: time.Second, Clock: clk})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	clk.BlockUntil(1)
+	fg.Stop()
+	select {
+	case <-fg.Done():
+	case <-time.After(time.Second):
+		t.Fatal("loop did not exit after Stop during the opening burst")
+	}
+	if n := fg.Stats().PacketsInjected; n != 1 {
+		t.Errorf("%d records injected, want 1 before the first jittered gap", n)
+	}
+}
+
+func TestFillGhostInjectLog(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	var buf bytes.Buffer
+	off := NewFillGhostController(server, FillGhostConfig{MaxLen: 10})
+	if _, err := off.InjectNow(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := off.DumpJSON(&buf); err != nil || strings.TrimSpace(buf.String()) != "[]" {
+		t.Errorf("DumpJSON with the log disabled = %q, %v; want []", buf.String(), err)
+	}
+
+	// 依次注入 10、20、（否决一次）、40 字节，容量 3 时只保留最后三项
+	lengths := []int{10, 20, 0, 40}
+	next, proposed := 0, 0
+	var metas []InjectMeta
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MaxLen:        64,
+		InjectLogSize: 3,
+		OnBeforeInject: func(l int) (int, bool) {
+			n := lengths[next]
+			next++
+			if n == 0 {
+				proposed = l
+				return 0, false
+			}
+			return n, true
+		},
+		OnInject: func(m InjectMeta) { metas = append(metas, m) },
+	})
+	for i := 0; i < len(lengths); i++ {
+		if _, err := fg.InjectNow(1); err != nil && !errors.Is(err, ErrFillGhostVetoed) {
+			t.Fatal(err)
+		}
+	}
+	if len(metas) != 3 {
+		t.Fatalf("%d records injected, want 3", len(metas))
+	}
+	want := []InjectLogEntry{
+		{Seq: metas[1].Seq, PlaintextLen: 20, WireLen: metas[1].WireLen},
+		{PlaintextLen: proposed, Skipped: true}, // 否决时记录采样得到的长度
+		{Seq: metas[2].Seq, PlaintextLen: 40, WireLen: metas[2].WireLen},
+	}
+	buf.Reset()
+	if err := fg.DumpJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var got []InjectLogEntry
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("DumpJSON output %q: %v", buf.String(), err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("DumpJSON has %d entries, want %d: %s", len(got), len(want), buf.String())
+	}
+	for i := range want {
+		if got[i].Time.IsZero() {
+			t.Errorf("entry %d has zero time", i)
+		}
+		got[i].Time = time.Time{}
+		if got[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if !strings.Contains(buf.String(), `"plaintext_len":40`) {
+		t.Errorf("DumpJSON output lacks snake_case keys: %s", buf.String())
+	}
+}
+
+// fillGhostTestMetrics 记录 FillGhostMetrics 调用的测试钩子
+type fillGhostTestMetrics struct {
+	mu                     sync.Mutex
+	packets, bytes, errors int
+	intervals              []time.Duration
+}
+
+func (m *fillGhostTestMetrics) IncPackets() { m.mu.Lock(); m.packets++; m.mu.Unlock() }
+
+func (m *fillGhostTestMetrics) AddBytes(n int) { m.mu.Lock(); m.bytes += n; m.mu.Unlock() }
+
+func (m *fillGhostTestMetrics) IncErrors() { m.mu.Lock(); m.errors++; m.mu.Unlock() }
+
+func (m *fillGhostTestMetrics) ObserveInterval(d time.Duration) {
+	m.mu.Lock()
+	m.intervals = append(m.intervals, d)
+	m.mu.Unlock()
+}
+
+func (m *fillGhostTestMetrics) snapshot() (packets, bytes, errors int, intervals []time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.packets, m.bytes, m.errors, append([]time.Duration(nil), m.intervals...)
+}
+
+// fillGhostPausingMetrics 在 IncPackets 中暂停控制器
+type fillGhostPausingMetrics struct {
+	fillGhostTestMetrics
+	fg *FillGhostController
+}
+
+func (m *fillGhostPausingMetrics) IncPackets() { m.fg.Pause() }
+
+func TestFillGhostMetrics(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	// 每条注入的记录恰好计一次，字节数与 Stats 一致；失败与跳过分别计入或不计入 errors
+	m := new(fillGhostTestMetrics)
+	var injected int
+	bad := false
+	fg := NewFillGhostController(server, FillGhostConfig{
+		MinLen:   1,
+		MaxLen:   100,
+		Metrics:  m,
+		OnInject: func(InjectMeta) { injected++ },
+		OnBeforeInject: func(n int) (int, bool) {
+			if bad {
+				return -1, true
+			}
+			return n, true
+		},
+	})
+	if _, err := fg.InjectNow(7); err != nil {
+		t.Fatal(err)
+	}
+	bad = true
+	if _, err := fg.InjectNow(1); err == nil {
+		t.Fatal("InjectNow with an invalid override succeeded")
+	}
+	packets, bytes, errs, _ := m.snapshot()
+	st := fg.Stats()
+	if packets != 7 || packets != injected || uint64(packets) != st.PacketsInjected {
+		t.Errorf("IncPackets called %d times, want 7 (OnInject %d, Stats %d)", packets, injected, st.PacketsInjected)
+	}
+	if uint64(bytes) != st.BytesOnWire {
+		t.Errorf("AddBytes total %d, want Stats().BytesOnWire %d", bytes, st.BytesOnWire)
+	}
+	if errs != 1 || uint64(errs) != st.Errors {
+		t.Errorf("IncErrors called %d times, want 1 (Stats %d)", errs, st.Errors)
+	}
+
+	// 方法在 injectMu 之外调用，循环中由其调用 Pause 不会死锁
+	pm := &fillGhostPausingMetrics{}
+	pfg := NewFillGhostController(server, FillGhostConfig{MaxLen: 10, Interval: time.Millisecond, Metrics: pm})
+	pm.fg = pfg
+	if err := pfg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); !pfg.isPaused(); {
+		if time.Now().After(deadline) {
+			t.Fatal("Pause from Metrics.IncPackets never took effect")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := pfg.StopTimeout(5 * time.Second); err != nil {
+		t.Fatalf("Stop after Pause from Metrics.IncPackets: %v", err)
+	}
+
+	// 循环中每次等待间隔观测一次
+	m = new(fillGhostTestMetrics)
+	clk := NewFakeFillGhostClock(time.Now())
+	fg = NewFillGhostController(server, FillGhostConfig{MaxLen: 10, Interval: time.Hour, Clock: clk, Metrics: m})
+	if err := fg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fg.Stop()
+	clk.BlockUntil(1)
+	clk.Advance(time.Hour)
+	clk.BlockUntil(1)
+	packets, _, _, intervals := m.snapshot()
+	if packets != 2 || len(intervals) != 2 || intervals[0] != time.Hour || intervals[1] != time.Hour {
+		t.Errorf("after two ticks: %d packets, intervals %v; want 2 and [1h 1h]", packets, intervals)
+	}
+}
+
+func TestFillGhostLoopRandErrors(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	// 循环自身取随机数失败同样计入 Stats().Errors 与 Metrics；负载与长度不读随机源
+	fixed := func(n int) []byte { return make([]byte, n) }
+	for _, tc := range []struct {
+		name string
+		cfg  FillGhostConfig
+	}{
+		{"InitialDelayJitter", FillGhostConfig{InitialDelayJitter: time.Millisecond}},
+		{"burstSize", FillGhostConfig{BurstMin: 1, BurstMax: 3}},
+		{"nextInterval", FillGhostConfig{Interval: time.Millisecond, Jitter: time.Millisecond}},
+		{"Burst.Jitter", FillGhostConfig{Burst: FillGhostBurst{BurstSize: 3, BurstInterval: time.Millisecond, Jitter: time.Millisecond}}},
+		{"FragmentJitter", FillGhostConfig{MinLen: 20000, MaxLen: 20000, Fragment: true, FragmentJitter: 10}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := new(fillGhostTestMetrics)
+			cfg := tc.cfg
+			if cfg.MaxLen == 0 {
+				cfg.MinLen, cfg.MaxLen = 10, 10
+			}
+			cfg.PayloadFunc, cfg.Rand, cfg.Metrics = fixed, &fillGhostFlakyReader{fail: 1 << 30}, m
+			fg := NewFillGhostController(server, cfg)
+			if err := fg.Start(); err != nil {
+				t.Fatal(err)
+			}
+			var fgErr *FillGhostError
+			if err := fg.Wait(); !errors.As(err, &fgErr) || fgErr.Op != "rand" {
+				t.Fatalf("Wait() = %v, want a rand FillGhostError", err)
+			}
+			_, _, errs, _ := m.snapshot()
+			if st := fg.Stats(); st.Errors != 1 || errs != 1 {
+				t.Errorf("Stats().Errors = %d, IncErrors called %d times; want 1 and 1", st.Errors, errs)
+			}
+		})
+	}
+}
+
+func TestFillGhostPayloadLenStats(t *testing.T) {
+	server, client := fillGhostStdPair(t)
+	go io.Copy(io.Discard, client)
+
+	var st FillGhostStats
+	if avg := st.AvgPayloadLen(); avg != 0 {
+		t.Errorf("AvgPayloadLen with no records = %v, want 0", avg)
+	}
+	lengths := []int{10, 30, 50, 30}
+	next := 0
+	fg := NewFillGhostController(server, FillGhostConfig{MaxLen: 100, OnBeforeInject: func(int) (int, bool) {
+		n := lengths[next]
+		next++
+		return n, true
+	}})
+	for i, want := range []float64{10, 20, 30, 30} {
+		if _, err := fg.InjectNow(1); err != nil {
+			t.Fatal(err)
+		}
+		st = fg.Stats()
+		if st.LastPayloadLen != lengths[i] || st.AvgPayloadLen() != want {
+			t.Errorf("after %d records: last %d, avg %v; want %d, %v", i+1, st.LastPayloadLen, st.AvgPayloadLen(), lengths[i], want)
+		}
+	}
+	// 平均值按明文长度计算，不含线上开销
+	if wire := float64(st.BytesOnWire) / float64(st.PacketsInjected); wire <= st.AvgPayloadLen() {
+		t.Errorf("average wire length %v not above average payload length %v", wire, st.AvgPayloadLen())
+	}
+}
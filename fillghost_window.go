@@ -0,0 +1,67 @@
+package tls
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// fillGhostWindowPoll ActiveWindows 之外空闲时单次等待的上限，使 UpdateConfig 修改的时段
+// 与系统时间的跳变（夏令时、校时）在此时间内生效
+const fillGhostWindowPoll = time.Minute
+
+// FillGhostWindow 一天中允许注入的时段 [Start, End)，均为距当天零点的钟面时间偏移。
+// Start 须在 [0, 24h) 内，End 须在 (0, 24h] 内且不等于 Start；Start 大于 End 时跨越午夜，
+// 如 {22h, 6h} 表示晚上十点到次日早上六点
+type FillGhostWindow struct {
+	Start, End time.Duration
+}
+
+// validateWindows 检查 ActiveWindows 中每个时段的取值
+func validateWindows(windows []FillGhostWindow) error {
+	for _, w := range windows {
+		switch {
+		case w.Start < 0 || w.Start >= 24*time.Hour:
+			return fmt.Errorf("fillghost: window start %v is outside [0, 24h)", w.Start)
+		case w.End <= 0 || w.End > 24*time.Hour:
+			return fmt.Errorf("fillghost: window end %v is outside (0, 24h]", w.End)
+		case w.Start == w.End:
+			return errors.New("fillghost: window start and end are equal")
+		}
+	}
+	return nil
+}
+
+// untilWindow 返回距下一个时段开始的时间，now 已在某个时段内或未设置 ActiveWindows 时返回0
+func (cfg *FillGhostConfig) untilWindow(now time.Time) time.Duration {
+	if len(cfg.ActiveWindows) == 0 {
+		return 0
+	}
+	if cfg.WindowLocation != nil {
+		now = now.In(cfg.WindowLocation)
+	}
+	h, m, s := now.Clock()
+	tod := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second + time.Duration(now.Nanosecond())
+	next := 24 * time.Hour
+	for _, w := range cfg.ActiveWindows {
+		if w.contains(tod) {
+			return 0
+		}
+		d := w.Start - tod
+		if d < 0 {
+			d += 24 * time.Hour
+		}
+		if d < next {
+			next = d
+		}
+	}
+	return next
+}
+
+// contains 报告当天的钟面时间 tod 是否落在时段内
+func (w FillGhostWindow) contains(tod time.Duration) bool {
+	if w.Start < w.End {
+		return tod >= w.Start && tod < w.End
+	}
+	return tod >= w.Start || tod < w.End
+}
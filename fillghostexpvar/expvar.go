@@ -0,0 +1,48 @@
+// Package fillghostexpvar 提供 tls.FillGhostMetrics 基于 expvar 的参考实现，
+// 计数器随 expvar 出现在 /debug/vars 中。
+package fillghostexpvar
+
+import (
+	"expvar"
+	"time"
+
+	tls "github.com/FillGhost/Go"
+)
+
+// Metrics 以 expvar 计数器实现 tls.FillGhostMetrics，可在多个控制器之间共用
+type Metrics struct {
+	Packets       expvar.Int // 成功注入的记录数
+	Bytes         expvar.Int // 注入记录的线上字节数
+	Errors        expvar.Int // 注入失败次数
+	Intervals     expvar.Int // 等待间隔的次数
+	IntervalNanos expvar.Int // 等待间隔的总时长（纳秒），除以 Intervals 即平均间隔
+	LastInterval  expvar.Int // 最近一次等待间隔（纳秒）
+}
+
+var _ tls.FillGhostMetrics = (*Metrics)(nil)
+
+// New 构造 Metrics 并以 name 发布为一个 expvar.Map，键为 packets、bytes、errors、intervals、
+// interval_ns_total 与 last_interval_ns。与 expvar.Publish 相同，name 重复时 panic
+func New(name string) *Metrics {
+	m := new(Metrics)
+	vars := expvar.NewMap(name)
+	vars.Set("packets", &m.Packets)
+	vars.Set("bytes", &m.Bytes)
+	vars.Set("errors", &m.Errors)
+	vars.Set("intervals", &m.Intervals)
+	vars.Set("interval_ns_total", &m.IntervalNanos)
+	vars.Set("last_interval_ns", &m.LastInterval)
+	return m
+}
+
+func (m *Metrics) IncPackets() { m.Packets.Add(1) }
+
+func (m *Metrics) AddBytes(n int) { m.Bytes.Add(int64(n)) }
+
+func (m *Metrics) IncErrors() { m.Errors.Add(1) }
+
+func (m *Metrics) ObserveInterval(d time.Duration) {
+	m.Intervals.Add(1)
+	m.IntervalNanos.Add(int64(d))
+	m.LastInterval.Set(int64(d))
+}
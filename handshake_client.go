@@ -78,6 +78,7 @@ func (c *Conn) makeClientHello() (*clientHelloMsg, ecdheParameters, error) {
 		secureRenegotiationSupported: true,
 		alpnProtocols:                config.NextProtos,
 		supportedVersions:            supportedVersions,
+		fillGhost:                    config.NegotiateFillGhost,
 	}
 
 	if c.handshakes > 0 {
@@ -717,6 +718,12 @@ func (hs *clientHandshakeState) processServerHello() (bool, error) {
 	}
 	c.clientProtocol = hs.serverHello.alpnProtocol
 
+	if hs.serverHello.fillGhost && !hs.hello.fillGhost {
+		c.sendAlert(alertUnsupportedExtension)
+		return false, errors.New("tls: server advertised unrequested FillGhost support")
+	}
+	c.fillGhostNegotiated = hs.serverHello.fillGhost
+
 	c.scts = hs.serverHello.scts
 
 	if !hs.serverResumedSession() {
@@ -130,7 +130,8 @@ func (hs *clientHandshakeStateTLS13) checkServerHelloOrHRR() error {
 		hs.serverHello.secureRenegotiationSupported ||
 		len(hs.serverHello.secureRenegotiation) != 0 ||
 		len(hs.serverHello.alpnProtocol) != 0 ||
-		len(hs.serverHello.scts) != 0 {
+		len(hs.serverHello.scts) != 0 ||
+		hs.serverHello.fillGhost {
 		c.sendAlert(alertUnsupportedExtension)
 		return errors.New("tls: server sent a ServerHello extension forbidden in TLS 1.3")
 	}
@@ -402,6 +403,12 @@ func (hs *clientHandshakeStateTLS13) readServerParameters() error {
 	}
 	c.clientProtocol = encryptedExtensions.alpnProtocol
 
+	if encryptedExtensions.fillGhost && !hs.hello.fillGhost {
+		c.sendAlert(alertUnsupportedExtension)
+		return errors.New("tls: server advertised unrequested FillGhost support")
+	}
+	c.fillGhostNegotiated = encryptedExtensions.fillGhost
+
 	return nil
 }
 
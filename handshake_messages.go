@@ -92,6 +92,7 @@ type clientHelloMsg struct {
 	pskModes                         []uint8
 	pskIdentities                    []pskIdentity
 	pskBinders                       [][]byte
+	fillGhost                        bool // 声明支持 FillGhost 填充记录
 }
 
 func (m *clientHelloMsg) marshal() []byte {
@@ -257,6 +258,10 @@ func (m *clientHelloMsg) marshal() []byte {
 				b.AddUint16(extensionEarlyData)
 				b.AddUint16(0) // empty extension_data
 			}
+			if m.fillGhost {
+				b.AddUint16(extensionFillGhost)
+				b.AddUint16(0) // empty extension_data
+			}
 			if len(m.pskModes) > 0 {
 				// RFC 8446, Section 4.2.9
 				b.AddUint16(extensionPSKModes)
@@ -540,6 +545,8 @@ func (m *clientHelloMsg) unmarshal(data []byte) bool {
 		case extensionEarlyData:
 			// RFC 8446, Section 4.2.10
 			m.earlyData = true
+		case extensionFillGhost:
+			m.fillGhost = true
 		case extensionPSKModes:
 			// RFC 8446, Section 4.2.9
 			if !readUint8LengthPrefixed(&extData, &m.pskModes) {
@@ -606,6 +613,7 @@ type serverHelloMsg struct {
 	selectedIdentityPresent      bool
 	selectedIdentity             uint16
 	supportedPoints              []uint8
+	fillGhost                    bool // TLS 1.2 下确认 FillGhost 支持
 
 	// HelloRetryRequest extensions
 	cookie        []byte
@@ -715,6 +723,10 @@ func (m *serverHelloMsg) marshal() []byte {
 					})
 				})
 			}
+			if m.fillGhost {
+				b.AddUint16(extensionFillGhost)
+				b.AddUint16(0) // empty extension_data
+			}
 
 			extensionsPresent = len(b.BytesOrPanic()) > 2
 		})
@@ -763,6 +775,8 @@ func (m *serverHelloMsg) unmarshal(data []byte) bool {
 			m.ocspStapling = true
 		case extensionSessionTicket:
 			m.ticketSupported = true
+		case extensionFillGhost:
+			m.fillGhost = true
 		case extensionRenegotiationInfo:
 			if !readUint8LengthPrefixed(&extData, &m.secureRenegotiation) {
 				return false
@@ -841,6 +855,7 @@ func (m *serverHelloMsg) unmarshal(data []byte) bool {
 type encryptedExtensionsMsg struct {
 	raw          []byte
 	alpnProtocol string
+	fillGhost    bool // TLS 1.3 下确认 FillGhost 支持
 }
 
 func (m *encryptedExtensionsMsg) marshal() []byte {
@@ -862,6 +877,10 @@ func (m *encryptedExtensionsMsg) marshal() []byte {
 					})
 				})
 			}
+			if m.fillGhost {
+				b.AddUint16(extensionFillGhost)
+				b.AddUint16(0) // empty extension_data
+			}
 		})
 	})
 
@@ -899,6 +918,8 @@ func (m *encryptedExtensionsMsg) unmarshal(data []byte) bool {
 				return false
 			}
 			m.alpnProtocol = string(proto)
+		case extensionFillGhost:
+			m.fillGhost = true
 		default:
 			// Ignore unknown extensions.
 			continue
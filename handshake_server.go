@@ -225,6 +225,10 @@ func (hs *serverHandshakeState) processClientHello() error {
 	hs.hello.alpnProtocol = selectedProto
 	c.clientProtocol = selectedProto
 
+	// 仅在双方都开启 NegotiateFillGhost 时确认
+	hs.hello.fillGhost = hs.clientHello.fillGhost && c.config.NegotiateFillGhost
+	c.fillGhostNegotiated = hs.hello.fillGhost
+
 	hs.cert, err = c.config.getCertificate(clientHelloInfo(hs.ctx, c, hs.clientHello))
 	if err != nil {
 		if err == errNoCertificates {
@@ -462,7 +462,8 @@ func illegalClientHelloChange(ch, ch1 *clientHelloMsg) bool {
 		len(ch.supportedCurves) != len(ch1.supportedCurves) ||
 		len(ch.supportedSignatureAlgorithms) != len(ch1.supportedSignatureAlgorithms) ||
 		len(ch.supportedSignatureAlgorithmsCert) != len(ch1.supportedSignatureAlgorithmsCert) ||
-		len(ch.alpnProtocols) != len(ch1.alpnProtocols) {
+		len(ch.alpnProtocols) != len(ch1.alpnProtocols) ||
+		ch.fillGhost != ch1.fillGhost {
 		return true
 	}
 	for i := range ch.supportedVersions {
@@ -559,6 +560,10 @@ func (hs *serverHandshakeStateTLS13) sendServerParameters() error {
 	encryptedExtensions.alpnProtocol = selectedProto
 	c.clientProtocol = selectedProto
 
+	// 仅在双方都开启 NegotiateFillGhost 时确认
+	encryptedExtensions.fillGhost = hs.clientHello.fillGhost && c.config.NegotiateFillGhost
+	c.fillGhostNegotiated = encryptedExtensions.fillGhost
+
 	hs.transcript.Write(encryptedExtensions.marshal())
 	if _, err := c.writeRecord(recordTypeHandshake, encryptedExtensions.marshal()); err != nil {
 		return err
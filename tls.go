@@ -156,6 +156,8 @@ func dial(ctx context.Context, netDialer *net.Dialer, network, addr string, conf
 	conn := Client(rawConn, config)
 	if err := conn.HandshakeContext(ctx); err != nil {
 		rawConn.Close()
+		// 握手完成后上下文才被取消时 Config.FillGhost 的控制器可能已经启动
+		conn.fillGhostAutoWait()
 		return nil, err
 	}
 	return conn, nil